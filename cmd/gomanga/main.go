@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 
 	"github.com/akarakai/gomanga-tbot/pkg/logger"
 	"github.com/akarakai/gomanga-tbot/pkg/repository"
@@ -27,20 +31,48 @@ func main() {
 		logger.Log.Panicw("could not connect to the database", "err", err)
 	}
 
-	s, err := scraper.NewWeebCentralScraperDefault()
-	if err != nil {
-		logger.Log.Panicw("could not connect to the scraper", "err", err)
+	// playwright is the default, http does not need a browser
+	var s scraper.Scraper
+	switch backend := os.Getenv("SCRAPER_BACKEND"); backend {
+	case "", "playwright":
+		s, err = scraper.NewWeebCentralScraperPoolDefault(maxConcurrency())
+		if err != nil {
+			logger.Log.Panicw("could not connect to the scraper", "err", err)
+		}
+	case "http":
+		s = scraper.NewWeebCentralHttpScraperDefault()
+	default:
+		logger.Log.Panicw("SCRAPER_BACKEND must be playwright or http", "backend", backend)
 	}
+	logger.Log.Infow("weebcentral scraper created", "type", fmt.Sprintf("%T", s))
+
+	sources := scraper.NewRegistry()
+	sources.Register(scraper.WeebCentralSource, s, scraper.WeebCentralBaseURL)
+	sources.Register(scraper.MangaDexSource, scraper.NewMangaDexScraperDefault(), scraper.MangaDexBaseURL)
+	defer sources.Close()
 
 	tg, err := telegram.NewTelegramService(
 		telegramKey,
 		repo,
-		s,
+		sources,
 	)
 
 	if err != nil {
 		logger.Log.Panicw("could not create bot instance", "err", err)
 	}
 
-	tg.Start(context.Background())
+	// on shutdown the running scrapes are interrupted
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	tg.Start(ctx)
+}
+
+// maxConcurrency is the number of browser pages open at the same time, 2 if not set
+func maxConcurrency() int {
+	n, err := strconv.Atoi(os.Getenv("SCRAPER_MAX_CONCURRENCY"))
+	if err != nil || n < 1 {
+		return 2
+	}
+	return n
 }
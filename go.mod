@@ -1,6 +1,6 @@
 module github.com/akarakai/gomanga-tbot
 
-go 1.24
+go 1.24.0
 
 require (
 	codeberg.org/go-pdf/fpdf v0.11.1
@@ -9,6 +9,8 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.32
 	github.com/playwright-community/playwright-go v0.5200.0
 	go.uber.org/zap v1.27.0
+	golang.org/x/image v0.25.0
+	golang.org/x/net v0.50.0
 )
 
 require (
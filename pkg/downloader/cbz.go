@@ -0,0 +1,145 @@
+package downloader
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+)
+
+// ComicInfo is the metadata file read by comic readers like Komga, Kavita and Mihon.
+// Only the fields known by the bot are written, see https://anansi-project.github.io/docs/comicinfo
+type ComicInfo struct {
+	XMLName     xml.Name `xml:"ComicInfo"`
+	Title       string   `xml:"Title,omitempty"`
+	Series      string   `xml:"Series,omitempty"`
+	Number      string   `xml:"Number,omitempty"`
+	Summary     string   `xml:"Summary,omitempty"`
+	Year        int      `xml:"Year,omitempty"`
+	Month       int      `xml:"Month,omitempty"`
+	Day         int      `xml:"Day,omitempty"`
+	Writer      string   `xml:"Writer,omitempty"`
+	Genre       string   `xml:"Genre,omitempty"`
+	Web         string   `xml:"Web,omitempty"`
+	PageCount   int      `xml:"PageCount,omitempty"`
+	Manga       string   `xml:"Manga,omitempty"`
+	LanguageISO string   `xml:"LanguageISO,omitempty"`
+}
+
+// values of ComicInfo.Manga, they tell the readers the direction of the pages
+const (
+	MangaRightToLeft = "YesAndRightToLeft"
+	MangaLeftToRight = "Yes" // like the webtoons, read from the top
+)
+
+// NewComicInfo fills the metadata of a chapter of the manga. The pages of the webtoons are
+// read left to right, the ones of the mangas right to left
+func NewComicInfo(manga model.Manga, ch model.Chapter, webtoon bool) ComicInfo {
+	info := ComicInfo{
+		Title:       ch.Title,
+		Series:      manga.Title,
+		Summary:     manga.Description,
+		Writer:      strings.Join(manga.Authors, ", "),
+		Genre:       strings.Join(manga.Genres, ", "),
+		Web:         ch.Url,
+		Manga:       MangaRightToLeft,
+		LanguageISO: ch.Language,
+	}
+	if webtoon {
+		info.Manga = MangaLeftToRight
+	}
+	if ch.Number != model.NoChapterNumber {
+		info.Number = model.FormatChapterNumber(ch.Number)
+	}
+	if !ch.ReleasedAt.IsZero() {
+		info.Year = ch.ReleasedAt.Year()
+		info.Month = int(ch.ReleasedAt.Month())
+		info.Day = ch.ReleasedAt.Day()
+	}
+	return info
+}
+
+// DownloadCbzFromImageSrcs downloads image URLs and zips them in order, with a ComicInfo.xml
+func DownloadCbzFromImageSrcs(imgSrcs []string, info ComicInfo) ([]byte, error) {
+	return NewDownloaderDefault().DownloadCbz(context.Background(), imgSrcs, info)
+}
+
+// DownloadCbz downloads image URLs and zips them in order, with a ComicInfo.xml.
+// The images are stored as they are, without converting them
+func (d *Downloader) DownloadCbz(ctx context.Context, imgSrcs []string, info ComicInfo) ([]byte, error) {
+	if len(imgSrcs) == 0 {
+		return nil, fmt.Errorf("no image sources provided")
+	}
+	images, err := d.pages(ctx, imgSrcs)
+	if err != nil {
+		return nil, err
+	}
+	return cbzFromImages(images, info)
+}
+
+// cbzFromImages zips the downloaded images, with a ComicInfo.xml
+func cbzFromImages(images [][]byte, info ComicInfo) ([]byte, error) {
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	// 001.jpg, 002.jpg... sorting by name keeps the order of the pages
+	digits := max(3, len(fmt.Sprint(len(images))))
+	for i, imgData := range images {
+		ext := imageExtension(imgData)
+		if ext == "" {
+			return nil, fmt.Errorf("unsupported or unknown image type for image %d", i+1)
+		}
+
+		// images are already compressed, deflate would only waste time
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:   fmt.Sprintf("%0*d%s", digits, i+1, ext),
+			Method: zip.Store,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to add image %d: %v", i+1, err)
+		}
+		if _, err := w.Write(imgData); err != nil {
+			return nil, fmt.Errorf("failed to write image %d: %v", i+1, err)
+		}
+	}
+
+	info.PageCount = len(images)
+	comicInfo, err := xml.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ComicInfo.xml: %v", err)
+	}
+	if err := writeZipFile(zw, "ComicInfo.xml", xml.Header+string(comicInfo)); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to generate CBZ: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// imageExtension detects the extension of the image from its content
+func imageExtension(data []byte) string {
+	switch http.DetectContentType(data) {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	}
+	// net/http does not know avif, it is an ISO media file with the avif brand
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" && (string(data[8:12]) == "avif" || string(data[8:12]) == "avis") {
+		return ".avif"
+	}
+	// html or text, like an error page
+	return ""
+}
@@ -0,0 +1,142 @@
+package downloader
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+)
+
+// newImageServer serves a small png for every path, /missing.png is not found
+func newImageServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 4, 6))
+	img.Set(1, 1, color.RGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(buf.Bytes())
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDownloadCbzFromImageSrcs(t *testing.T) {
+	srv := newImageServer(t)
+	var imgSrcs []string
+	for i := 1; i <= 3; i++ {
+		imgSrcs = append(imgSrcs, fmt.Sprintf("%s/page-%d.png", srv.URL, i))
+	}
+
+	manga := model.Manga{
+		Title:       "Berserk",
+		Authors:     []string{"Kentaro Miura"},
+		Genres:      []string{"Action", "Horror"},
+		Description: "Guts, the black swordsman",
+	}
+	ch := model.Chapter{
+		Title:      "Chapter 10.5",
+		Url:        "https://example.com/berserk/ch10.5",
+		Number:     10.5,
+		ReleasedAt: time.Date(2024, time.March, 7, 0, 0, 0, 0, time.UTC),
+		Language:   "en",
+	}
+
+	cbz, err := DownloadCbzFromImageSrcs(imgSrcs, NewComicInfo(manga, ch, false))
+	if err != nil {
+		t.Fatalf("DownloadCbzFromImageSrcs: %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(cbz), int64(len(cbz)))
+	if err != nil {
+		t.Fatalf("zip.NewReader: %v", err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if got, want := fmt.Sprint(names), "[001.png 002.png 003.png ComicInfo.xml]"; got != want {
+		t.Fatalf("files = %s, want %s", got, want)
+	}
+
+	f, err := zr.Open("ComicInfo.xml")
+	if err != nil {
+		t.Fatalf("open ComicInfo.xml: %v", err)
+	}
+	defer f.Close()
+	data, _ := io.ReadAll(f)
+	var info ComicInfo
+	if err := xml.Unmarshal(data, &info); err != nil {
+		t.Fatalf("xml.Unmarshal: %v", err)
+	}
+
+	want := ComicInfo{
+		XMLName:     xml.Name{Local: "ComicInfo"},
+		Title:       "Chapter 10.5",
+		Series:      "Berserk",
+		Number:      "10.5",
+		Summary:     "Guts, the black swordsman",
+		Year:        2024,
+		Month:       3,
+		Day:         7,
+		Writer:      "Kentaro Miura",
+		Genre:       "Action, Horror",
+		Web:         "https://example.com/berserk/ch10.5",
+		PageCount:   3,
+		Manga:       MangaRightToLeft,
+		LanguageISO: "en",
+	}
+	if info != want {
+		t.Errorf("ComicInfo = %+v, want %+v", info, want)
+	}
+}
+
+func TestNewComicInfoWebtoon(t *testing.T) {
+	ch := model.Chapter{Title: "Episode 1", Number: 1}
+	if info := NewComicInfo(model.Manga{Title: "Tower of God"}, ch, true); info.Manga != MangaLeftToRight {
+		t.Errorf("webtoon read as %q, want %q", info.Manga, MangaLeftToRight)
+	}
+}
+
+func TestDownloadCbzUnknownImage(t *testing.T) {
+	srv := newImageServer(t)
+	if _, err := DownloadCbzFromImageSrcs([]string{srv.URL + "/missing.png"}, ComicInfo{}); err == nil {
+		t.Error("a page which is not an image should fail")
+	}
+	if _, err := DownloadCbzFromImageSrcs(nil, ComicInfo{}); err == nil {
+		t.Error("a chapter without pages should fail")
+	}
+}
+
+func TestCbzAvifPage(t *testing.T) {
+	// net/http does not sniff avif, the page keeps its type anyway
+	avif := append([]byte{0, 0, 0, 0x1c}, []byte("ftypavif\x00\x00\x00\x00")...)
+	cbz, err := cbzFromImages([][]byte{avif}, ComicInfo{})
+	if err != nil {
+		t.Fatalf("cbzFromImages: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(cbz), int64(len(cbz)))
+	if err != nil {
+		t.Fatalf("zip.NewReader: %v", err)
+	}
+	if name := zr.File[0].Name; name != "001.avif" {
+		t.Errorf("page = %s, want 001.avif", name)
+	}
+}
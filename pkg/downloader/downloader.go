@@ -2,12 +2,18 @@ package downloader
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"image"
-	"io"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
 	"net/http"
 
 	"codeberg.org/go-pdf/fpdf"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
 )
 
 // DPI used for converting pixels to mm
@@ -15,9 +21,23 @@ const dpi = 96.0
 
 // DownloadPdfFromImageSrcs downloads image URLs and creates a PDF with each image as a full-page
 func DownloadPdfFromImageSrcs(imgSrcs []string, title string) ([]byte, error) {
+	return NewDownloaderDefault().DownloadPdf(context.Background(), imgSrcs, title)
+}
+
+// DownloadPdf downloads image URLs and creates a PDF with each image as a full-page
+func (d *Downloader) DownloadPdf(ctx context.Context, imgSrcs []string, title string) ([]byte, error) {
 	if len(imgSrcs) == 0 {
 		return nil, fmt.Errorf("no image sources provided")
 	}
+	images, err := d.pages(ctx, imgSrcs)
+	if err != nil {
+		return nil, err
+	}
+	return pdfFromImages(images, title)
+}
+
+// pdfFromImages creates a PDF with each image as a full-page
+func pdfFromImages(images [][]byte, title string) ([]byte, error) {
 
 	pdf := fpdf.NewCustom(&fpdf.InitType{
 		UnitStr: "mm",
@@ -25,22 +45,11 @@ func DownloadPdfFromImageSrcs(imgSrcs []string, title string) ([]byte, error) {
 	})
 	pdf.SetTitle(title, false)
 
-	for i, src := range imgSrcs {
-		// Download image
-		resp, err := http.Get(src)
+	for i, imgData := range images {
+		// Detect type and dimensions, the formats fpdf does not know are converted
+		imgData, imgType, err := pdfImage(imgData)
 		if err != nil {
-			return nil, fmt.Errorf("error fetching image %d: %v", i+1, err)
-		}
-		imgData, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			return nil, fmt.Errorf("failed to read image %d: %v", i+1, err)
-		}
-
-		// Detect type and dimensions
-		imgType := detectImageType(imgData)
-		if imgType == "" {
-			return nil, fmt.Errorf("unsupported or unknown image type for image %d", i+1)
+			return nil, fmt.Errorf("unsupported image %d: %v", i+1, err)
 		}
 
 		cfg, _, err := image.DecodeConfig(bytes.NewReader(imgData))
@@ -77,6 +86,46 @@ func DownloadPdfFromImageSrcs(imgSrcs []string, title string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// pdfImage returns the image in a format which can be embedded in the pdf, with its fpdf type.
+// JPEG and PNG are kept as they are, the other formats are decoded and converted:
+// to JPEG when opaque, like most of the pages, to PNG when they have transparency
+func pdfImage(data []byte) ([]byte, string, error) {
+	if imgType := detectImageType(data); imgType != "" {
+		return data, imgType, nil
+	}
+	if isAvif(data) {
+		// there is no avif decoder for go, the Accept header asks the CDNs for other formats
+		return nil, "", fmt.Errorf("avif cannot be converted")
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("unknown image type: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+		format, data = "JPG", buf.Bytes()
+	} else {
+		err = png.Encode(&buf, img)
+		format, data = "PNG", buf.Bytes()
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to convert image: %v", err)
+	}
+	return data, format, nil
+}
+
+// avif files start with a ftyp box of brand avif, or avis for the animated ones
+func isAvif(data []byte) bool {
+	if len(data) < 12 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+	brand := string(data[8:12])
+	return brand == "avif" || brand == "avis"
+}
+
 // detectImageType detects MIME type using content sniffing
 func detectImageType(data []byte) string {
 	switch http.DetectContentType(data) {
@@ -1,6 +1,8 @@
 package downloader
 
 import (
+	"bytes"
+	"context"
 	"testing"
 )
 
@@ -33,3 +35,14 @@ func TestDownloadPdfFromImageSrcs(t *testing.T) {
 		t.Errorf("there was an error: %s", err)
 	}
 }
+
+func TestDownloadPdf(t *testing.T) {
+	srv := newImageServer(t)
+	pdf, err := NewDownloaderDefault().DownloadPdf(context.Background(), []string{srv.URL + "/1.png", srv.URL + "/2.png"}, "title")
+	if err != nil {
+		t.Fatalf("DownloadPdf: %v", err)
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF")) {
+		t.Errorf("not a pdf: %q", pdf[:min(len(pdf), 10)])
+	}
+}
@@ -0,0 +1,235 @@
+package downloader
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"strings"
+	"time"
+)
+
+// EPUB 3 fixed layout: every page is an xhtml with the size of its image, read right to left
+// unless the ComicInfo says otherwise.
+// E-readers like Kobo show a page per screen instead of scaling a long pdf page
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+const epubPage = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+  <title>%s</title>
+  <meta name="viewport" content="width=%d, height=%d"/>
+  <style>html, body { margin: 0; padding: 0; } img { display: block; width: 100%%; height: 100%%; }</style>
+</head>
+<body>
+  <img src="%s" alt="%s"/>
+</body>
+</html>
+`
+
+const epubNav = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>%s</title></head>
+<body>
+  <nav epub:type="toc" id="toc">
+    <ol><li><a href="page-%s.xhtml">%s</a></li></ol>
+  </nav>
+</body>
+</html>
+`
+
+// epubImage is a page of the chapter already downloaded
+type epubImage struct {
+	name          string // page-001
+	ext           string
+	width, height int
+}
+
+// DownloadEpubFromImageSrcs downloads image URLs and creates a fixed layout EPUB 3, a page for each image
+func DownloadEpubFromImageSrcs(imgSrcs []string, info ComicInfo) ([]byte, error) {
+	return NewDownloaderDefault().DownloadEpub(context.Background(), imgSrcs, info)
+}
+
+// DownloadEpub downloads image URLs and creates a fixed layout EPUB 3, a page for each image
+func (d *Downloader) DownloadEpub(ctx context.Context, imgSrcs []string, info ComicInfo) ([]byte, error) {
+	if len(imgSrcs) == 0 {
+		return nil, fmt.Errorf("no image sources provided")
+	}
+	images, err := d.pages(ctx, imgSrcs)
+	if err != nil {
+		return nil, err
+	}
+	return epubFromImages(images, info)
+}
+
+// epubFromImages creates the EPUB with the downloaded images
+func epubFromImages(images [][]byte, info ComicInfo) ([]byte, error) {
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	// the mimetype must be the first file and not compressed
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add mimetype: %v", err)
+	}
+	if _, err := io.WriteString(w, "application/epub+zip"); err != nil {
+		return nil, fmt.Errorf("failed to write mimetype: %v", err)
+	}
+	if err := writeZipFile(zw, "META-INF/container.xml", epubContainer); err != nil {
+		return nil, err
+	}
+
+	title := html.EscapeString(info.Title)
+	digits := max(3, len(fmt.Sprint(len(images))))
+	pages := make([]epubImage, 0, len(images))
+	for i, imgData := range images {
+		// the e-readers may not show the formats which are not core media types of EPUB, like WebP.
+		// They are converted as for the pdf
+		imgData, imgType, err := pdfImage(imgData)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported image %d: %v", i+1, err)
+		}
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(imgData))
+		if err != nil {
+			return nil, fmt.Errorf("unsupported or unknown image type for image %d", i+1)
+		}
+		ext := "." + strings.ToLower(imgType)
+
+		page := epubImage{
+			name:   fmt.Sprintf("page-%0*d", digits, i+1),
+			ext:    ext,
+			width:  cfg.Width,
+			height: cfg.Height,
+		}
+		pages = append(pages, page)
+
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: "OEBPS/images/" + page.name + ext, Method: zip.Store})
+		if err != nil {
+			return nil, fmt.Errorf("failed to add image %d: %v", i+1, err)
+		}
+		if _, err := w.Write(imgData); err != nil {
+			return nil, fmt.Errorf("failed to write image %d: %v", i+1, err)
+		}
+
+		xhtml := fmt.Sprintf(epubPage, title, page.width, page.height, "images/"+page.name+ext, page.name)
+		if err := writeZipFile(zw, "OEBPS/"+page.name+".xhtml", xhtml); err != nil {
+			return nil, err
+		}
+	}
+
+	firstPage := strings.TrimPrefix(pages[0].name, "page-")
+	if err := writeZipFile(zw, "OEBPS/nav.xhtml", fmt.Sprintf(epubNav, title, firstPage, title)); err != nil {
+		return nil, err
+	}
+	if err := writeZipFile(zw, "OEBPS/content.opf", epubPackage(info, pages)); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to generate EPUB: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// epubPackage writes the content.opf with the metadata, the files and the reading order
+func epubPackage(info ComicInfo, pages []epubImage) string {
+	identifier := info.Web
+	if identifier == "" {
+		identifier = info.Series + " " + info.Title
+	}
+
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid" prefix="rendition: http://www.idpf.org/vocab/rendition/#">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+`)
+	fmt.Fprintf(&sb, "    <dc:identifier id=\"bookid\">%s</dc:identifier>\n", html.EscapeString(identifier))
+	fmt.Fprintf(&sb, "    <dc:title>%s</dc:title>\n", html.EscapeString(info.Title))
+	// required by EPUB 3, und is the code of the unknown language
+	language := info.LanguageISO
+	if language == "" {
+		language = "und"
+	}
+	fmt.Fprintf(&sb, "    <dc:language>%s</dc:language>\n", html.EscapeString(language))
+	if info.Writer != "" {
+		fmt.Fprintf(&sb, "    <dc:creator>%s</dc:creator>\n", html.EscapeString(info.Writer))
+	}
+	if info.Summary != "" {
+		fmt.Fprintf(&sb, "    <dc:description>%s</dc:description>\n", html.EscapeString(info.Summary))
+	}
+	if info.Year != 0 {
+		fmt.Fprintf(&sb, "    <dc:date>%04d-%02d-%02d</dc:date>\n", info.Year, info.Month, info.Day)
+	}
+	if info.Series != "" {
+		// calibre and kobo group the chapters of the same series
+		fmt.Fprintf(&sb, "    <meta property=\"belongs-to-collection\" id=\"series\">%s</meta>\n", html.EscapeString(info.Series))
+		sb.WriteString("    <meta refines=\"#series\" property=\"collection-type\">series</meta>\n")
+		if info.Number != "" {
+			fmt.Fprintf(&sb, "    <meta refines=\"#series\" property=\"group-position\">%s</meta>\n", info.Number)
+		}
+	}
+	fmt.Fprintf(&sb, "    <meta property=\"dcterms:modified\">%s</meta>\n", time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+	sb.WriteString(`    <meta property="rendition:layout">pre-paginated</meta>
+    <meta property="rendition:orientation">portrait</meta>
+    <meta property="rendition:spread">none</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+`)
+	for i, page := range pages {
+		properties := ""
+		if i == 0 {
+			properties = ` properties="cover-image"`
+		}
+		fmt.Fprintf(&sb, "    <item id=\"img-%s\" href=\"images/%s%s\" media-type=\"%s\"%s/>\n",
+			page.name, page.name, page.ext, imageMediaType(page.ext), properties)
+		fmt.Fprintf(&sb, "    <item id=\"%s\" href=\"%s.xhtml\" media-type=\"application/xhtml+xml\"/>\n", page.name, page.name)
+	}
+	direction := "rtl"
+	if info.Manga == MangaLeftToRight {
+		direction = "ltr"
+	}
+	fmt.Fprintf(&sb, "  </manifest>\n  <spine page-progression-direction=\"%s\">\n", direction)
+	for _, page := range pages {
+		fmt.Fprintf(&sb, "    <itemref idref=\"%s\"/>\n", page.name)
+	}
+	sb.WriteString("  </spine>\n</package>\n")
+	return sb.String()
+}
+
+func imageMediaType(ext string) string {
+	switch ext {
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	default:
+		return "image/" + strings.TrimPrefix(ext, ".")
+	}
+}
+
+// writeZipFile adds a text file, compressed
+func writeZipFile(zw *zip.Writer, name string, content string) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to add %s: %v", name, err)
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package downloader
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDownloadEpubFromImageSrcs(t *testing.T) {
+	srv := newImageServer(t)
+	imgSrcs := []string{srv.URL + "/1.png", srv.URL + "/2.png"}
+	info := ComicInfo{Series: "Berserk", Title: "Chapter 10 & more", Number: "10", Web: "https://example.com/ch10", Writer: "Kentaro Miura"}
+
+	epub, err := DownloadEpubFromImageSrcs(imgSrcs, info)
+	if err != nil {
+		t.Fatalf("DownloadEpubFromImageSrcs: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(epub), int64(len(epub)))
+	if err != nil {
+		t.Fatalf("zip.NewReader: %v", err)
+	}
+
+	// readers check the mimetype before anything else
+	if first := zr.File[0]; first.Name != "mimetype" || first.Method != zip.Store {
+		t.Fatalf("first file = %s method %d, want stored mimetype", first.Name, first.Method)
+	}
+	files := readEpub(t, epub)
+	if files["mimetype"] != "application/epub+zip" {
+		t.Errorf("mimetype = %q", files["mimetype"])
+	}
+	for _, name := range []string{"META-INF/container.xml", "OEBPS/nav.xhtml", "OEBPS/images/page-001.png", "OEBPS/images/page-002.png"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("missing %s", name)
+		}
+	}
+
+	// every page has the size of its image
+	if page := files["OEBPS/page-002.xhtml"]; !strings.Contains(page, `content="width=4, height=6"`) {
+		t.Errorf("page without viewport: %s", page)
+	}
+
+	var opf struct {
+		Metadata struct {
+			Identifier string `xml:"identifier"`
+			Title      string `xml:"title"`
+			Creator    string `xml:"creator"`
+			Meta       []struct {
+				Property string `xml:"property,attr"`
+				Value    string `xml:",chardata"`
+			} `xml:"meta"`
+		} `xml:"metadata"`
+		Manifest []struct {
+			ID         string `xml:"id,attr"`
+			Properties string `xml:"properties,attr"`
+		} `xml:"manifest>item"`
+		Spine struct {
+			Direction string `xml:"page-progression-direction,attr"`
+			Items     []struct {
+				IDRef string `xml:"idref,attr"`
+			} `xml:"itemref"`
+		} `xml:"spine"`
+	}
+	if err := xml.Unmarshal([]byte(files["OEBPS/content.opf"]), &opf); err != nil {
+		t.Fatalf("content.opf is not valid xml: %v", err)
+	}
+	if opf.Metadata.Title != "Chapter 10 & more" || opf.Metadata.Identifier != info.Web || opf.Metadata.Creator != "Kentaro Miura" {
+		t.Errorf("metadata = %+v", opf.Metadata)
+	}
+	meta := make(map[string]string)
+	for _, m := range opf.Metadata.Meta {
+		meta[m.Property] = m.Value
+	}
+	if meta["rendition:layout"] != "pre-paginated" || meta["belongs-to-collection"] != "Berserk" || meta["group-position"] != "10" {
+		t.Errorf("meta = %v", meta)
+	}
+	if opf.Spine.Direction != "rtl" {
+		t.Errorf("spine direction = %q, want rtl", opf.Spine.Direction)
+	}
+	if got := fmt.Sprint(opf.Spine.Items); got != "[{page-001} {page-002}]" {
+		t.Errorf("spine = %s", got)
+	}
+	if opf.Manifest[1].ID != "img-page-001" || opf.Manifest[1].Properties != "cover-image" {
+		t.Errorf("first image must be the cover, got %+v", opf.Manifest[1])
+	}
+}
+
+// readEpub returns the content of the files of the epub
+func readEpub(t *testing.T, epub []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(epub), int64(len(epub)))
+	if err != nil {
+		t.Fatalf("zip.NewReader: %v", err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", f.Name, err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+	return files
+}
+
+func TestEpubFromWebP(t *testing.T) {
+	webp, err := os.ReadFile("testdata/page.webp")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	epub, err := epubFromImages([][]byte{webp}, ComicInfo{Title: "Chapter 1"})
+	if err != nil {
+		t.Fatalf("epubFromImages: %v", err)
+	}
+	files := readEpub(t, epub)
+
+	// the readers may not show webp, the page is converted
+	page, ok := files["OEBPS/images/page-001.jpg"]
+	if !ok {
+		t.Fatalf("Expected the page converted to jpeg, got the files %v", slices.Collect(maps.Keys(files)))
+	}
+	if detectImageType([]byte(page)) != "JPG" {
+		t.Errorf("Expected a jpeg page")
+	}
+	opf := files["OEBPS/content.opf"]
+	if !strings.Contains(opf, `href="images/page-001.jpg" media-type="image/jpeg"`) {
+		t.Errorf("Expected the jpeg page in the manifest: %s", opf)
+	}
+	// the language of the chapter is not known, but EPUB 3 requires one
+	if !strings.Contains(opf, "<dc:language>und</dc:language>") {
+		t.Errorf("Expected the unknown language: %s", opf)
+	}
+
+	epub, err = epubFromImages([][]byte{webp}, ComicInfo{Title: "Chapter 1", LanguageISO: "it"})
+	if err != nil {
+		t.Fatalf("epubFromImages: %v", err)
+	}
+	if opf := readEpub(t, epub)["OEBPS/content.opf"]; !strings.Contains(opf, "<dc:language>it</dc:language>") {
+		t.Errorf("Expected the language of the chapter: %s", opf)
+	}
+}
+
+func TestEpubLeftToRight(t *testing.T) {
+	srv := newImageServer(t)
+	epub, err := DownloadEpubFromImageSrcs([]string{srv.URL + "/1.png"}, ComicInfo{Title: "Episode 1", Manga: MangaLeftToRight})
+	if err != nil {
+		t.Fatalf("DownloadEpubFromImageSrcs: %v", err)
+	}
+	if opf := readEpub(t, epub)["OEBPS/content.opf"]; !strings.Contains(opf, `<spine page-progression-direction="ltr">`) {
+		t.Errorf("Expected the pages left to right: %s", opf)
+	}
+}
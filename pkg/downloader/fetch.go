@@ -0,0 +1,241 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// image CDNs refuse requests which do not look like coming from a browser
+const userAgent = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36"
+
+// Downloader fetches the pages of a chapter concurrently and builds the documents.
+// The pages keep the order of the sources whatever order they are downloaded in
+type Downloader struct {
+	client    *http.Client
+	workers   int           // images downloaded at the same time
+	attempts  int           // tries for every image
+	backoff   time.Duration // wait before the second try, doubled at every try
+	timeout   time.Duration // for every try
+	referer   string
+	userAgent string
+	maxSize   int // of the documents, see DownloadChapter
+	profile   Profile
+	webtoon   bool
+}
+
+// NewDownloader creates a downloader using the client, a nil client means http.DefaultClient
+func NewDownloader(client *http.Client, workers int) *Downloader {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Downloader{
+		client:    client,
+		workers:   max(1, workers),
+		attempts:  3,
+		backoff:   500 * time.Millisecond,
+		timeout:   30 * time.Second,
+		userAgent: userAgent,
+		maxSize:   MaxDocumentSize,
+		profile:   OriginalProfile,
+	}
+}
+
+func NewDownloaderDefault() *Downloader {
+	return NewDownloader(nil, 4)
+}
+
+// WithReferer returns a copy of the downloader sending the origin of the page as Referer,
+// like a browser reading the chapter. Some CDNs do not serve the images without it
+func (d *Downloader) WithReferer(pageURL string) *Downloader {
+	c := *d
+	c.referer = ""
+	if u, err := url.Parse(pageURL); err == nil && u.Scheme != "" && u.Host != "" {
+		c.referer = u.Scheme + "://" + u.Host + "/"
+	}
+	return &c
+}
+
+// WithRetry returns a copy of the downloader trying every image the given times
+func (d *Downloader) WithRetry(attempts int, backoff time.Duration) *Downloader {
+	c := *d
+	c.attempts = max(1, attempts)
+	c.backoff = backoff
+	return &c
+}
+
+// WithTimeout returns a copy of the downloader with the timeout of every try
+func (d *Downloader) WithTimeout(timeout time.Duration) *Downloader {
+	c := *d
+	c.timeout = timeout
+	return &c
+}
+
+// FetchImages downloads the images, the i-th result is the image of the i-th source.
+// The first image failing after all the tries stops the others
+func (d *Downloader) FetchImages(ctx context.Context, imgSrcs []string) ([][]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	images := make([][]byte, len(imgSrcs))
+	jobs := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for range min(d.workers, len(imgSrcs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				data, err := d.fetchImage(ctx, i, imgSrcs[i])
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					continue
+				}
+				images[i] = data
+			}
+		}()
+	}
+
+send:
+	for i := range imgSrcs {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// pages downloads the images and prepares them for the document: the strips of the webtoons
+// are sliced and then the profile is applied
+func (d *Downloader) pages(ctx context.Context, imgSrcs []string) ([][]byte, error) {
+	images, err := d.FetchImages(ctx, imgSrcs)
+	if err != nil {
+		return nil, err
+	}
+	if d.webtoon {
+		if images, err = sliceStrip(images); err != nil {
+			return nil, err
+		}
+	}
+	if d.profile.isOriginal() {
+		return images, nil
+	}
+
+	// the profile is slow on big images, the pages are processed by the workers
+	var wg sync.WaitGroup
+	next := make(chan int)
+	for range min(d.workers, len(images)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				images[i] = d.process(i, images[i])
+			}
+		}()
+	}
+	for i := range images {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return images, nil
+}
+
+// process applies the profile to the image. An image which cannot be processed,
+// like an avif, is kept as it is and the document decides what to do with it
+func (d *Downloader) process(i int, data []byte) []byte {
+	processed, err := d.profile.Apply(data)
+	if err != nil {
+		log.Printf("image %d not processed with the profile %s: %v", i+1, d.profile.Name, err)
+		return data
+	}
+	return processed
+}
+
+// fetchImage downloads the i-th image of a chapter, trying again on server and network errors
+func (d *Downloader) fetchImage(ctx context.Context, i int, src string) ([]byte, error) {
+	backoff := d.backoff
+	var err error
+	for attempt := 1; attempt <= d.attempts; attempt++ {
+		var data []byte
+		data, err = d.get(ctx, src)
+		if err == nil {
+			return data, nil
+		}
+		var se *statusError
+		if ctx.Err() != nil || (errors.As(err, &se) && !se.retryable()) || attempt == d.attempts {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return nil, fmt.Errorf("error fetching image %d: %w", i+1, ctx.Err())
+		}
+	}
+	return nil, fmt.Errorf("error fetching image %d: %w", i+1, err)
+}
+
+func (d *Downloader) get(ctx context.Context, src string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", d.userAgent)
+	// avif is left out, it cannot be converted for the pdf
+	req.Header.Set("Accept", "image/webp,image/png,image/jpeg,image/gif,image/*;q=0.8")
+	if d.referer != "" {
+		req.Header.Set("Referer", d.referer)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &statusError{code: resp.StatusCode, url: src}
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// statusError is an answer of the server which is not a 2xx
+type statusError struct {
+	code int
+	url  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s answered with status %d", e.url, e.code)
+}
+
+// server errors and rate limits can go away, a missing image will not
+func (e *statusError) retryable() bool {
+	return e.code >= 500 || e.code == http.StatusTooManyRequests || e.code == http.StatusRequestTimeout
+}
@@ -0,0 +1,143 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchImagesKeepsOrder(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		// the first pages are the slowest
+		i, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		time.Sleep(time.Duration(10-i) * 5 * time.Millisecond)
+		fmt.Fprintf(w, "page %d", i)
+	}))
+	defer srv.Close()
+
+	var imgSrcs []string
+	for i := range 10 {
+		imgSrcs = append(imgSrcs, fmt.Sprintf("%s/%d", srv.URL, i))
+	}
+	images, err := NewDownloader(srv.Client(), 3).FetchImages(context.Background(), imgSrcs)
+	if err != nil {
+		t.Fatalf("FetchImages: %v", err)
+	}
+	for i, img := range images {
+		if want := fmt.Sprintf("page %d", i); string(img) != want {
+			t.Errorf("image %d = %q, want %q", i, img, want)
+		}
+	}
+	if m := maxRunning.Load(); m > 3 {
+		t.Errorf("%d images downloaded at the same time, at most 3 expected", m)
+	}
+}
+
+func TestFetchImagesRetry(t *testing.T) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls[r.URL.Path]++
+		n := calls[r.URL.Path]
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/flaky":
+			if n < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/down":
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		case "/missing":
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("image"))
+	}))
+	defer srv.Close()
+	d := NewDownloader(srv.Client(), 2).WithRetry(3, time.Millisecond)
+
+	images, err := d.FetchImages(context.Background(), []string{srv.URL + "/ok", srv.URL + "/flaky"})
+	if err != nil {
+		t.Fatalf("server errors must be retried: %v", err)
+	}
+	if string(images[1]) != "image" || calls["/flaky"] != 3 {
+		t.Errorf("got %q after %d calls", images[1], calls["/flaky"])
+	}
+
+	_, err = d.FetchImages(context.Background(), []string{srv.URL + "/missing"})
+	if err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Errorf("expected a 404 error, got %v", err)
+	}
+	if calls["/missing"] != 1 {
+		t.Errorf("a missing image must not be retried, got %d calls", calls["/missing"])
+	}
+
+	// still failing after all the tries
+	_, err = d.FetchImages(context.Background(), []string{srv.URL + "/ok", srv.URL + "/down"})
+	if err == nil || !strings.Contains(err.Error(), "image 2") {
+		t.Errorf("expected an error for image 2, got %v", err)
+	}
+	if calls["/down"] != 3 {
+		t.Errorf("expected 3 tries, got %d", calls["/down"])
+	}
+}
+
+func TestFetchImagesTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	d := NewDownloader(srv.Client(), 1).WithRetry(2, time.Millisecond).WithTimeout(20 * time.Millisecond)
+	if _, err := d.FetchImages(context.Background(), []string{srv.URL + "/slow"}); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("the timeout was not applied, took %s", elapsed)
+	}
+}
+
+func TestFetchImagesHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// like the CDNs refusing hotlinking
+		if r.Header.Get("Referer") != "https://weebcentral.com/" || !strings.HasPrefix(r.Header.Get("User-Agent"), "Mozilla") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("image"))
+	}))
+	defer srv.Close()
+	d := NewDownloader(srv.Client(), 1)
+
+	if _, err := d.FetchImages(context.Background(), []string{srv.URL}); err == nil {
+		t.Error("expected 403 without the referer")
+	}
+	d = d.WithReferer("https://weebcentral.com/chapters/01J76XYYZ")
+	if _, err := d.FetchImages(context.Background(), []string{srv.URL}); err != nil {
+		t.Errorf("FetchImages with referer: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestPdfImage(t *testing.T) {
+	webp, err := os.ReadFile("testdata/page.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	webpAlpha, err := os.ReadFile("testdata/page-alpha.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gifData bytes.Buffer
+	palette := color.Palette{color.Black, color.White}
+	if err := gif.Encode(&gifData, image.NewPaletted(image.Rect(0, 0, 4, 6), palette), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		data    []byte
+		imgType string
+	}{
+		{"webp", webp, "JPG"},
+		{"webp with transparency", webpAlpha, "PNG"},
+		{"gif", gifData.Bytes(), "JPG"},
+	}
+	for _, c := range cases {
+		data, imgType, err := pdfImage(c.data)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if imgType != c.imgType || detectImageType(data) != c.imgType {
+			t.Errorf("%s converted to %s (%s), want %s", c.name, imgType, detectImageType(data), c.imgType)
+		}
+	}
+
+	avif := append([]byte{0, 0, 0, 0x1c}, []byte("ftypavif\x00\x00\x00\x00")...)
+	if _, _, err := pdfImage(avif); err == nil {
+		t.Error("avif should fail")
+	}
+	if _, _, err := pdfImage([]byte("<html>not found</html>")); err == nil {
+		t.Error("html should fail")
+	}
+}
+
+func TestDownloadPdfWebp(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer srv.Close()
+
+	pdf, err := NewDownloaderDefault().DownloadPdf(context.Background(),
+		[]string{srv.URL + "/page.webp", srv.URL + "/page-alpha.webp"}, "webp")
+	if err != nil {
+		t.Fatalf("DownloadPdf: %v", err)
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF")) {
+		t.Errorf("not a pdf")
+	}
+}
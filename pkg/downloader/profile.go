@@ -0,0 +1,174 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// Profile is how the pages are processed for a device before building the document.
+// E-ink screens are gray and small: colors and big images only make the files heavier
+type Profile struct {
+	Name        string
+	Description string
+	// the pages are resized to fit in the screen, never enlarged. 0 keeps the size
+	Width, Height int
+	Grayscale     bool
+	// stretches the levels of gray, the scans are often washed out
+	AutoContrast bool
+	// greater than 1 darkens the midtones, which look too light on e-ink. 0 or 1 keeps them
+	Gamma float64
+	// of the jpeg the pages are encoded in
+	Quality int
+}
+
+// OriginalProfile keeps the pages as they are downloaded
+var OriginalProfile = Profile{Name: "original", Description: "pages as they are on the site"}
+
+// Profiles are the profiles a user can choose, the first one is the default
+var Profiles = []Profile{
+	OriginalProfile,
+	{Name: "kindle-paperwhite", Description: "Kindle Paperwhite 5, 1236x1648", Width: 1236, Height: 1648, Grayscale: true, AutoContrast: true, Gamma: 1.8, Quality: 80},
+	{Name: "kindle-oasis", Description: "Kindle Oasis, 1264x1680", Width: 1264, Height: 1680, Grayscale: true, AutoContrast: true, Gamma: 1.8, Quality: 80},
+	{Name: "kindle", Description: "Kindle, 1072x1448", Width: 1072, Height: 1448, Grayscale: true, AutoContrast: true, Gamma: 1.8, Quality: 80},
+	{Name: "kobo-libra", Description: "Kobo Libra 2, 1264x1680", Width: 1264, Height: 1680, Grayscale: true, AutoContrast: true, Gamma: 1.8, Quality: 80},
+	{Name: "kobo-clara", Description: "Kobo Clara HD/2E, 1072x1448", Width: 1072, Height: 1448, Grayscale: true, AutoContrast: true, Gamma: 1.8, Quality: 80},
+	{Name: "phone", Description: "color pages for phones and tablets, 1440 wide", Width: 1440, Height: 0, Quality: 85},
+}
+
+// ProfileByName finds a profile of Profiles
+func ProfileByName(name string) (Profile, bool) {
+	for _, p := range Profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Profile{}, false
+}
+
+// WithProfile returns a copy of the downloader processing the pages with the profile
+func (d *Downloader) WithProfile(p Profile) *Downloader {
+	c := *d
+	c.profile = p
+	return &c
+}
+
+func (p Profile) isOriginal() bool {
+	return p.Width == 0 && p.Height == 0 && !p.Grayscale && !p.AutoContrast && (p.Gamma == 0 || p.Gamma == 1) && p.Quality == 0
+}
+
+// Apply processes the image and encodes it as jpeg
+func (p Profile) Apply(data []byte) ([]byte, error) {
+	if p.isOriginal() {
+		return data, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	img = p.resize(img)
+	if p.Grayscale {
+		img = toGray(img)
+	}
+	if gray, ok := img.(*image.Gray); ok {
+		if p.AutoContrast {
+			autoContrast(gray)
+		}
+		if p.Gamma != 0 && p.Gamma != 1 {
+			applyGamma(gray.Pix, p.Gamma)
+		}
+	}
+
+	quality := p.Quality
+	if quality == 0 {
+		quality = 90
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// resize fits the image in Width x Height keeping the aspect ratio
+func (p Profile) resize(img image.Image) image.Image {
+	b := img.Bounds()
+	scale := 1.0
+	if p.Width > 0 && b.Dx() > p.Width {
+		scale = float64(p.Width) / float64(b.Dx())
+	}
+	if p.Height > 0 && float64(b.Dy())*scale > float64(p.Height) {
+		scale = float64(p.Height) / float64(b.Dy())
+	}
+	if scale >= 1 {
+		return img
+	}
+
+	w, h := max(1, int(float64(b.Dx())*scale)), max(1, int(float64(b.Dy())*scale))
+	var dst draw.Image
+	if _, ok := img.(*image.Gray); ok {
+		dst = image.NewGray(image.Rect(0, 0, w, h))
+	} else {
+		dst = image.NewRGBA(image.Rect(0, 0, w, h))
+	}
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+func toGray(img image.Image) *image.Gray {
+	if gray, ok := img.(*image.Gray); ok {
+		return gray
+	}
+	b := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			gray.Set(x-b.Min.X, y-b.Min.Y, color.GrayModel.Convert(img.At(x, y)))
+		}
+	}
+	return gray
+}
+
+// autoContrast stretches the levels so that the darkest 0.5% is black and the lightest 0.5% is white
+func autoContrast(gray *image.Gray) {
+	var histogram [256]int
+	for _, v := range gray.Pix {
+		histogram[v]++
+	}
+	clip := len(gray.Pix) / 200
+	low, high := 0, 255
+	for n := 0; low < 255 && n+histogram[low] <= clip; low++ {
+		n += histogram[low]
+	}
+	for n := 0; high > 0 && n+histogram[high] <= clip; high-- {
+		n += histogram[high]
+	}
+	if high <= low {
+		return
+	}
+
+	var levels [256]uint8
+	for v := range levels {
+		stretched := (v - low) * 255 / (high - low)
+		levels[v] = uint8(min(255, max(0, stretched)))
+	}
+	for i, v := range gray.Pix {
+		gray.Pix[i] = levels[v]
+	}
+}
+
+func applyGamma(pix []uint8, gamma float64) {
+	var levels [256]uint8
+	for v := range levels {
+		levels[v] = uint8(math.Round(255 * math.Pow(float64(v)/255, gamma)))
+	}
+	for i, v := range pix {
+		pix[i] = levels[v]
+	}
+}
@@ -0,0 +1,95 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodePng(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProfileApply(t *testing.T) {
+	page := image.NewRGBA(image.Rect(0, 0, 2000, 3000))
+	for y := range 3000 {
+		for x := range 2000 {
+			page.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+	paperwhite, ok := ProfileByName("kindle-paperwhite")
+	if !ok {
+		t.Fatal("kindle-paperwhite profile not found")
+	}
+
+	data, err := paperwhite.Apply(encodePng(t, page))
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("the page is not a jpeg: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 1098 || b.Dy() != 1648 {
+		t.Errorf("page resized to %dx%d, want 1098x1648", b.Dx(), b.Dy())
+	}
+	if _, ok := img.(*image.Gray); !ok {
+		t.Errorf("expected a gray page, got %T", img)
+	}
+
+	// small pages are not enlarged
+	data, err = paperwhite.Apply(encodePng(t, image.NewRGBA(image.Rect(0, 0, 100, 150))))
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if cfg, _ := jpeg.DecodeConfig(bytes.NewReader(data)); cfg.Width != 100 || cfg.Height != 150 {
+		t.Errorf("small page resized to %dx%d", cfg.Width, cfg.Height)
+	}
+
+	original := encodePng(t, page)
+	if data, _ := OriginalProfile.Apply(original); !bytes.Equal(data, original) {
+		t.Error("the original profile must not change the page")
+	}
+	if _, err := paperwhite.Apply([]byte("not an image")); err == nil {
+		t.Error("expected an error for a broken image")
+	}
+}
+
+func TestAutoContrastAndGamma(t *testing.T) {
+	// a washed out scan, from 100 to 150
+	gray := image.NewGray(image.Rect(0, 0, 51, 1))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(100 + i)
+	}
+	autoContrast(gray)
+	if gray.Pix[0] != 0 || gray.Pix[50] != 255 {
+		t.Errorf("levels stretched to %d-%d, want 0-255", gray.Pix[0], gray.Pix[50])
+	}
+
+	pix := []uint8{0, 128, 255}
+	applyGamma(pix, 2)
+	if pix[0] != 0 || pix[1] != 64 || pix[2] != 255 {
+		t.Errorf("gamma 2 gave %v, want [0 64 255]", pix)
+	}
+}
+
+func TestDownloaderWithProfile(t *testing.T) {
+	srv := newImageServer(t)
+	kobo, _ := ProfileByName("kobo-libra")
+	images, err := NewDownloaderDefault().WithProfile(kobo).pages(context.Background(), []string{srv.URL + "/1.png"})
+	if err != nil {
+		t.Fatalf("pages: %v", err)
+	}
+	if detectImageType(images[0]) != "JPG" {
+		t.Errorf("the page was not processed with the profile")
+	}
+}
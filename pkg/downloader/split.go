@@ -0,0 +1,186 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+	"golang.org/x/image/draw"
+)
+
+// MaxDocumentSize is the biggest document a bot can upload to Telegram, 50 MB.
+// A margin is left for the multipart form
+const MaxDocumentSize = 50<<20 - 512<<10
+
+// Document is a file ready to be sent
+type Document struct {
+	Name string
+	Data []byte
+}
+
+// compression is a step of the ladders used when a document is too big
+type compression struct {
+	quality int     // of the jpeg
+	scale   float64 // of width and height
+}
+
+// first the quality is lowered, keeping the pages readable
+var qualityLadder = []compression{{quality: 85, scale: 1}, {quality: 70, scale: 1}}
+
+// a single page too big for a document is also resized, like the very long strips of the webtoons
+var resizeLadder = []compression{{quality: 70, scale: 0.75}, {quality: 60, scale: 0.5}, {quality: 50, scale: 0.35}}
+
+// WithMaxSize returns a copy of the downloader building documents of at most maxSize bytes,
+// 0 means no limit
+func (d *Downloader) WithMaxSize(maxSize int) *Downloader {
+	c := *d
+	c.maxSize = maxSize
+	return &c
+}
+
+// DownloadChapter downloads the chapter in the format. When the document is bigger than the max size,
+// the pages are recompressed and then split in numbered parts, which are returned in order
+func (d *Downloader) DownloadChapter(ctx context.Context, imgSrcs []string, format model.DownloadFormat, title string, info ComicInfo) ([]Document, error) {
+	if len(imgSrcs) == 0 {
+		return nil, fmt.Errorf("no image sources provided")
+	}
+	images, err := d.pages(ctx, imgSrcs)
+	if err != nil {
+		return nil, err
+	}
+
+	build := func(images [][]byte) ([]byte, error) {
+		switch format {
+		case model.FormatCBZ:
+			return cbzFromImages(images, info)
+		case model.FormatEPUB:
+			return epubFromImages(images, info)
+		default:
+			return pdfFromImages(images, title)
+		}
+	}
+	if format != model.FormatCBZ && format != model.FormatEPUB {
+		format = model.FormatPDF
+	}
+
+	doc, err := build(images)
+	for _, c := range qualityLadder {
+		if err != nil || d.fits(doc) {
+			break
+		}
+		images = recompressAll(images, c)
+		doc, err = build(images)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.fits(doc) {
+		return []Document{{Name: fmt.Sprintf("%s.%s", title, format), Data: doc}}, nil
+	}
+
+	parts, err := d.split(images, build)
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]Document, len(parts))
+	for i, part := range parts {
+		docs[i] = Document{Name: fmt.Sprintf("%s-part%d.%s", title, i+1, format), Data: part}
+	}
+	return docs, nil
+}
+
+func (d *Downloader) fits(doc []byte) bool {
+	return d.maxSize <= 0 || len(doc) <= d.maxSize
+}
+
+// split builds the documents of consecutive pages fitting the max size. The pages are first packed
+// by their size, a part still too big because of the size of the format is halved again
+func (d *Downloader) split(images [][]byte, build func([][]byte) ([]byte, error)) ([][]byte, error) {
+	var parts [][]byte
+	var splitPart func(from, to int) error
+	splitPart = func(from, to int) error {
+		doc, err := build(images[from:to])
+		if err != nil {
+			return err
+		}
+		if d.fits(doc) {
+			parts = append(parts, doc)
+			return nil
+		}
+		if to-from > 1 {
+			mid := (from + to) / 2
+			if err := splitPart(from, mid); err != nil {
+				return err
+			}
+			return splitPart(mid, to)
+		}
+
+		// a single page, it can only be made smaller
+		original := images[from]
+		for _, c := range resizeLadder {
+			images[from] = recompress(original, c)
+			if doc, err = build(images[from:to]); err != nil {
+				return err
+			}
+			if d.fits(doc) {
+				parts = append(parts, doc)
+				return nil
+			}
+		}
+		return fmt.Errorf("image %d is too big even after resizing: %d bytes", from+1, len(doc))
+	}
+
+	// about 5% of every document is left for the format
+	budget := d.maxSize - d.maxSize/20
+	from, size := 0, 0
+	for i, img := range images {
+		if i > from && size+len(img) > budget {
+			if err := splitPart(from, i); err != nil {
+				return nil, err
+			}
+			from, size = i, 0
+		}
+		size += len(img)
+	}
+	if err := splitPart(from, len(images)); err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+func recompressAll(images [][]byte, c compression) [][]byte {
+	recompressed := make([][]byte, len(images))
+	for i, img := range images {
+		recompressed[i] = recompress(img, c)
+	}
+	return recompressed
+}
+
+// recompress encodes the image as jpeg with the quality and the scale of the step.
+// The image is returned as it is when it cannot be decoded or when it would become bigger
+func recompress(data []byte, c compression) []byte {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return data
+	}
+	if c.scale < 1 {
+		b := img.Bounds()
+		w, h := max(1, int(float64(b.Dx())*c.scale)), max(1, int(float64(b.Dy())*c.scale))
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.BiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+		img = dst
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: c.quality}); err != nil {
+		return data
+	}
+	// resized pages are always taken, even if a png was smaller
+	if c.scale >= 1 && buf.Len() >= len(data) {
+		return data
+	}
+	return buf.Bytes()
+}
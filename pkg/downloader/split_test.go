@@ -0,0 +1,117 @@
+package downloader
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+)
+
+// newNoiseServer serves pngs of random pixels, which compress badly like the scanned pages
+func newNoiseServer(t *testing.T, width, height int) *httptest.Server {
+	t.Helper()
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rng.Intn(256))
+	}
+	img.Set(0, 0, color.White)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(buf.Bytes())
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func pageSrcs(srv *httptest.Server, n int) []string {
+	var srcs []string
+	for i := range n {
+		srcs = append(srcs, fmt.Sprintf("%s/%d.png", srv.URL, i))
+	}
+	return srcs
+}
+
+// cbzPages counts the images in the cbz
+func cbzPages(t *testing.T, cbz []byte) int {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(cbz), int64(len(cbz)))
+	if err != nil {
+		t.Fatalf("zip.NewReader: %v", err)
+	}
+	return len(zr.File) - 1 // ComicInfo.xml
+}
+
+func TestDownloadChapterFits(t *testing.T) {
+	srv := newNoiseServer(t, 50, 50)
+	docs, err := NewDownloaderDefault().DownloadChapter(context.Background(), pageSrcs(srv, 3), model.FormatPDF, "Berserk-Chapter 1", ComicInfo{})
+	if err != nil {
+		t.Fatalf("DownloadChapter: %v", err)
+	}
+	if len(docs) != 1 || docs[0].Name != "Berserk-Chapter 1.pdf" || !bytes.HasPrefix(docs[0].Data, []byte("%PDF")) {
+		t.Errorf("expected a single pdf, got %d documents", len(docs))
+	}
+}
+
+func TestDownloadChapterSplit(t *testing.T) {
+	srv := newNoiseServer(t, 200, 200)
+	const maxSize = 60 << 10
+	d := NewDownloaderDefault().WithMaxSize(maxSize)
+
+	docs, err := d.DownloadChapter(context.Background(), pageSrcs(srv, 12), model.FormatCBZ, "Berserk-Chapter 1", ComicInfo{})
+	if err != nil {
+		t.Fatalf("DownloadChapter: %v", err)
+	}
+	if len(docs) < 2 {
+		t.Fatalf("expected more parts, got %d", len(docs))
+	}
+	pages := 0
+	for i, doc := range docs {
+		if want := fmt.Sprintf("Berserk-Chapter 1-part%d.cbz", i+1); doc.Name != want {
+			t.Errorf("part %d named %q, want %q", i+1, doc.Name, want)
+		}
+		if len(doc.Data) > maxSize {
+			t.Errorf("part %d is %d bytes, more than %d", i+1, len(doc.Data), maxSize)
+		}
+		pages += cbzPages(t, doc.Data)
+	}
+	if pages != 12 {
+		t.Errorf("expected 12 pages in the parts, got %d", pages)
+	}
+}
+
+func TestDownloadChapterResizesBigPage(t *testing.T) {
+	// a page alone is bigger than the limit
+	srv := newNoiseServer(t, 600, 600)
+	const maxSize = 100 << 10
+	d := NewDownloaderDefault().WithMaxSize(maxSize)
+
+	docs, err := d.DownloadChapter(context.Background(), pageSrcs(srv, 2), model.FormatPDF, "webtoon", ComicInfo{})
+	if err != nil {
+		t.Fatalf("DownloadChapter: %v", err)
+	}
+	for _, doc := range docs {
+		if len(doc.Data) > maxSize {
+			t.Errorf("%s is %d bytes, more than %d", doc.Name, len(doc.Data), maxSize)
+		}
+	}
+
+	// nothing can make it fit
+	_, err = d.WithMaxSize(1<<10).DownloadChapter(context.Background(), pageSrcs(srv, 1), model.FormatPDF, "webtoon", ComicInfo{})
+	if err == nil || !strings.Contains(err.Error(), "too big") {
+		t.Errorf("expected an error for a page too big, got %v", err)
+	}
+}
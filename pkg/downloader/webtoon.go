@@ -0,0 +1,214 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// WebtoonPageRatio is the height of the pages sliced from a strip, relative to their width
+const WebtoonPageRatio = 1.5
+
+const (
+	// a page is cut between minPageRatio and maxPageRatio times the target height,
+	// the closest uniform row to the target wins
+	minPageRatio = 0.6
+	maxPageRatio = 1.2
+	// how much the pixels of a row can differ from the first one for the row to be background
+	rowTolerance = 24
+	// images taller than this times the page height are strips
+	stripRatio = 1.5
+)
+
+// WithWebtoon returns a copy of the downloader slicing the long strips of the webtoons in pages
+func (d *Downloader) WithWebtoon(webtoon bool) *Downloader {
+	c := *d
+	c.webtoon = webtoon
+	return &c
+}
+
+// isStrip reports whether the chapter is made of long strips, like the webtoons.
+// Normal manga pages are left as they are even in webtoon mode
+func isStrip(configs []image.Config) bool {
+	for _, cfg := range configs {
+		if float64(cfg.Height) > float64(cfg.Width)*WebtoonPageRatio*stripRatio {
+			return true
+		}
+	}
+	return false
+}
+
+// strip is the images one below the other, scaled to the same width. Only the images of the
+// page being cut are decoded, a long chapter decoded whole would take hundreds of MB
+type strip struct {
+	data    [][]byte      // the encoded images
+	decoded []image.Image // nil when not decoded yet, or not needed anymore
+	starts  []int         // y of every image in the strip
+	width   int
+	height  int
+	err     error // of the first image which could not be decoded
+}
+
+// newStrip places the images with their sizes, without decoding them
+func newStrip(images [][]byte, configs []image.Config) *strip {
+	s := &strip{data: images, decoded: make([]image.Image, len(images)), width: configs[0].Width}
+	for _, cfg := range configs {
+		h := cfg.Height
+		if cfg.Width != s.width {
+			h = max(1, cfg.Height*s.width/cfg.Width)
+		}
+		s.starts = append(s.starts, s.height)
+		s.height += h
+	}
+	return s
+}
+
+// image decodes the i-th image and scales it to the width of the strip. An image which
+// cannot be decoded is blank, the error is kept in s.err
+func (s *strip) image(i int) image.Image {
+	if s.decoded[i] != nil {
+		return s.decoded[i]
+	}
+	img, _, err := image.Decode(bytes.NewReader(s.data[i]))
+	if err != nil {
+		if s.err == nil {
+			s.err = fmt.Errorf("image %d: %v", i+1, err)
+		}
+		img = image.NewRGBA(image.Rect(0, 0, s.width, s.bottom(i)-s.starts[i]))
+	}
+	if b := img.Bounds(); b.Dx() != s.width || b.Dy() != s.bottom(i)-s.starts[i] {
+		dst := image.NewRGBA(image.Rect(0, 0, s.width, s.bottom(i)-s.starts[i]))
+		xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+		img = dst
+	}
+	s.decoded[i] = img
+	return img
+}
+
+// bottom is the y where the i-th image ends
+func (s *strip) bottom(i int) int {
+	if i+1 < len(s.starts) {
+		return s.starts[i+1]
+	}
+	return s.height
+}
+
+// release forgets the decoded images which end above the row y
+func (s *strip) release(y int) {
+	for i := range s.decoded {
+		if s.bottom(i) <= y {
+			s.decoded[i] = nil
+		}
+	}
+}
+
+// at returns the image containing the row y of the strip and the row in the image
+func (s *strip) at(y int) (image.Image, int) {
+	i := len(s.starts) - 1
+	for i > 0 && s.starts[i] > y {
+		i--
+	}
+	img := s.image(i)
+	return img, img.Bounds().Min.Y + y - s.starts[i]
+}
+
+// isUniform reports whether the row is a single color, like the space between the panels
+func (s *strip) isUniform(y int) bool {
+	img, row := s.at(y)
+	minX := img.Bounds().Min.X
+	r0, g0, b0, _ := img.At(minX, row).RGBA()
+	for x := minX + 1; x < img.Bounds().Max.X; x++ {
+		r, g, b, _ := img.At(x, row).RGBA()
+		if diff(r, r0) > rowTolerance || diff(g, g0) > rowTolerance || diff(b, b0) > rowTolerance {
+			return false
+		}
+	}
+	return true
+}
+
+func diff(a, b uint32) uint32 {
+	// 16 bit colors to 8 bit
+	a, b = a>>8, b>>8
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// nextCut returns the row where the page starting at start is cut, the height of the strip for the last page
+func (s *strip) nextCut(start int) int {
+	target := int(float64(s.width) * WebtoonPageRatio)
+	minHeight, maxHeight := int(float64(target)*minPageRatio), int(float64(target)*maxPageRatio)
+	if s.height-start <= maxHeight {
+		return s.height
+	}
+
+	// the closest background row to the target, alternating below and above
+	for offset := 0; ; offset++ {
+		below, above := start+target+offset, start+target-offset
+		if below > start+maxHeight && above < start+minHeight {
+			return start + target
+		}
+		if below <= start+maxHeight && s.isUniform(below) {
+			return below
+		}
+		if above >= start+minHeight && s.isUniform(above) {
+			return above
+		}
+	}
+}
+
+// page draws the rows of the strip from y0 to y1
+func (s *strip) page(y0, y1 int) *image.RGBA {
+	page := image.NewRGBA(image.Rect(0, 0, s.width, y1-y0))
+	for i := range s.data {
+		top, bottom := s.starts[i], s.bottom(i)
+		if bottom <= y0 || top >= y1 {
+			continue
+		}
+		img := s.image(i)
+		from, to := max(top, y0), min(bottom, y1)
+		src := image.Pt(img.Bounds().Min.X, img.Bounds().Min.Y+from-top)
+		draw.Draw(page, image.Rect(0, from-y0, s.width, to-y0), img, src, draw.Src)
+	}
+	return page
+}
+
+// sliceStrip stitches the images of a webtoon and slices them in pages of WebtoonPageRatio,
+// cutting on the background between the panels when possible. Chapters which are not strips,
+// or with images which cannot be decoded, are returned as they are
+func sliceStrip(images [][]byte) ([][]byte, error) {
+	configs := make([]image.Config, len(images))
+	for i, data := range images {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return images, nil
+		}
+		configs[i] = cfg
+	}
+	if len(configs) == 0 || !isStrip(configs) {
+		return images, nil
+	}
+
+	s := newStrip(images, configs)
+	var pages [][]byte
+	for y0 := 0; y0 < s.height; {
+		y1 := s.nextCut(y0)
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, s.page(y0, y1), &jpeg.Options{Quality: 90}); err != nil {
+			return nil, fmt.Errorf("failed to encode page %d: %v", len(pages)+1, err)
+		}
+		pages = append(pages, buf.Bytes())
+		// the next pages start below, the images above are not needed anymore
+		s.release(y1)
+		y0 = y1
+	}
+	if s.err != nil {
+		return images, nil
+	}
+	return pages, nil
+}
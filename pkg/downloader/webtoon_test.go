@@ -0,0 +1,136 @@
+package downloader
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+// panel draws noise, like the drawings, with white gutters at the given rows
+func panels(width, height int, gutters ...int) *image.RGBA {
+	rng := rand.New(rand.NewSource(int64(height)))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			v := uint8(rng.Intn(256))
+			img.Set(x, y, color.RGBA{R: v, G: v / 2, B: 255 - v, A: 255})
+		}
+	}
+	for _, g := range gutters {
+		for y := g; y < g+6 && y < height; y++ {
+			for x := range width {
+				img.Set(x, y, color.White)
+			}
+		}
+	}
+	return img
+}
+
+// stripOf encodes the images in png, which keeps the gutters white, and places them in a strip
+func stripOf(t *testing.T, images ...image.Image) *strip {
+	t.Helper()
+	var data [][]byte
+	var configs []image.Config
+	for _, img := range images {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, buf.Bytes())
+		configs = append(configs, image.Config{Width: img.Bounds().Dx(), Height: img.Bounds().Dy()})
+	}
+	return newStrip(data, configs)
+}
+
+// cutsOf cuts the whole strip, releasing the images above every cut as sliceStrip does
+func cutsOf(s *strip) []int {
+	var cuts []int
+	for y := 0; y < s.height; {
+		y = s.nextCut(y)
+		s.release(y)
+		cuts = append(cuts, y)
+	}
+	return cuts
+}
+
+func TestStripCuts(t *testing.T) {
+	// target height 150: the gutters are near 150, 300 and 450. The second image
+	// is twice as wide, it is scaled to 100x250
+	first := panels(100, 350, 135, 290)
+	second := panels(200, 500, 200)
+	s := stripOf(t, first, second)
+	if s.height != 600 || s.width != 100 {
+		t.Fatalf("strip is %dx%d, want 100x600", s.width, s.height)
+	}
+
+	cuts := cutsOf(s)
+	if len(cuts) == 0 || cuts[len(cuts)-1] != 600 {
+		t.Fatalf("the last cut must be the end of the strip, got %v", cuts)
+	}
+	// the gutter of the second image is at 350 + 200/2
+	for i, want := range []int{135, 290, 450} {
+		if i >= len(cuts) || cuts[i] < want || cuts[i] >= want+6 {
+			t.Errorf("cut %d at %v, want in the gutter at %d", i, cuts, want)
+		}
+	}
+	for i := 1; i < len(cuts); i++ {
+		if h := cuts[i] - cuts[i-1]; h > int(150*maxPageRatio) {
+			t.Errorf("page %d is %d rows high", i, h)
+		}
+	}
+
+	// the images above the last cut are not kept decoded
+	for i, img := range s.decoded {
+		if img != nil {
+			t.Errorf("image %d is still decoded", i+1)
+		}
+	}
+
+	// without gutters the cut is at the target height
+	if cuts := cutsOf(stripOf(t, panels(100, 400))); cuts[0] != 150 {
+		t.Errorf("expected a cut at 150, got %v", cuts)
+	}
+}
+
+func TestSliceStrip(t *testing.T) {
+	encode := func(img image.Image) []byte {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, nil); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	strip := [][]byte{encode(panels(100, 700, 140, 290, 440, 590)), encode(panels(100, 200))}
+	pages, err := sliceStrip(strip)
+	if err != nil {
+		t.Fatalf("sliceStrip: %v", err)
+	}
+	if len(pages) < 5 {
+		t.Fatalf("expected the strip in pages, got %d", len(pages))
+	}
+	height := 0
+	for i, page := range pages {
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(page))
+		if err != nil {
+			t.Fatalf("page %d: %v", i+1, err)
+		}
+		if cfg.Width != 100 {
+			t.Errorf("page %d is %d wide", i+1, cfg.Width)
+		}
+		height += cfg.Height
+	}
+	if height != 900 {
+		t.Errorf("the pages are %d rows high, want the whole strip of 900", height)
+	}
+
+	// manga pages are not touched
+	manga := [][]byte{encode(panels(100, 150)), encode(panels(100, 150))}
+	if pages, _ := sliceStrip(manga); len(pages) != 2 || !bytes.Equal(pages[0], manga[0]) {
+		t.Errorf("normal pages must not be sliced")
+	}
+}
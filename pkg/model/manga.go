@@ -1,9 +1,12 @@
 package model
 
 import (
+	"fmt"
+	"regexp"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
-
 )
 
 type ChatID int64
@@ -12,20 +15,127 @@ type ChatID int64
 type Manga struct {
 	Title       string
 	Url         string // unique, is ID
+	Source      string // name of the site the manga is scraped from
 	LastChapter *Chapter
+
+	// metadata, read from the page of the manga. The search results do not have it
+	CoverUrl    string
+	Authors     []string
+	Genres      []string
+	Status      MangaStatus
+	AltTitles   []string
+	Description string
+}
+
+// publication status of a manga
+type MangaStatus string
+
+const (
+	StatusUnknown   MangaStatus = ""
+	StatusOngoing   MangaStatus = "ongoing"
+	StatusCompleted MangaStatus = "completed"
+	StatusHiatus    MangaStatus = "hiatus"
+	StatusCancelled MangaStatus = "cancelled"
+)
+
+// ParseMangaStatus converts the status shown by the sites, like "Ongoing" or "Complete"
+func ParseMangaStatus(s string) MangaStatus {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "ongoing", "publishing":
+		return StatusOngoing
+	case "completed", "complete", "finished":
+		return StatusCompleted
+	case "hiatus", "on hiatus":
+		return StatusHiatus
+	case "cancelled", "canceled", "discontinued", "dropped":
+		return StatusCancelled
+	}
+	return StatusUnknown
 }
 
 type Chapter struct {
 	Title      string
-	Url        string // unique, is ID
+	Url        string  // unique, is ID
+	Number     float64 // 10.5 for extras, NoChapterNumber for oneshots
 	ReleasedAt time.Time
+	Language   string // of the translation, like "en". Only the scrapers know it, it is not saved
+	// when the bot found the chapter, zero for the chapters not saved
+	FirstSeenAt time.Time
+}
+
+// Number of the chapters whose title does not contain a number
+const NoChapterNumber = -1
+
+var (
+	chapterKeywordRegex = regexp.MustCompile(`(?i)(?:chapter|ch\.?|episode|ep\.?)\s*(\d+(?:\.\d+)?)`)
+	chapterNumberRegex  = regexp.MustCompile(`\d+(?:\.\d+)?`)
+)
+
+// ParseChapterNumber reads the number in the title of a chapter.
+// "Chapter 33.5 - Extra" => 33.5, "Vol.2 Ch.10" => 10
+func ParseChapterNumber(title string) float64 {
+	match := ""
+	if m := chapterKeywordRegex.FindStringSubmatch(title); m != nil {
+		match = m[1]
+	} else {
+		match = chapterNumberRegex.FindString(title)
+	}
+	if match == "" {
+		return NoChapterNumber
+	}
+	n, err := strconv.ParseFloat(match, 64)
+	if err != nil {
+		return NoChapterNumber
+	}
+	return n
+}
+
+// FormatChapterNumber is the inverse of ParseChapterNumber: 10.5 => "10.5", 10 => "10"
+func FormatChapterNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
 }
 
 // for semplicity an user has only a ChatID, meaning that if he deletes
 // the chat, then he looses the data
 type User struct {
-	ChatID ChatID // int6, unique, is IO
-	Mangas []Manga
+	ChatID  ChatID // int6, unique, is IO
+	Mangas  []Manga
+	Format  DownloadFormat // format of the downloaded chapters
+	Profile string         // name of the image profile of the downloads, like kobo-libra
+	Webtoon bool           // the long strips of the webtoons are sliced in pages
+}
+
+// Session is the state of a conversation of the bot with a chat, like /add.
+// Data is the json of what the conversation collected so far
+type Session struct {
+	Conversation string // name of the conversation, a chat has a session for each one
+	ChatID       ChatID
+	State        string
+	Data         []byte
+	ExpiresAt    time.Time
+}
+
+// DownloadFormat is the file a chapter is downloaded as
+type DownloadFormat string
+
+const (
+	FormatPDF  DownloadFormat = "pdf"
+	FormatCBZ  DownloadFormat = "cbz"  // zip of the images, for comic readers
+	FormatEPUB DownloadFormat = "epub" // fixed layout, for e-readers
+)
+
+// DownloadFormats are the formats a user can choose
+var DownloadFormats = []DownloadFormat{FormatPDF, FormatCBZ, FormatEPUB}
+
+// ParseDownloadFormat reads a format written by the user, like "CBZ"
+func ParseDownloadFormat(s string) (DownloadFormat, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, f := range DownloadFormats {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown download format %q", s)
 }
 
 // SortMangaByRecentChapter sorts manga based on the most recent chapter's ReleasedAt date, closest to the present time.
@@ -42,4 +152,4 @@ func (u *User) HasMangaSubscription(manga *Manga) bool {
 		}
 	}
 	return false
-}
\ No newline at end of file
+}
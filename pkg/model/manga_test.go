@@ -0,0 +1,39 @@
+package model
+
+import "testing"
+
+func TestParseChapterNumber(t *testing.T) {
+	cases := map[string]float64{
+		"Chapter 34":                 34,
+		"Chapter 33.5":               33.5,
+		"Chapter 373.5 - Extra":      373.5,
+		"Vol.2 Ch.10":                10,
+		"Episode 7 - 2 years later":  7,
+		"100":                        100,
+		"Oneshot":                    NoChapterNumber,
+		"Chapter 376 - Sunset of 20": 376,
+	}
+	for title, expected := range cases {
+		if n := ParseChapterNumber(title); n != expected {
+			t.Errorf("ParseChapterNumber(%q) = %v, want %v", title, n, expected)
+		}
+	}
+}
+
+func TestFormatChapterNumber(t *testing.T) {
+	if s := FormatChapterNumber(10.5); s != "10.5" {
+		t.Errorf("Expected 10.5, got %q", s)
+	}
+	if s := FormatChapterNumber(10); s != "10" {
+		t.Errorf("Expected 10, got %q", s)
+	}
+}
+
+func TestParseDownloadFormat(t *testing.T) {
+	if f, err := ParseDownloadFormat(" CBZ "); err != nil || f != FormatCBZ {
+		t.Errorf("Expected cbz, got %q %v", f, err)
+	}
+	if _, err := ParseDownloadFormat("epub2"); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
@@ -2,51 +2,168 @@ package repository
 
 import (
 	"database/sql"
+	"fmt"
+	"time"
 
+	"github.com/akarakai/gomanga-tbot/pkg/logger"
 	"github.com/akarakai/gomanga-tbot/pkg/model"
 )
 
+// ChapterRepo stores the history of the chapters of every manga.
+// The lists are ordered from the most recent chapter, like the scrapers return them
 type ChapterRepo interface {
 	UpdateLastChapter(chapter *model.Chapter, mangaUrl string) error
+	SaveChapters(mangaUrl string, chapters []model.Chapter) ([]model.Chapter, error)
+	FindChaptersOfManga(mangaUrl string) ([]model.Chapter, error)
+	FindChaptersPage(mangaUrl string, page int, pageSize int) ([]model.Chapter, int, error)
+	FindChapterByNumber(mangaUrl string, number float64) (*model.Chapter, error)
+	FindChapterByUrl(url string) (*model.Chapter, error)
 }
 
 type ChapterRepoSqlite3 struct {
 	db *sql.DB
 }
 
+const chapterColumns = "url, number, title, released_at, first_seen_at"
 
+// most recent first. Chapters with the same number, like the ones of more groups, by release
+const chapterOrder = "ORDER BY number DESC, released_at DESC"
+
+// UpdateLastChapter saves the chapter in the history of the manga and makes it the last one
 func (repo *ChapterRepoSqlite3) UpdateLastChapter(chapter *model.Chapter, mangaUrl string) error {
-	_, err := repo.db.Exec(`
-		INSERT OR REPLACE INTO chapters (Url, Title, released_at)
-		VALUES (?, ?, ?)`,
-		chapter.Url, chapter.Title, chapter.ReleasedAt)
+	tx, err := repo.db.Begin()
 	if err != nil {
 		return err
 	}
+	if err := upsertChapter(tx, mangaUrl, chapter); err != nil {
+		_ = tx.Rollback()
+		logger.Log.Errorw("error when saving chapter", "chapter", chapter.Title, "manga_url", mangaUrl, "err", err)
+		return err
+	}
 
-	_, err = repo.db.Exec(`
+	_, err = tx.Exec(`
 		UPDATE mangas SET last_chapter = ? WHERE Url = ?`,
 		chapter.Url, mangaUrl)
-	return err
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
-func (repo *UserRepoSqlite3) AddMangaToSaved(manga *model.Manga) error {
-	_, err := repo.db.Exec(`
-		INSERT OR IGNORE INTO mangas (Url, Title) VALUES (?, ?)`,
-		manga.Url, manga.Title)
+// SaveChapters adds to the history of the manga the chapters not saved yet.
+// The new ones are returned, in the same order
+func (repo *ChapterRepoSqlite3) SaveChapters(mangaUrl string, chapters []model.Chapter) ([]model.Chapter, error) {
+	tx, err := repo.db.Begin()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Dummy: assuming user_id = 1 for now
-	_, err = repo.db.Exec(`
-		INSERT OR IGNORE INTO user_mangas (user_id, manga_Url)
-		VALUES (?, ?)`, 1, manga.Url)
-	return err
+	now := time.Now().UTC()
+	var newChapters []model.Chapter
+	for _, ch := range chapters {
+		res, err := tx.Exec(`
+			INSERT INTO chapters (url, manga_url, number, title, released_at, first_seen_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT(url) DO NOTHING`,
+			ch.Url, mangaUrl, ch.Number, ch.Title, ch.ReleasedAt, now)
+		if err != nil {
+			_ = tx.Rollback()
+			logger.Log.Errorw("error when saving chapter", "chapter", ch.Title, "manga_url", mangaUrl, "err", err)
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			ch.FirstSeenAt = now
+			newChapters = append(newChapters, ch)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	logger.Log.Debugw("chapters saved", "manga_url", mangaUrl, "new", len(newChapters))
+	return newChapters, nil
+}
+
+// FindChaptersOfManga returns the full history of the manga
+func (repo *ChapterRepoSqlite3) FindChaptersOfManga(mangaUrl string) ([]model.Chapter, error) {
+	return repo.queryChapters(fmt.Sprintf(`SELECT %s FROM chapters WHERE manga_url = ? %s`, chapterColumns, chapterOrder), mangaUrl)
 }
 
-func (repo *UserRepoSqlite3) RemoveMangaFromSaved(manga *model.Manga) error {
-	_, err := repo.db.Exec(`
-		DELETE FROM user_mangas WHERE manga_Url = ?`, manga.Url)
+// FindChaptersPage returns the page of the history, counting from 0, and the total number of chapters
+func (repo *ChapterRepoSqlite3) FindChaptersPage(mangaUrl string, page int, pageSize int) ([]model.Chapter, int, error) {
+	if page < 0 || pageSize <= 0 {
+		return nil, 0, fmt.Errorf("invalid page %d of size %d", page, pageSize)
+	}
+
+	var total int
+	if err := repo.db.QueryRow(`SELECT COUNT(*) FROM chapters WHERE manga_url = ?`, mangaUrl).Scan(&total); err != nil {
+		logger.Log.Errorw("could not count the chapters", "manga_url", mangaUrl, "err", err)
+		return nil, 0, err
+	}
+
+	chapters, err := repo.queryChapters(
+		fmt.Sprintf(`SELECT %s FROM chapters WHERE manga_url = ? %s LIMIT ? OFFSET ?`, chapterColumns, chapterOrder),
+		mangaUrl, pageSize, page*pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	return chapters, total, nil
+}
+
+// FindChapterByNumber returns nil if the manga has no chapter with the number.
+// When more chapters have the same number the most recent is returned
+func (repo *ChapterRepoSqlite3) FindChapterByNumber(mangaUrl string, number float64) (*model.Chapter, error) {
+	chapters, err := repo.queryChapters(
+		fmt.Sprintf(`SELECT %s FROM chapters WHERE manga_url = ? AND number = ? %s LIMIT 1`, chapterColumns, chapterOrder),
+		mangaUrl, number)
+	if err != nil || len(chapters) == 0 {
+		return nil, err
+	}
+	return &chapters[0], nil
+}
+
+// FindChapterByUrl returns nil if the chapter is not saved
+func (repo *ChapterRepoSqlite3) FindChapterByUrl(url string) (*model.Chapter, error) {
+	chapters, err := repo.queryChapters(fmt.Sprintf(`SELECT %s FROM chapters WHERE url = ?`, chapterColumns), url)
+	if err != nil || len(chapters) == 0 {
+		return nil, err
+	}
+	return &chapters[0], nil
+}
+
+func (repo *ChapterRepoSqlite3) queryChapters(query string, args ...any) ([]model.Chapter, error) {
+	rows, err := repo.db.Query(query, args...)
+	if err != nil {
+		logger.Log.Errorw("could not query chapters", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	chapters := make([]model.Chapter, 0)
+	for rows.Next() {
+		var ch model.Chapter
+		var firstSeen sql.NullTime
+		if err := rows.Scan(&ch.Url, &ch.Number, &ch.Title, &ch.ReleasedAt, &firstSeen); err != nil {
+			logger.Log.Errorw("scan error in chapters", "err", err)
+			return nil, err
+		}
+		ch.FirstSeenAt = firstSeen.Time
+		chapters = append(chapters, ch)
+	}
+	return chapters, rows.Err()
+}
+
+// upsertChapter saves the chapter without touching the first time it was seen
+func upsertChapter(tx *sql.Tx, mangaUrl string, chapter *model.Chapter) error {
+	_, err := tx.Exec(`
+		INSERT INTO chapters (url, manga_url, number, title, released_at, first_seen_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(url) DO UPDATE SET
+			manga_url = excluded.manga_url,
+			number = excluded.number,
+			title = excluded.title,
+			released_at = excluded.released_at`,
+		chapter.Url, mangaUrl, chapter.Number, chapter.Title, chapter.ReleasedAt, time.Now().UTC())
 	return err
-}
\ No newline at end of file
+}
@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+)
+
+func newTestDatabase(t *testing.T) *Sqlite3Database {
+	t.Helper()
+	db, err := NewSqlite3Database(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSqlite3Database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+// chapters from n to 1, the most recent first
+func testChapters(mangaUrl string, n int) []model.Chapter {
+	released := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	chapters := make([]model.Chapter, 0, n)
+	for i := n; i >= 1; i-- {
+		chapters = append(chapters, model.Chapter{
+			Title:      "Chapter " + model.FormatChapterNumber(float64(i)),
+			Url:        mangaUrl + "/ch" + model.FormatChapterNumber(float64(i)),
+			Number:     float64(i),
+			ReleasedAt: released.AddDate(0, 0, i*7),
+		})
+	}
+	return chapters
+}
+
+func TestSaveChapters(t *testing.T) {
+	db := newTestDatabase(t)
+	berserk := model.Manga{Title: "Berserk", Url: "https://example.com/berserk"}
+	vagabond := model.Manga{Title: "Vagabond", Url: "https://example.com/vagabond"}
+	for _, m := range []model.Manga{berserk, vagabond} {
+		if err := db.MangaRepo.SaveManga(&m); err != nil {
+			t.Fatalf("SaveManga: %v", err)
+		}
+	}
+
+	newChs, err := db.ChapterRepo.SaveChapters(berserk.Url, testChapters(berserk.Url, 3))
+	if err != nil {
+		t.Fatalf("SaveChapters: %v", err)
+	}
+	if len(newChs) != 3 {
+		t.Fatalf("want 3 new chapters, got %d", len(newChs))
+	}
+
+	// two chapters released since the last save
+	newChs, err = db.ChapterRepo.SaveChapters(berserk.Url, testChapters(berserk.Url, 5))
+	if err != nil {
+		t.Fatalf("SaveChapters: %v", err)
+	}
+	if len(newChs) != 2 || newChs[0].Number != 5 || newChs[1].Number != 4 {
+		t.Fatalf("want chapters 5 and 4, got %v", newChs)
+	}
+	if newChs[0].FirstSeenAt.IsZero() {
+		t.Error("want first seen time")
+	}
+
+	// the same titles in another manga
+	if _, err := db.ChapterRepo.SaveChapters(vagabond.Url, testChapters(vagabond.Url, 2)); err != nil {
+		t.Fatalf("SaveChapters with duplicated titles: %v", err)
+	}
+
+	chapters, err := db.ChapterRepo.FindChaptersOfManga(berserk.Url)
+	if err != nil {
+		t.Fatalf("FindChaptersOfManga: %v", err)
+	}
+	if len(chapters) != 5 || chapters[0].Number != 5 || chapters[4].Number != 1 {
+		t.Fatalf("want chapters from 5 to 1, got %v", chapters)
+	}
+}
+
+func TestFindChapters(t *testing.T) {
+	db := newTestDatabase(t)
+	mangaUrl := "https://example.com/berserk"
+	if err := db.MangaRepo.SaveManga(&model.Manga{Title: "Berserk", Url: mangaUrl}); err != nil {
+		t.Fatalf("SaveManga: %v", err)
+	}
+	chapters := testChapters(mangaUrl, 5)
+	extra := model.Chapter{Title: "Chapter 2.5", Url: mangaUrl + "/ch2.5", Number: 2.5, ReleasedAt: time.Now()}
+	if _, err := db.ChapterRepo.SaveChapters(mangaUrl, append(chapters, extra)); err != nil {
+		t.Fatalf("SaveChapters: %v", err)
+	}
+
+	t.Run("Page", func(t *testing.T) {
+		page, total, err := db.ChapterRepo.FindChaptersPage(mangaUrl, 1, 4)
+		if err != nil {
+			t.Fatalf("FindChaptersPage: %v", err)
+		}
+		if total != 6 {
+			t.Errorf("want 6 chapters, got %d", total)
+		}
+		// 5 4 3 2.5 | 2 1
+		if len(page) != 2 || page[0].Number != 2 || page[1].Number != 1 {
+			t.Errorf("unexpected second page %v", page)
+		}
+		if _, _, err := db.ChapterRepo.FindChaptersPage(mangaUrl, 0, 0); err == nil {
+			t.Error("want error for empty page size")
+		}
+	})
+
+	t.Run("ByNumber", func(t *testing.T) {
+		ch, err := db.ChapterRepo.FindChapterByNumber(mangaUrl, 2.5)
+		if err != nil || ch == nil {
+			t.Fatalf("FindChapterByNumber: %v %v", ch, err)
+		}
+		if ch.Url != extra.Url {
+			t.Errorf("want %q, got %q", extra.Url, ch.Url)
+		}
+		if ch, _ := db.ChapterRepo.FindChapterByNumber(mangaUrl, 42); ch != nil {
+			t.Errorf("want nil, got %v", ch)
+		}
+	})
+
+	t.Run("ByUrl", func(t *testing.T) {
+		ch, err := db.ChapterRepo.FindChapterByUrl(chapters[0].Url)
+		if err != nil || ch == nil {
+			t.Fatalf("FindChapterByUrl: %v %v", ch, err)
+		}
+		if ch.Title != "Chapter 5" || !ch.ReleasedAt.Equal(chapters[0].ReleasedAt) {
+			t.Errorf("unexpected chapter %v", ch)
+		}
+	})
+
+	t.Run("LastChapterKeepsHistory", func(t *testing.T) {
+		first, _ := db.ChapterRepo.FindChapterByUrl(chapters[0].Url)
+		if err := db.ChapterRepo.UpdateLastChapter(&chapters[0], mangaUrl); err != nil {
+			t.Fatalf("UpdateLastChapter: %v", err)
+		}
+		// saving the manga again must not delete its chapters
+		if err := db.MangaRepo.SaveManga(&model.Manga{Title: "Berserk", Url: mangaUrl, LastChapter: &chapters[0]}); err != nil {
+			t.Fatalf("SaveManga: %v", err)
+		}
+		all, _ := db.ChapterRepo.FindChaptersOfManga(mangaUrl)
+		if len(all) != 6 {
+			t.Errorf("want 6 chapters, got %d", len(all))
+		}
+		again, _ := db.ChapterRepo.FindChapterByUrl(chapters[0].Url)
+		if !again.FirstSeenAt.Equal(first.FirstSeenAt) {
+			t.Errorf("first seen changed from %v to %v", first.FirstSeenAt, again.FirstSeenAt)
+		}
+		m, _ := db.MangaRepo.FindMangaByUrl(mangaUrl)
+		if m.LastChapter == nil || m.LastChapter.Number != 5 {
+			t.Errorf("unexpected last chapter %v", m.LastChapter)
+		}
+	})
+}
@@ -1,9 +1,13 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"strings"
 
 	"github.com/akarakai/gomanga-tbot/pkg/logger"
+	"github.com/akarakai/gomanga-tbot/pkg/model"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -11,6 +15,8 @@ type Database interface {
 	GetMangaRepo() MangaRepo
 	GetUserRepo() UserRepo
 	GetChapterRepo() ChapterRepo
+	GetSessionRepo() SessionRepo
+	GetShareRepo() ShareRepo
 	Close() error
 }
 
@@ -19,6 +25,8 @@ type Sqlite3Database struct {
 	MangaRepo   MangaRepo
 	ChapterRepo ChapterRepo
 	UserRepo    UserRepo
+	SessionRepo SessionRepo
+	ShareRepo   ShareRepo
 }
 
 func NewSqlite3Database(dbPath string) (*Sqlite3Database, error) {
@@ -40,6 +48,8 @@ func NewSqlite3Database(dbPath string) (*Sqlite3Database, error) {
 		MangaRepo:   &MangaRepoSqlite3{db: db},
 		ChapterRepo: &ChapterRepoSqlite3{db: db},
 		UserRepo:    &UserRepoSqlite3{db: db},
+		SessionRepo: &SessionRepoSqlite3{db: db},
+		ShareRepo:   &ShareRepoSqlite3{db: db},
 	}, nil
 
 }
@@ -62,6 +72,20 @@ func (s *Sqlite3Database) GetUserRepo() UserRepo {
 	return s.UserRepo
 }
 
+func (s *Sqlite3Database) GetSessionRepo() SessionRepo {
+	if s.SessionRepo == nil {
+		logger.Log.Panicln("session repo not initialized")
+	}
+	return s.SessionRepo
+}
+
+func (s *Sqlite3Database) GetShareRepo() ShareRepo {
+	if s.ShareRepo == nil {
+		logger.Log.Panicln("share repo not initialized")
+	}
+	return s.ShareRepo
+}
+
 func (s *Sqlite3Database) GetMangaRepo() MangaRepo {
 	if s.MangaRepo == nil {
 		logger.Log.Panicln("chapter repo not initialized")
@@ -75,30 +99,22 @@ func loadTables(db *sql.DB) {
 	db.Exec(`PRAGMA foreign_keys = ON;`)
 
 	// Create chapters table
-		db.Exec(`
-		CREATE TABLE IF NOT EXISTS chapters (
-			url TEXT PRIMARY KEY,
-			title TEXT NOT NULL UNIQUE,
-			released_at DATETIME NOT NULL
-		);`)
+	db.Exec(fmt.Sprintf(createChaptersTable, "chapters"))
 
-		// Create mangas table
-		db.Exec(`
-		CREATE TABLE IF NOT EXISTS mangas (
-			url TEXT PRIMARY KEY,
-			title TEXT NOT NULL UNIQUE,
-			last_chapter TEXT,
-			FOREIGN KEY (last_chapter) REFERENCES chapters(url) ON DELETE SET NULL
-		);`)
+	// Create mangas table
+	db.Exec(fmt.Sprintf(createMangasTable, "mangas"))
 
-		// Create users table
-		db.Exec(`
+	// Create users table
+	db.Exec(`
 		CREATE TABLE IF NOT EXISTS users (
-			chat_id INTEGER NOT NULL PRIMARY KEY
+			chat_id INTEGER NOT NULL PRIMARY KEY,
+			format TEXT NOT NULL DEFAULT 'pdf',
+			profile TEXT NOT NULL DEFAULT 'original',
+			webtoon INTEGER NOT NULL DEFAULT 0
 		);`)
 
-		// Create user_mangas join table (many-to-many)
-		db.Exec(`
+	// Create user_mangas join table (many-to-many)
+	db.Exec(`
 		CREATE TABLE IF NOT EXISTS user_mangas (
 			chat_id INTEGER NOT NULL,
 			manga_url TEXT NOT NULL,
@@ -106,7 +122,211 @@ func loadTables(db *sql.DB) {
 			FOREIGN KEY (chat_id) REFERENCES users(chat_id) ON DELETE CASCADE,
 			FOREIGN KEY (manga_url) REFERENCES mangas(url) ON DELETE CASCADE
 		);`)
+
+	// conversations in progress, expires_at is in unix milliseconds
+	db.Exec(`
+		CREATE TABLE IF NOT EXISTS sessions (
+			conversation TEXT NOT NULL,
+			chat_id INTEGER NOT NULL,
+			state TEXT NOT NULL,
+			data BLOB NOT NULL,
+			expires_at INTEGER NOT NULL,
+			PRIMARY KEY (conversation, chat_id)
+		);`)
+
+	// mangas shared with the inline mode, the links of the messages refer to them by key
+	db.Exec(`
+		CREATE TABLE IF NOT EXISTS shared_mangas (
+			key TEXT PRIMARY KEY,
+			url TEXT NOT NULL,
+			title TEXT NOT NULL,
+			source TEXT NOT NULL DEFAULT ''
+		);`)
+
+	// authors, genres and alternative titles of the mangas, in the order of the site
+	for _, table := range mangaListTables {
+		db.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			manga_url TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			PRIMARY KEY (manga_url, position),
+			FOREIGN KEY (manga_url) REFERENCES mangas(url) ON DELETE CASCADE
+		);`, table))
+	}
+
+	// columns added after the first release. Databases created before need them too
+	addColumnIfMissing(db, "mangas", "source", "TEXT NOT NULL DEFAULT 'weebcentral'")
+	addColumnIfMissing(db, "mangas", "disabled", "INTEGER NOT NULL DEFAULT 0")
+	addColumnIfMissing(db, "mangas", "cover_url", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfMissing(db, "mangas", "status", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfMissing(db, "mangas", "description", "TEXT NOT NULL DEFAULT ''")
+	addColumnIfMissing(db, "users", "format", "TEXT NOT NULL DEFAULT 'pdf'")
+	addColumnIfMissing(db, "users", "profile", "TEXT NOT NULL DEFAULT 'original'")
+	addColumnIfMissing(db, "users", "webtoon", "INTEGER NOT NULL DEFAULT 0")
+
+	// the same title can be found in more sources, the title is not unique anymore
+	if tableDefinitionContains(db, "mangas", "title TEXT NOT NULL UNIQUE") {
+		rebuildTable(db, "mangas", createMangasTable, "url, title, last_chapter, source, disabled, cover_url, status, description")
+	}
+
+	// every manga has a "Chapter 1", the chapters became the history of the mangas
+	if tableDefinitionContains(db, "chapters", "title TEXT NOT NULL UNIQUE") {
+		rebuildTable(db, "chapters", createChaptersTable, "url, title, released_at")
+		backfillChapters(db)
+	}
+	db.Exec(`CREATE INDEX IF NOT EXISTS chapters_manga ON chapters (manga_url, number);`)
+}
+
+// chapters of the old schema only were the last chapter of a manga, without number
+func backfillChapters(db *sql.DB) {
+	_, err := db.Exec(`
+		UPDATE chapters SET manga_url = (SELECT m.url FROM mangas m WHERE m.last_chapter = chapters.url)
+		WHERE manga_url IS NULL`)
+	if err != nil {
+		logger.Log.Errorw("could not backfill manga of the chapters", "err", err)
+	}
+
+	rows, err := db.Query(`SELECT url, title FROM chapters WHERE number = ?`, model.NoChapterNumber)
+	if err != nil {
+		logger.Log.Errorw("could not read chapters without number", "err", err)
+		return
+	}
+	numbers := make(map[string]float64)
+	for rows.Next() {
+		var url, title string
+		if err := rows.Scan(&url, &title); err != nil {
+			logger.Log.Errorw("could not scan chapter", "err", err)
+			break
+		}
+		numbers[url] = model.ParseChapterNumber(title)
+	}
+	_ = rows.Close()
+
+	for url, number := range numbers {
+		if _, err := db.Exec(`UPDATE chapters SET number = ? WHERE url = ?`, number, url); err != nil {
+			logger.Log.Errorw("could not backfill chapter number", "url", url, "err", err)
+		}
+	}
+}
+
+const (
+	mangaAuthorsTable   = "manga_authors"
+	mangaGenresTable    = "manga_genres"
+	mangaAltTitlesTable = "manga_alt_titles"
+)
+
+var mangaListTables = []string{mangaAuthorsTable, mangaGenresTable, mangaAltTitlesTable}
+
+// %s is the name of the table, so that the definition can be used for rebuilding it.
+// The chapters are written before their manga, the check of the manga is deferred to the commit
+const createChaptersTable = `
+		CREATE TABLE IF NOT EXISTS %s (
+			url TEXT PRIMARY KEY,
+			manga_url TEXT,
+			number REAL NOT NULL DEFAULT -1,
+			title TEXT NOT NULL,
+			released_at DATETIME NOT NULL,
+			first_seen_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (manga_url) REFERENCES mangas(url) ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED
+		);`
+
+// %s is the name of the table, so that the definition can be used for rebuilding it
+const createMangasTable = `
+		CREATE TABLE IF NOT EXISTS %s (
+			url TEXT PRIMARY KEY,
+			title TEXT NOT NULL,
+			last_chapter TEXT,
+			source TEXT NOT NULL DEFAULT 'weebcentral',
+			disabled INTEGER NOT NULL DEFAULT 0,
+			cover_url TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT '',
+			description TEXT NOT NULL DEFAULT '',
+			FOREIGN KEY (last_chapter) REFERENCES chapters(url) ON DELETE SET NULL
+		);`
+
+func tableDefinitionContains(db *sql.DB, table string, fragment string) bool {
+	var definition string
+	err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&definition)
+	if err != nil {
+		logger.Log.Errorw("could not read table definition", "table", table, "err", err)
+		return false
+	}
+	return strings.Contains(definition, fragment)
+}
+
+// rebuildTable is the way sqlite changes the constraints of a table: the data is copied
+// in a new table created with createTable, then the old one is replaced.
+// Foreign keys are disabled meanwhile, otherwise dropping the old table cascades
+func rebuildTable(db *sql.DB, table string, createTable string, columns string) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		logger.Log.Errorw("could not get a connection for rebuilding", "table", table, "err", err)
+		return
+	}
+	defer conn.Close()
+
+	tmp := table + "_new"
+	_, _ = conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF;`)
+	defer func() { _, _ = conn.ExecContext(ctx, `PRAGMA foreign_keys = ON;`) }()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Log.Errorw("could not begin rebuild", "table", table, "err", err)
+		return
 	}
+	stmts := []string{
+		fmt.Sprintf(createTable, tmp),
+		fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", tmp, columns, columns, table),
+		fmt.Sprintf("DROP TABLE %s", table),
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", tmp, table),
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			_ = tx.Rollback()
+			logger.Log.Errorw("could not rebuild table", "table", table, "err", err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Log.Errorw("could not commit rebuild", "table", table, "err", err)
+		return
+	}
+	logger.Log.Infow("table rebuilt", "table", table)
+}
+
+// addColumnIfMissing alters the table only if the column does not exist yet
+func addColumnIfMissing(db *sql.DB, table string, column string, definition string) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		logger.Log.Errorw("could not read table info", "table", table, "err", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name, ctyp string
+			notNull    int
+			dflt       sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &ctyp, &notNull, &dflt, &pk); err != nil {
+			logger.Log.Errorw("could not scan table info", "table", table, "err", err)
+			return
+		}
+		if name == column {
+			return
+		}
+	}
+	rows.Close()
+
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		logger.Log.Errorw("could not add column", "table", table, "column", column, "err", err)
+	}
+}
 
 // func removeDatabaseTestFile() error {
 // 	logger.Log.Debugln("removing test.db")
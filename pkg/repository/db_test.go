@@ -3,8 +3,11 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"path/filepath"
 	"testing"
 
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -31,3 +34,89 @@ func TestDatabase(t *testing.T) {
 		t.Errorf("could not create table: %s", err)
 	}
 }
+
+func TestMigrateOldMangasTable(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "old.db")
+	old, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	// schema of the first release
+	_, err = old.Exec(`
+		CREATE TABLE chapters (
+			url TEXT PRIMARY KEY,
+			title TEXT NOT NULL UNIQUE,
+			released_at DATETIME NOT NULL
+		);
+		CREATE TABLE mangas (
+			url TEXT PRIMARY KEY,
+			title TEXT NOT NULL UNIQUE,
+			last_chapter TEXT,
+			FOREIGN KEY (last_chapter) REFERENCES chapters(url) ON DELETE SET NULL
+		);
+		CREATE TABLE users (chat_id INTEGER NOT NULL PRIMARY KEY);
+		CREATE TABLE user_mangas (
+			chat_id INTEGER NOT NULL,
+			manga_url TEXT NOT NULL,
+			PRIMARY KEY (chat_id, manga_url),
+			FOREIGN KEY (chat_id) REFERENCES users(chat_id) ON DELETE CASCADE,
+			FOREIGN KEY (manga_url) REFERENCES mangas(url) ON DELETE CASCADE
+		);
+		INSERT INTO chapters (url, title, released_at) VALUES ('https://weebcentral.com/chapters/1', 'Chapter 376', '2025-01-01 00:00:00');
+		INSERT INTO mangas (url, title, last_chapter) VALUES ('https://weebcentral.com/series/1', 'Berserk', 'https://weebcentral.com/chapters/1');
+		INSERT INTO users (chat_id) VALUES (1);
+		INSERT INTO user_mangas (chat_id, manga_url) VALUES (1, 'https://weebcentral.com/series/1');
+	`)
+	if err != nil {
+		t.Fatalf("create old schema: %v", err)
+	}
+	_ = old.Close()
+
+	db, err := NewSqlite3Database(dbPath)
+	if err != nil {
+		t.Fatalf("NewSqlite3Database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	mangas, err := db.MangaRepo.FindMangasOfUser(1)
+	if err != nil {
+		t.Fatalf("FindMangasOfUser: %v", err)
+	}
+	if len(mangas) != 1 {
+		t.Fatalf("subscription lost in migration, got %d mangas", len(mangas))
+	}
+	if mangas[0].Source != "weebcentral" {
+		t.Errorf("expected default source weebcentral, got %q", mangas[0].Source)
+	}
+
+	user, err := db.UserRepo.FindUserByChatID(1)
+	if err != nil || user == nil {
+		t.Fatalf("FindUserByChatID: %v %v", user, err)
+	}
+	if user.Format != model.FormatPDF {
+		t.Errorf("expected default format pdf, got %q", user.Format)
+	}
+
+	// the last chapter became part of the history of the manga
+	chapters, err := db.ChapterRepo.FindChaptersOfManga("https://weebcentral.com/series/1")
+	if err != nil {
+		t.Fatalf("FindChaptersOfManga: %v", err)
+	}
+	if len(chapters) != 1 || chapters[0].Number != 376 {
+		t.Fatalf("chapter not migrated, got %v", chapters)
+	}
+
+	// same title from another source
+	err = db.MangaRepo.SaveManga(&model.Manga{
+		Title:  "Berserk",
+		Url:    "https://mangadex.org/title/1",
+		Source: "mangadex",
+		LastChapter: &model.Chapter{
+			Title: "Chapter 376",
+			Url:   "https://mangadex.org/chapter/1",
+		},
+	})
+	if err != nil {
+		t.Fatalf("SaveManga with duplicated title: %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 
 	"github.com/akarakai/gomanga-tbot/pkg/logger"
 	"github.com/akarakai/gomanga-tbot/pkg/model"
@@ -13,6 +14,8 @@ type MangaRepo interface {
 	FindMangaByUrl(url string) (*model.Manga, error)
 	FindMangasOfUser(chatID model.ChatID) ([]model.Manga, error)
 	FindAllMangas() ([]model.Manga, error)
+	DisableManga(url string) error
+	DeleteUnfollowedMangas() (int, error)
 }
 
 type MangaRepoSqlite3 struct {
@@ -28,13 +31,7 @@ func (repo *MangaRepoSqlite3) SaveManga(manga *model.Manga) error {
 
 	// If there’s a LastChapter, insert it
 	if manga.LastChapter != nil {
-		_, err = tx.Exec(`
-			INSERT OR REPLACE INTO chapters (url, title, released_at)
-			VALUES (?, ?, ?)`,
-			manga.LastChapter.Url,
-			manga.LastChapter.Title,
-			manga.LastChapter.ReleasedAt,
-		)
+		err = upsertChapter(tx, manga.Url, manga.LastChapter)
 		if err != nil {
 			_ = tx.Rollback()
 			logger.Log.Errorw("error when saving chapter", "chapter", manga.LastChapter, "err", err)
@@ -44,8 +41,16 @@ func (repo *MangaRepoSqlite3) SaveManga(manga *model.Manga) error {
 
 	// Insert manga
 	_, err = tx.Exec(`
-		INSERT OR REPLACE INTO mangas (url, title, last_chapter)
-		VALUES (?, ?, ?)`,
+		INSERT INTO mangas (url, title, last_chapter, source, cover_url, status, description)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(url) DO UPDATE SET
+			title = excluded.title,
+			last_chapter = excluded.last_chapter,
+			source = excluded.source,
+			cover_url = excluded.cover_url,
+			status = excluded.status,
+			description = excluded.description,
+			disabled = 0`,
 		manga.Url,
 		manga.Title,
 		func() interface{} {
@@ -54,6 +59,10 @@ func (repo *MangaRepoSqlite3) SaveManga(manga *model.Manga) error {
 			}
 			return nil
 		}(),
+		mangaSource(manga),
+		manga.CoverUrl,
+		string(manga.Status),
+		manga.Description,
 	)
 	if err != nil {
 		_ = tx.Rollback()
@@ -61,6 +70,19 @@ func (repo *MangaRepoSqlite3) SaveManga(manga *model.Manga) error {
 		return err
 	}
 
+	lists := map[string][]string{
+		mangaAuthorsTable:   manga.Authors,
+		mangaGenresTable:    manga.Genres,
+		mangaAltTitlesTable: manga.AltTitles,
+	}
+	for table, names := range lists {
+		if err := saveMangaList(tx, table, manga.Url, names); err != nil {
+			_ = tx.Rollback()
+			logger.Log.Errorw("error when saving manga metadata", "table", table, "manga", manga.Title, "err", err)
+			return err
+		}
+	}
+
 	if err = tx.Commit(); err != nil {
 		return err
 	}
@@ -71,7 +93,7 @@ func (repo *MangaRepoSqlite3) SaveManga(manga *model.Manga) error {
 
 func (repo *MangaRepoSqlite3) FindMangasOfUser(chatID model.ChatID) ([]model.Manga, error) {
 	rows, err := repo.db.Query(`
-		SELECT m.url, m.title, c.url, c.title, c.released_at
+		SELECT m.url, m.title, m.source, m.cover_url, m.status, m.description, c.url, c.number, c.title, c.released_at
 		FROM mangas m
 		JOIN user_mangas um ON um.manga_url = m.url
 		JOIN users u ON u.chat_id = um.chat_id
@@ -87,9 +109,10 @@ func (repo *MangaRepoSqlite3) FindMangasOfUser(chatID model.ChatID) ([]model.Man
 	for rows.Next() {
 		var m model.Manga
 		var chURL, chTitle sql.NullString
+		var chNumber sql.NullFloat64
 		var chReleased sql.NullTime
 
-		if err := rows.Scan(&m.Url, &m.Title, &chURL, &chTitle, &chReleased); err != nil {
+		if err := rows.Scan(&m.Url, &m.Title, &m.Source, &m.CoverUrl, &m.Status, &m.Description, &chURL, &chNumber, &chTitle, &chReleased); err != nil {
 			return nil, err
 		}
 
@@ -97,6 +120,7 @@ func (repo *MangaRepoSqlite3) FindMangasOfUser(chatID model.ChatID) ([]model.Man
 			m.LastChapter = &model.Chapter{
 				Url:        chURL.String,
 				Title:      chTitle.String,
+				Number:     chNumber.Float64,
 				ReleasedAt: chReleased.Time,
 			}
 		}
@@ -107,10 +131,10 @@ func (repo *MangaRepoSqlite3) FindMangasOfUser(chatID model.ChatID) ([]model.Man
 
 func (repo *MangaRepoSqlite3) FindMangaByUrl(url string) (*model.Manga, error) {
 	row, err := repo.db.Query(`
-		SELECT m.url, m.title, c.url, c.title, c.released_at
+		SELECT m.url, m.title, m.source, m.cover_url, m.status, m.description, c.url, c.number, c.title, c.released_at
 		FROM mangas m
-		JOIN chapters c ON m.last_chapter = c.url
-		WHERE c.url = ?
+		LEFT JOIN chapters c ON m.last_chapter = c.url
+		WHERE m.url = ?
 `, url)
 	if err != nil {
 		logger.Log.Errorw("error when finding manga by url", "url", url, "err", err)
@@ -131,34 +155,52 @@ func (repo *MangaRepoSqlite3) FindMangaByUrl(url string) (*model.Manga, error) {
 
 	var mangaURL sql.NullString
 	var mangaTitle sql.NullString
+	var mangaSource sql.NullString
+	var coverURL, status, description sql.NullString
 	var chapterURL sql.NullString
+	var chapterNumber sql.NullFloat64
 	var chapterTitle sql.NullString
 	var chapterReleased sql.NullTime
 
 	// Now it's safe to scan the row
-	if err := row.Scan(&mangaURL, &mangaTitle, &chapterURL, &chapterTitle, &chapterReleased); err != nil {
+	if err := row.Scan(&mangaURL, &mangaTitle, &mangaSource, &coverURL, &status, &description, &chapterURL, &chapterNumber, &chapterTitle, &chapterReleased); err != nil {
 		logger.Log.Errorw("error when scanning manga row", "err", err)
 		return nil, err
 	}
 
-	logger.Log.Debugw("manga found successfully by url", "mangaTitle", mangaTitle, "lastCh", chapterTitle)
-	return &model.Manga{
-		Title: mangaTitle.String,
-		Url:   mangaURL.String,
-		LastChapter: &model.Chapter{
+	manga := &model.Manga{
+		Title:       mangaTitle.String,
+		Url:         mangaURL.String,
+		Source:      mangaSource.String,
+		CoverUrl:    coverURL.String,
+		Status:      model.MangaStatus(status.String),
+		Description: description.String,
+	}
+	if chapterURL.Valid {
+		manga.LastChapter = &model.Chapter{
 			Title:      chapterTitle.String,
 			Url:        chapterURL.String,
+			Number:     chapterNumber.Float64,
 			ReleasedAt: chapterReleased.Time,
-		},
-	}, nil
+		}
+	}
+	// the rows must be closed before querying again
+	_ = row.Close()
+	if err := repo.loadMangaLists(manga); err != nil {
+		return nil, err
+	}
+
+	logger.Log.Debugw("manga found successfully by url", "mangaTitle", mangaTitle, "lastCh", chapterTitle)
+	return manga, nil
 }
 func (repo *MangaRepoSqlite3) FindAllMangas() ([]model.Manga, error) {
 	rows, err := repo.db.Query(`
 		SELECT 
-			m.url, m.title, 
-			c.url, c.title, c.released_at
+			m.url, m.title, m.source, m.cover_url, m.status, m.description,
+			c.url, c.number, c.title, c.released_at
 		FROM mangas m
 		LEFT JOIN chapters c ON m.last_chapter = c.url
+		WHERE m.disabled = 0
 	`)
 	if err != nil {
 		logger.Log.Errorw("could not retrieve all mangas", "err", err)
@@ -175,10 +217,11 @@ func (repo *MangaRepoSqlite3) FindAllMangas() ([]model.Manga, error) {
 		var m model.Manga
 		var c model.Chapter
 		var chapterUrl, chapterTitle sql.NullString
+		var chapterNumber sql.NullFloat64
 		var releasedAt sql.NullTime
 
 		// Scan values into temporary vars so we can handle NULLs properly
-		err := rows.Scan(&m.Url, &m.Title, &chapterUrl, &chapterTitle, &releasedAt)
+		err := rows.Scan(&m.Url, &m.Title, &m.Source, &m.CoverUrl, &m.Status, &m.Description, &chapterUrl, &chapterNumber, &chapterTitle, &releasedAt)
 		if err != nil {
 			logger.Log.Errorw("scan error in FindAllMangas", "err", err)
 			return nil, err
@@ -187,6 +230,7 @@ func (repo *MangaRepoSqlite3) FindAllMangas() ([]model.Manga, error) {
 		if chapterUrl.Valid {
 			c.Url = chapterUrl.String
 			c.Title = chapterTitle.String
+			c.Number = chapterNumber.Float64
 			if releasedAt.Valid {
 				c.ReleasedAt = releasedAt.Time
 			}
@@ -205,3 +249,100 @@ func (repo *MangaRepoSqlite3) FindAllMangas() ([]model.Manga, error) {
 
 	return mangas, nil
 }
+
+// DisableManga excludes the manga from FindAllMangas, so that the updater does not check it anymore.
+// Saving the manga again enables it
+func (repo *MangaRepoSqlite3) DisableManga(url string) error {
+	_, err := repo.db.Exec(`UPDATE mangas SET disabled = 1 WHERE url = ?`, url)
+	if err != nil {
+		logger.Log.Errorw("could not disable manga", "url", url, "err", err)
+		return err
+	}
+	logger.Log.Infow("manga disabled", "url", url)
+	return nil
+}
+
+// DeleteUnfollowedMangas deletes the mangas no user is subscribed to, with their chapters,
+// so that the updater stops scraping them. The number of deleted mangas is returned
+func (repo *MangaRepoSqlite3) DeleteUnfollowedMangas() (int, error) {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	const unfollowed = `SELECT url FROM mangas WHERE url NOT IN (SELECT manga_url FROM user_mangas)`
+	// the foreign keys are not enabled on every connection, the dependent rows are deleted explicitly
+	stmts := []string{`DELETE FROM chapters WHERE manga_url IN (` + unfollowed + `)`}
+	for _, table := range mangaListTables {
+		stmts = append(stmts, fmt.Sprintf(`DELETE FROM %s WHERE manga_url IN (%s)`, table, unfollowed))
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			_ = tx.Rollback()
+			logger.Log.Errorw("could not delete the data of unfollowed mangas", "err", err)
+			return 0, err
+		}
+	}
+	res, err := tx.Exec(`DELETE FROM mangas WHERE url NOT IN (SELECT manga_url FROM user_mangas)`)
+	if err != nil {
+		_ = tx.Rollback()
+		logger.Log.Errorw("could not delete unfollowed mangas", "err", err)
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	n, _ := res.RowsAffected()
+	logger.Log.Infow("unfollowed mangas deleted", "count", n)
+	return int(n), nil
+}
+
+// saveMangaList replaces the names of the manga saved in one of the list tables
+func saveMangaList(tx *sql.Tx, table string, mangaURL string, names []string) error {
+	if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE manga_url = ?`, table), mangaURL); err != nil {
+		return err
+	}
+	for i, name := range names {
+		_, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s (manga_url, position, name) VALUES (?, ?, ?)`, table), mangaURL, i, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadMangaLists reads authors, genres and alternative titles of the manga.
+// Only FindMangaByUrl loads them, the lists of mangas do not need them
+func (repo *MangaRepoSqlite3) loadMangaLists(manga *model.Manga) error {
+	lists := map[string]*[]string{
+		mangaAuthorsTable:   &manga.Authors,
+		mangaGenresTable:    &manga.Genres,
+		mangaAltTitlesTable: &manga.AltTitles,
+	}
+	for table, names := range lists {
+		rows, err := repo.db.Query(fmt.Sprintf(`SELECT name FROM %s WHERE manga_url = ? ORDER BY position`, table), manga.Url)
+		if err != nil {
+			logger.Log.Errorw("could not load manga metadata", "table", table, "url", manga.Url, "err", err)
+			return err
+		}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			*names = append(*names, name)
+		}
+		_ = rows.Close()
+	}
+	return nil
+}
+
+// mangas saved before the introduction of the sources all come from WeebCentral
+func mangaSource(manga *model.Manga) string {
+	if manga.Source == "" {
+		return "weebcentral"
+	}
+	return manga.Source
+}
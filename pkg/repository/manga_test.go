@@ -2,6 +2,7 @@ package repository
 
 import (
 	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
@@ -29,6 +30,7 @@ func TestSaveManga(t *testing.T) {
 	mg := model.Manga{
 		Title:       "Berserk",
 		Url:         "https://example.com/berserk",
+		Source:      "mangadex",
 		LastChapter: &ch,
 	}
 
@@ -54,6 +56,15 @@ func TestSaveManga(t *testing.T) {
 		t.Fatalf("last_chapter mismatch: got %q want %q", lc, ch.Url)
 	}
 
+	// source is recorded
+	var src string
+	if err := db.db.QueryRow(`SELECT source FROM mangas WHERE url = ?`, mg.Url).Scan(&src); err != nil {
+		t.Fatalf("select source: %v", err)
+	}
+	if src != mg.Source {
+		t.Fatalf("source mismatch: got %q want %q", src, mg.Source)
+	}
+
 	// chapter exists
 	var cCount int
 	if err := db.db.QueryRow(`SELECT COUNT(*) FROM chapters WHERE url = ?`, ch.Url).Scan(&cCount); err != nil {
@@ -63,3 +74,150 @@ func TestSaveManga(t *testing.T) {
 		t.Fatalf("want 1 chapter, got %d", cCount)
 	}
 }
+
+func TestDisableManga(t *testing.T) {
+	db, err := NewSqlite3Database(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSqlite3Database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	for _, url := range []string{"https://example.com/berserk", "https://example.com/vagabond"} {
+		if err := db.MangaRepo.SaveManga(&model.Manga{Title: url, Url: url}); err != nil {
+			t.Fatalf("SaveManga: %v", err)
+		}
+	}
+	if err := db.MangaRepo.DisableManga("https://example.com/berserk"); err != nil {
+		t.Fatalf("DisableManga: %v", err)
+	}
+
+	mangas, err := db.MangaRepo.FindAllMangas()
+	if err != nil {
+		t.Fatalf("FindAllMangas: %v", err)
+	}
+	if len(mangas) != 1 || mangas[0].Url != "https://example.com/vagabond" {
+		t.Fatalf("want only vagabond, got %v", mangas)
+	}
+
+	// saving it again enables it
+	if err := db.MangaRepo.SaveManga(&model.Manga{Title: "Berserk", Url: "https://example.com/berserk"}); err != nil {
+		t.Fatalf("SaveManga: %v", err)
+	}
+	if mangas, _ = db.MangaRepo.FindAllMangas(); len(mangas) != 2 {
+		t.Fatalf("want 2 mangas, got %d", len(mangas))
+	}
+}
+
+func TestSaveMangaMetadata(t *testing.T) {
+	db, err := NewSqlite3Database(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSqlite3Database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	mg := model.Manga{
+		Title:       "Berserk",
+		Url:         "https://example.com/berserk",
+		CoverUrl:    "https://example.com/berserk.jpg",
+		Authors:     []string{"Miura Kentarou", "Studio Gaga"},
+		Genres:      []string{"Action", "Horror"},
+		Status:      model.StatusHiatus,
+		AltTitles:   []string{"Beruseruku"},
+		Description: "Guts is out for revenge.",
+		LastChapter: &model.Chapter{Title: "chapter 10", Url: "https://example.com/berserk/ch10", ReleasedAt: time.Now()},
+	}
+	if err := db.MangaRepo.SaveManga(&mg); err != nil {
+		t.Fatalf("SaveManga: %v", err)
+	}
+
+	found, err := db.MangaRepo.FindMangaByUrl(mg.Url)
+	if err != nil || found == nil {
+		t.Fatalf("FindMangaByUrl: %v %v", found, err)
+	}
+	if found.CoverUrl != mg.CoverUrl || found.Status != mg.Status || found.Description != mg.Description {
+		t.Errorf("metadata mismatch: %+v", found)
+	}
+	if !slices.Equal(found.Authors, mg.Authors) || !slices.Equal(found.Genres, mg.Genres) || !slices.Equal(found.AltTitles, mg.AltTitles) {
+		t.Errorf("lists mismatch: %v %v %v", found.Authors, found.Genres, found.AltTitles)
+	}
+	if found.LastChapter == nil || found.LastChapter.Url != mg.LastChapter.Url {
+		t.Errorf("last chapter mismatch: %v", found.LastChapter)
+	}
+
+	// saving again replaces the lists
+	mg.Genres = []string{"Dark Fantasy"}
+	if err := db.MangaRepo.SaveManga(&mg); err != nil {
+		t.Fatalf("SaveManga: %v", err)
+	}
+	if found, _ = db.MangaRepo.FindMangaByUrl(mg.Url); !slices.Equal(found.Genres, mg.Genres) {
+		t.Errorf("want genres %v, got %v", mg.Genres, found.Genres)
+	}
+
+	if missing, err := db.MangaRepo.FindMangaByUrl("https://example.com/unknown"); err != nil || missing != nil {
+		t.Errorf("want nil manga, got %v %v", missing, err)
+	}
+}
+
+func TestRemoveMangaOfUser(t *testing.T) {
+	db := newTestDatabase(t)
+	berserk := "https://example.com/berserk"
+	vagabond := "https://example.com/vagabond"
+	for _, url := range []string{berserk, vagabond} {
+		ch := model.Chapter{Title: "Chapter 1", Url: url + "/ch1", Number: 1, ReleasedAt: time.Now()}
+		if err := db.MangaRepo.SaveManga(&model.Manga{Title: url, Url: url, Genres: []string{"Action"}, LastChapter: &ch}); err != nil {
+			t.Fatalf("SaveManga: %v", err)
+		}
+	}
+	for _, chatID := range []model.ChatID{1, 2} {
+		if err := db.UserRepo.SaveUser(chatID); err != nil {
+			t.Fatalf("SaveUser: %v", err)
+		}
+		if err := db.UserRepo.SaveManga(chatID, berserk); err != nil {
+			t.Fatalf("SaveManga of user: %v", err)
+		}
+	}
+	if err := db.UserRepo.SaveManga(1, vagabond); err != nil {
+		t.Fatalf("SaveManga of user: %v", err)
+	}
+
+	// berserk is still followed by the user 2
+	if err := db.UserRepo.RemoveManga(1, berserk); err != nil {
+		t.Fatalf("RemoveManga: %v", err)
+	}
+	if n, err := db.MangaRepo.DeleteUnfollowedMangas(); err != nil || n != 0 {
+		t.Fatalf("want 0 mangas deleted, got %d %v", n, err)
+	}
+	mangas, _ := db.MangaRepo.FindMangasOfUser(2)
+	if len(mangas) != 1 {
+		t.Fatalf("subscription of user 2 lost, got %v", mangas)
+	}
+	if mangas, _ = db.MangaRepo.FindMangasOfUser(1); len(mangas) != 1 || mangas[0].Url != vagabond {
+		t.Fatalf("want only vagabond for user 1, got %v", mangas)
+	}
+
+	if err := db.UserRepo.RemoveManga(1, berserk); err == nil {
+		t.Error("want error removing a manga not followed")
+	}
+
+	// nobody follows vagabond anymore
+	if err := db.UserRepo.RemoveManga(1, vagabond); err != nil {
+		t.Fatalf("RemoveManga: %v", err)
+	}
+	if n, err := db.MangaRepo.DeleteUnfollowedMangas(); err != nil || n != 1 {
+		t.Fatalf("want 1 manga deleted, got %d %v", n, err)
+	}
+	if m, _ := db.MangaRepo.FindMangaByUrl(vagabond); m != nil {
+		t.Errorf("vagabond not deleted")
+	}
+	if chs, _ := db.ChapterRepo.FindChaptersOfManga(vagabond); len(chs) != 0 {
+		t.Errorf("chapters of vagabond not deleted, got %d", len(chs))
+	}
+	var genres int
+	_ = db.db.QueryRow(`SELECT COUNT(*) FROM manga_genres WHERE manga_url = ?`, vagabond).Scan(&genres)
+	if genres != 0 {
+		t.Errorf("genres of vagabond not deleted")
+	}
+	if m, _ := db.MangaRepo.FindMangaByUrl(berserk); m == nil {
+		t.Errorf("berserk deleted")
+	}
+}
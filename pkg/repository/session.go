@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/akarakai/gomanga-tbot/pkg/logger"
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+)
+
+// SessionRepo keeps the conversations in progress, so that they continue after a restart of the bot
+type SessionRepo interface {
+	SaveSession(session model.Session) error
+	FindSession(conversation string, chatID model.ChatID) (*model.Session, error)
+	DeleteSession(conversation string, chatID model.ChatID) error
+	DeleteExpiredSessions(conversation string, now time.Time) (int64, error)
+}
+
+type SessionRepoSqlite3 struct {
+	db *sql.DB
+}
+
+// SaveSession inserts the session or replaces the one of the same conversation and chat
+func (repo *SessionRepoSqlite3) SaveSession(session model.Session) error {
+	_, err := repo.db.Exec(`
+		INSERT INTO sessions (conversation, chat_id, state, data, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (conversation, chat_id) DO UPDATE SET
+			state = excluded.state, data = excluded.data, expires_at = excluded.expires_at
+	`, session.Conversation, session.ChatID, session.State, session.Data, session.ExpiresAt.UnixMilli())
+	if err != nil {
+		logger.Log.Errorw("error when saving session", "conversation", session.Conversation, "chat_id", session.ChatID, "err", err)
+		return err
+	}
+	logger.Log.Debugw("session saved", "conversation", session.Conversation, "chat_id", session.ChatID, "state", session.State)
+	return nil
+}
+
+// FindSession returns nil if the chat has no session of the conversation. Expired sessions are returned too
+func (repo *SessionRepoSqlite3) FindSession(conversation string, chatID model.ChatID) (*model.Session, error) {
+	row := repo.db.QueryRow(`
+		SELECT state, data, expires_at FROM sessions
+		WHERE conversation = ? AND chat_id = ?
+	`, conversation, chatID)
+
+	session := model.Session{Conversation: conversation, ChatID: chatID}
+	var expiresAt int64
+	if err := row.Scan(&session.State, &session.Data, &expiresAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		logger.Log.Errorw("error when scanning session row", "conversation", conversation, "chat_id", chatID, "err", err)
+		return nil, err
+	}
+	session.ExpiresAt = time.UnixMilli(expiresAt)
+	return &session, nil
+}
+
+func (repo *SessionRepoSqlite3) DeleteSession(conversation string, chatID model.ChatID) error {
+	_, err := repo.db.Exec(`
+		DELETE FROM sessions WHERE conversation = ? AND chat_id = ?
+	`, conversation, chatID)
+	if err != nil {
+		logger.Log.Errorw("error when deleting session", "conversation", conversation, "chat_id", chatID, "err", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteExpiredSessions deletes the sessions of the conversation expired before now and returns how many
+func (repo *SessionRepoSqlite3) DeleteExpiredSessions(conversation string, now time.Time) (int64, error) {
+	res, err := repo.db.Exec(`
+		DELETE FROM sessions WHERE conversation = ? AND expires_at < ?
+	`, conversation, now.UnixMilli())
+	if err != nil {
+		logger.Log.Errorw("error when deleting expired sessions", "conversation", conversation, "err", err)
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+)
+
+func TestSessions(t *testing.T) {
+	db := newTestDatabase(t)
+	repo := db.SessionRepo
+	now := time.Now()
+
+	if session, err := repo.FindSession("add", 1); err != nil || session != nil {
+		t.Fatalf("Expected no session, got %v %v", session, err)
+	}
+
+	session := model.Session{Conversation: "add", ChatID: 1, State: "chosen_manga", Data: []byte(`{}`), ExpiresAt: now.Add(time.Minute)}
+	if err := repo.SaveSession(session); err != nil {
+		t.Fatalf("SaveSession: %v", err)
+	}
+	// the same chat has a session for every conversation
+	if err := repo.SaveSession(model.Session{Conversation: "remove", ChatID: 1, State: "chosen_manga_to_remove", Data: []byte(`{}`), ExpiresAt: now.Add(-time.Minute)}); err != nil {
+		t.Fatalf("SaveSession: %v", err)
+	}
+
+	session.State = "chose_what_to_do"
+	session.Data = []byte(`{"Chosen":{"Title":"Berserk"}}`)
+	if err := repo.SaveSession(session); err != nil {
+		t.Fatalf("SaveSession replacing: %v", err)
+	}
+	found, err := repo.FindSession("add", 1)
+	if err != nil || found == nil {
+		t.Fatalf("FindSession: %v %v", found, err)
+	}
+	if found.State != "chose_what_to_do" || string(found.Data) != string(session.Data) || !found.ExpiresAt.Equal(session.ExpiresAt.Truncate(time.Millisecond)) {
+		t.Errorf("Unexpected session %+v", found)
+	}
+
+	if n, err := repo.DeleteExpiredSessions("remove", now); err != nil || n != 1 {
+		t.Errorf("Expected 1 expired session, got %d %v", n, err)
+	}
+	if n, _ := repo.DeleteExpiredSessions("add", now); n != 0 {
+		t.Errorf("Expected the session of add to be kept, got %d deleted", n)
+	}
+
+	if err := repo.DeleteSession("add", 1); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+	if found, _ := repo.FindSession("add", 1); found != nil {
+		t.Errorf("Expected the session to be deleted, got %+v", found)
+	}
+}
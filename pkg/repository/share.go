@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/akarakai/gomanga-tbot/pkg/logger"
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+)
+
+// ShareRepo keeps the mangas shared in the chats with the inline mode.
+// The links of the shared messages are too short for the url, they refer to the manga by key
+type ShareRepo interface {
+	SaveShared(key string, manga model.Manga) error
+	FindShared(key string) (*model.Manga, error)
+}
+
+type ShareRepoSqlite3 struct {
+	db *sql.DB
+}
+
+// SaveShared saves the title, url and source of the manga, replacing the manga with the same key
+func (repo *ShareRepoSqlite3) SaveShared(key string, manga model.Manga) error {
+	_, err := repo.db.Exec(`
+		INSERT OR REPLACE INTO shared_mangas (key, url, title, source)
+		VALUES (?, ?, ?, ?)
+	`, key, manga.Url, manga.Title, manga.Source)
+	if err != nil {
+		logger.Log.Errorw("error when saving shared manga", "key", key, "url", manga.Url, "err", err)
+		return err
+	}
+	return nil
+}
+
+// FindShared returns nil if no manga was shared with the key
+func (repo *ShareRepoSqlite3) FindShared(key string) (*model.Manga, error) {
+	row := repo.db.QueryRow(`
+		SELECT url, title, source FROM shared_mangas WHERE key = ?
+	`, key)
+
+	var manga model.Manga
+	if err := row.Scan(&manga.Url, &manga.Title, &manga.Source); err != nil {
+		if err == sql.ErrNoRows {
+			logger.Log.Debugw("shared manga does not exist", "key", key)
+			return nil, nil
+		}
+		logger.Log.Errorw("error when scanning shared manga row", "key", key, "err", err)
+		return nil, err
+	}
+	return &manga, nil
+}
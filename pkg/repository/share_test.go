@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+)
+
+func TestSharedMangas(t *testing.T) {
+	db := newTestDatabase(t)
+	repo := db.ShareRepo
+
+	if manga, err := repo.FindShared("abc"); err != nil || manga != nil {
+		t.Fatalf("Expected no shared manga, got %v %v", manga, err)
+	}
+
+	berserk := model.Manga{Title: "Berserk", Url: "https://weebcentral.com/series/berserk", Source: "weebcentral"}
+	if err := repo.SaveShared("abc", berserk); err != nil {
+		t.Fatalf("SaveShared: %v", err)
+	}
+	// sharing it again does not fail
+	if err := repo.SaveShared("abc", berserk); err != nil {
+		t.Fatalf("SaveShared again: %v", err)
+	}
+
+	manga, err := repo.FindShared("abc")
+	if err != nil || manga == nil {
+		t.Fatalf("FindShared: %v %v", manga, err)
+	}
+	if manga.Title != berserk.Title || manga.Url != berserk.Url || manga.Source != berserk.Source {
+		t.Errorf("Unexpected manga %+v", manga)
+	}
+}
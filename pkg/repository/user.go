@@ -2,6 +2,7 @@ package repository
 
 import (
 	"database/sql"
+	"fmt"
 
 	"github.com/akarakai/gomanga-tbot/pkg/logger"
 	"github.com/akarakai/gomanga-tbot/pkg/model"
@@ -10,6 +11,10 @@ import (
 type UserRepo interface {
 	SaveUser(chatID model.ChatID) error
 	SaveManga(chatID model.ChatID, mangaUrl string) error
+	RemoveManga(chatID model.ChatID, mangaUrl string) error
+	SetFormat(chatID model.ChatID, format model.DownloadFormat) error
+	SetProfile(chatID model.ChatID, profile string) error
+	SetWebtoon(chatID model.ChatID, webtoon bool) error
 	FindUserByChatID(chatID model.ChatID) (*model.User, error)
 	FindAllUsers() ([]model.User, error)
 }
@@ -34,12 +39,14 @@ func (repo *UserRepoSqlite3) SaveUser(chatID model.ChatID) error {
 
 func (repo *UserRepoSqlite3) FindUserByChatID(chatID model.ChatID) (*model.User, error) {
 	row := repo.db.QueryRow(`
-        SELECT chat_id FROM users
+        SELECT chat_id, format, profile, webtoon FROM users
         WHERE chat_id = ?
     `, chatID)
 
 	var chatIDq sql.NullInt64
-	if err := row.Scan(&chatIDq); err != nil {
+	var format, profile string
+	var webtoon bool
+	if err := row.Scan(&chatIDq, &format, &profile, &webtoon); err != nil {
 		if err == sql.ErrNoRows {
 			logger.Log.Debugw("user does not exist", "chat_id", chatIDq.Int64)
 			return nil, nil
@@ -51,10 +58,29 @@ func (repo *UserRepoSqlite3) FindUserByChatID(chatID model.ChatID) (*model.User,
 	logger.Log.Debugw("user found successfully", "chat_id", chatIDq.Int64)
 
 	return &model.User{
-		ChatID: model.ChatID(chatIDq.Int64),
+		ChatID:  model.ChatID(chatIDq.Int64),
+		Format:  model.DownloadFormat(format),
+		Profile: profile,
+		Webtoon: webtoon,
 	}, nil
 }
 
+// SetFormat changes the format of the chapters downloaded by the user
+func (repo *UserRepoSqlite3) SetFormat(chatID model.ChatID, format model.DownloadFormat) error {
+	res, err := repo.db.Exec(`
+		UPDATE users SET format = ? WHERE chat_id = ?
+	`, format, chatID)
+	if err != nil {
+		logger.Log.Errorw("error when setting the format of the user", "chat_id", chatID, "format", format, "err", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("user %d not found", chatID)
+	}
+	logger.Log.Debugw("format of the user changed", "chat_id", chatID, "format", format)
+	return nil
+}
+
 func (repo *UserRepoSqlite3) SaveManga(chatID model.ChatID, mangaUrl string) error {
 	_, err := repo.db.Exec(`
 		INSERT INTO user_mangas (chat_id, manga_url)
@@ -68,13 +94,63 @@ func (repo *UserRepoSqlite3) SaveManga(chatID model.ChatID, mangaUrl string) err
 	return nil
 }
 
-// finds also the mangas of a user in order to complete the User struct and the chapter of each 
+// RemoveManga unsubscribes the user from the manga. The manga stays saved for the other users,
+// call MangaRepo.DeleteUnfollowedMangas for removing it when nobody follows it anymore
+func (repo *UserRepoSqlite3) RemoveManga(chatID model.ChatID, mangaUrl string) error {
+	res, err := repo.db.Exec(`
+		DELETE FROM user_mangas WHERE chat_id = ? AND manga_url = ?
+	`, chatID, mangaUrl)
+	if err != nil {
+		logger.Log.Errorw("error when removing manga of the user", "chat_id", chatID, "manga_url", mangaUrl, "err", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("user %d is not subscribed to %s", chatID, mangaUrl)
+	}
+	logger.Log.Debugw("manga removed from user", "chat_id", chatID, "manga_url", mangaUrl)
+	return nil
+}
+
+// SetProfile changes the image profile of the chapters downloaded by the user
+func (repo *UserRepoSqlite3) SetProfile(chatID model.ChatID, profile string) error {
+	res, err := repo.db.Exec(`
+		UPDATE users SET profile = ? WHERE chat_id = ?
+	`, profile, chatID)
+	if err != nil {
+		logger.Log.Errorw("error when setting the profile of the user", "chat_id", chatID, "profile", profile, "err", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("user %d not found", chatID)
+	}
+	logger.Log.Debugw("profile of the user changed", "chat_id", chatID, "profile", profile)
+	return nil
+}
+
+// SetWebtoon turns on or off the slicing of the webtoons downloaded by the user
+func (repo *UserRepoSqlite3) SetWebtoon(chatID model.ChatID, webtoon bool) error {
+	res, err := repo.db.Exec(`
+		UPDATE users SET webtoon = ? WHERE chat_id = ?
+	`, webtoon, chatID)
+	if err != nil {
+		logger.Log.Errorw("error when setting the webtoon mode of the user", "chat_id", chatID, "webtoon", webtoon, "err", err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("user %d not found", chatID)
+	}
+	logger.Log.Debugw("webtoon mode of the user changed", "chat_id", chatID, "webtoon", webtoon)
+	return nil
+}
+
+// finds also the mangas of a user in order to complete the User struct and the chapter of each
 func (repo *UserRepoSqlite3) FindAllUsers() ([]model.User, error) {
 	rows, err := repo.db.Query(`
 		SELECT
 			u.chat_id,
 			m.url       AS manga_url,
 			m.title     AS manga_title,
+			m.source    AS manga_source,
 			c.url       AS chapter_url,
 			c.title     AS chapter_title,
 			c.released_at
@@ -97,15 +173,16 @@ func (repo *UserRepoSqlite3) FindAllUsers() ([]model.User, error) {
 
 	for rows.Next() {
 		var (
-			chatID                 model.ChatID
-			mangaURL, mangaTitle   sql.NullString
-			chURL, chTitle         sql.NullString
-			chReleased             sql.NullTime
+			chatID               model.ChatID
+			mangaURL, mangaTitle sql.NullString
+			mangaSource          sql.NullString
+			chURL, chTitle       sql.NullString
+			chReleased           sql.NullTime
 		)
 
 		if err := rows.Scan(
 			&chatID,
-			&mangaURL, &mangaTitle,
+			&mangaURL, &mangaTitle, &mangaSource,
 			&chURL, &chTitle, &chReleased,
 		); err != nil {
 			logger.Log.Errorw("FindAllUsers: scan failed", "err", err)
@@ -125,8 +202,9 @@ func (repo *UserRepoSqlite3) FindAllUsers() ([]model.User, error) {
 		// If user has at least one manga row
 		if mangaURL.Valid {
 			m := model.Manga{
-				Url:   mangaURL.String,
-				Title: mangaTitle.String,
+				Url:    mangaURL.String,
+				Title:  mangaTitle.String,
+				Source: mangaSource.String,
 			}
 
 			if chURL.Valid {
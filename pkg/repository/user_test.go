@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+)
+
+func TestSetProfile(t *testing.T) {
+	db := newTestDatabase(t)
+	if err := db.UserRepo.SaveUser(1); err != nil {
+		t.Fatalf("SaveUser: %v", err)
+	}
+	if user, _ := db.UserRepo.FindUserByChatID(1); user.Profile != "original" {
+		t.Errorf("new users keep the original pages, got %q", user.Profile)
+	}
+	if err := db.UserRepo.SetProfile(1, "kobo-libra"); err != nil {
+		t.Fatalf("SetProfile: %v", err)
+	}
+	if user, _ := db.UserRepo.FindUserByChatID(1); user.Profile != "kobo-libra" {
+		t.Errorf("profile = %q, want kobo-libra", user.Profile)
+	}
+
+	if user, _ := db.UserRepo.FindUserByChatID(1); user.Webtoon {
+		t.Error("webtoon mode must be off for new users")
+	}
+	if err := db.UserRepo.SetWebtoon(1, true); err != nil {
+		t.Fatalf("SetWebtoon: %v", err)
+	}
+	if user, _ := db.UserRepo.FindUserByChatID(1); !user.Webtoon {
+		t.Error("webtoon mode not saved")
+	}
+}
+
+func TestSetFormat(t *testing.T) {
+	db := newTestDatabase(t)
+	if err := db.UserRepo.SaveUser(1); err != nil {
+		t.Fatalf("SaveUser: %v", err)
+	}
+
+	user, err := db.UserRepo.FindUserByChatID(1)
+	if err != nil {
+		t.Fatalf("FindUserByChatID: %v", err)
+	}
+	if user.Format != model.FormatPDF {
+		t.Errorf("new users download pdf, got %q", user.Format)
+	}
+
+	if err := db.UserRepo.SetFormat(1, model.FormatCBZ); err != nil {
+		t.Fatalf("SetFormat: %v", err)
+	}
+	user, err = db.UserRepo.FindUserByChatID(1)
+	if err != nil {
+		t.Fatalf("FindUserByChatID: %v", err)
+	}
+	if user.Format != model.FormatCBZ {
+		t.Errorf("format = %q, want cbz", user.Format)
+	}
+
+	if err := db.UserRepo.SetFormat(2, model.FormatCBZ); err == nil {
+		t.Error("setting the format of an unknown user should fail")
+	}
+}
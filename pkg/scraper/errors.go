@@ -0,0 +1,117 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// The errors returned by every Scraper wrap one of these, so that the callers can check them
+// with errors.Is and decide what to do. A timeout is a *TimeoutError, which is also ErrTimeout
+var (
+	// the manga or the chapter does not exist on the site
+	ErrNotFound = errors.New("not found")
+	// the site answered with a captcha or refused the request
+	ErrBlocked = errors.New("blocked by the site")
+	// the page does not contain what the scraper looks for, the scraper must be updated
+	ErrLayoutChanged = errors.New("layout of the site changed")
+	// the site cannot be reached or answered with a server error
+	ErrNetwork = errors.New("network error")
+	// the site received too many requests
+	ErrRateLimited = errors.New("rate limited")
+	// the site did not answer in time
+	ErrTimeout = errors.New("timeout")
+)
+
+// IsRetryable reports whether the call may work if made again later.
+// Not found and layout changes will not fix themselves
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrTimeout) ||
+		errors.Is(err, ErrNetwork) ||
+		errors.Is(err, ErrRateLimited) ||
+		errors.Is(err, ErrBlocked)
+}
+
+// TimeoutError is returned when the site does not answer before the deadline of the context,
+// or before the timeout of the browser or of the http client
+type TimeoutError struct {
+	URL string
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timeout loading %s: %v", e.URL, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// IsTimeout reports whether the error returned by a Scraper is a TimeoutError
+func IsTimeout(err error) bool {
+	var te *TimeoutError
+	return errors.As(err, &te)
+}
+
+// wrapContextError converts the errors caused by timeouts in TimeoutError.
+// If the context was canceled, the error wraps context.Canceled
+func wrapContextError(ctx context.Context, url string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if IsTimeout(err) {
+		return err
+	}
+
+	var netErr net.Error
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, playwright.ErrTimeout) ||
+		(errors.As(err, &netErr) && netErr.Timeout()) {
+		return &TimeoutError{URL: url, Err: err}
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+		return fmt.Errorf("%w: %w", ctxErr, err)
+	}
+	return err
+}
+
+// requestError classifies the error of a request which did not get an answer
+func requestError(ctx context.Context, url string, err error) error {
+	wrapped := wrapContextError(ctx, url, err)
+	if IsTimeout(wrapped) || ctx.Err() != nil {
+		return wrapped
+	}
+	return fmt.Errorf("%w: error navigating to %s: %w", ErrNetwork, url, err)
+}
+
+// statusError classifies an answer of the site with a status which is not 200.
+// challenge tells if the answer is a captcha page
+func statusError(status int, url string, challenge bool) error {
+	switch {
+	case challenge || status == http.StatusForbidden:
+		return fmt.Errorf("%w: status %d for URL %s", ErrBlocked, status, url)
+	case status == http.StatusNotFound || status == http.StatusGone:
+		return fmt.Errorf("%w: status %d for URL %s", ErrNotFound, status, url)
+	case status == http.StatusTooManyRequests:
+		return fmt.Errorf("%w: status %d for URL %s", ErrRateLimited, status, url)
+	case status >= 500:
+		return fmt.Errorf("%w: status %d for URL %s", ErrNetwork, status, url)
+	default:
+		return fmt.Errorf("received non-OK status %d for URL %s", status, url)
+	}
+}
+
+// isChallenge detects the captcha page of cloudflare, which protects most of the sites
+func isChallenge(header http.Header, title string) bool {
+	return header.Get("cf-mitigated") == "challenge" || strings.HasPrefix(title, "Just a moment")
+}
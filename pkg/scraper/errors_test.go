@@ -0,0 +1,65 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestStatusError(t *testing.T) {
+	cases := []struct {
+		status    int
+		challenge bool
+		expected  error
+	}{
+		{http.StatusNotFound, false, ErrNotFound},
+		{http.StatusGone, false, ErrNotFound},
+		{http.StatusForbidden, false, ErrBlocked},
+		{http.StatusOK, true, ErrBlocked},
+		{http.StatusServiceUnavailable, true, ErrBlocked},
+		{http.StatusTooManyRequests, false, ErrRateLimited},
+		{http.StatusBadGateway, false, ErrNetwork},
+	}
+	for _, c := range cases {
+		err := statusError(c.status, "https://example.com", c.challenge)
+		if !errors.Is(err, c.expected) {
+			t.Errorf("statusError(%d, %v) = %v, want %v", c.status, c.challenge, err, c.expected)
+		}
+	}
+
+	err := statusError(http.StatusBadRequest, "https://example.com", false)
+	for _, sentinel := range []error{ErrNotFound, ErrBlocked, ErrRateLimited, ErrNetwork, ErrLayoutChanged} {
+		if errors.Is(err, sentinel) {
+			t.Errorf("status 400 should not be %v", sentinel)
+		}
+	}
+}
+
+func TestIsChallenge(t *testing.T) {
+	header := http.Header{}
+	if isChallenge(header, "Hikaru ga Shinda Natsu | Weeb Central") {
+		t.Error("Normal page detected as challenge")
+	}
+	if !isChallenge(header, "Just a moment...") {
+		t.Error("Challenge title not detected")
+	}
+	header.Set("cf-mitigated", "challenge")
+	if !isChallenge(header, "") {
+		t.Error("Challenge header not detected")
+	}
+}
+
+func TestRequestError(t *testing.T) {
+	err := requestError(context.Background(), "https://example.com", errors.New("connection refused"))
+	if !errors.Is(err, ErrNetwork) || !IsRetryable(err) {
+		t.Errorf("Expected retryable network error, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = requestError(ctx, "https://example.com", errors.New("connection closed"))
+	if errors.Is(err, ErrNetwork) || !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected canceled error, got %v", err)
+	}
+}
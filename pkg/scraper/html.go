@@ -0,0 +1,79 @@
+package scraper
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// sites without an api refuse requests which do not look like coming from a browser
+const userAgent = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36"
+
+// small helpers for reading parsed html, just what the scrapers need
+
+func isElement(tag string) func(*html.Node) bool {
+	return func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == tag
+	}
+}
+
+func hasID(id string) func(*html.Node) bool {
+	return func(n *html.Node) bool {
+		return n.Type == html.ElementNode && attr(n, "id") == id
+	}
+}
+
+// findAll returns the descendants of root matching the predicate, in document order
+func findAll(root *html.Node, match func(*html.Node) bool) []*html.Node {
+	var found []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if match(c) {
+				found = append(found, c)
+			}
+			walk(c)
+		}
+	}
+	walk(root)
+	return found
+}
+
+// findFirst returns the first descendant of root matching the predicate, or nil
+func findFirst(root *html.Node, match func(*html.Node) bool) *html.Node {
+	for c := root.FirstChild; c != nil; c = c.NextSibling {
+		if match(c) {
+			return c
+		}
+		if found := findFirst(c, match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// textContent returns the text inside the node with the whitespaces collapsed
+func textContent(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+			sb.WriteString(" ")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
@@ -0,0 +1,402 @@
+package scraper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+)
+
+const MangaDexBaseURL = "https://mangadex.org"
+const MangaDexApiURL = "https://api.mangadex.org"
+const MangaDexCoversURL = "https://uploads.mangadex.org/covers"
+
+// max number of entries the api returns in a single page
+const mangaDexMaxLimit = 100
+
+// MangaDexScraper implements Scraper using the public MangaDex JSON api.
+// No browser is needed, every call is a plain http request
+type MangaDexScraper struct {
+	client  *http.Client
+	apiURL  string
+	lang    string
+	mu      sync.Mutex
+	lastURL string
+}
+
+// NewMangaDexScraper creates a scraper that talks to the api at apiURL.
+// apiURL can be changed in order to use a local server in the tests
+func NewMangaDexScraper(client *http.Client, apiURL string, lang string) *MangaDexScraper {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &MangaDexScraper{
+		client: client,
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+		lang:   lang,
+	}
+}
+
+// No need to pass configuration. Chapters are searched in english
+func NewMangaDexScraperDefault() *MangaDexScraper {
+	return NewMangaDexScraper(nil, MangaDexApiURL, "en")
+}
+
+type mangaDexMangaList struct {
+	Data []struct {
+		ID         string `json:"id"`
+		Attributes struct {
+			Title     map[string]string   `json:"title"`
+			AltTitles []map[string]string `json:"altTitles"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+type mangaDexMangaDetails struct {
+	Data struct {
+		ID         string `json:"id"`
+		Attributes struct {
+			Title       map[string]string   `json:"title"`
+			AltTitles   []map[string]string `json:"altTitles"`
+			Description map[string]string   `json:"description"`
+			Status      string              `json:"status"`
+			Tags        []struct {
+				Attributes struct {
+					Name  map[string]string `json:"name"`
+					Group string            `json:"group"`
+				} `json:"attributes"`
+			} `json:"tags"`
+		} `json:"attributes"`
+		// included with the includes[] parameter
+		Relationships []struct {
+			Type       string `json:"type"`
+			Attributes struct {
+				Name     string `json:"name"`
+				FileName string `json:"fileName"`
+			} `json:"attributes"`
+		} `json:"relationships"`
+	} `json:"data"`
+}
+
+type mangaDexChapterList struct {
+	Data []struct {
+		ID         string `json:"id"`
+		Attributes struct {
+			Volume      *string   `json:"volume"`
+			Chapter     *string   `json:"chapter"`
+			Title       *string   `json:"title"`
+			Language    string    `json:"translatedLanguage"`
+			ExternalURL *string   `json:"externalUrl"`
+			PublishAt   time.Time `json:"publishAt"`
+			Pages       int       `json:"pages"`
+		} `json:"attributes"`
+	} `json:"data"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+	Total  int `json:"total"`
+}
+
+type mangaDexAtHome struct {
+	BaseURL string `json:"baseUrl"`
+	Chapter struct {
+		Hash string   `json:"hash"`
+		Data []string `json:"data"`
+	} `json:"chapter"`
+}
+
+// FindListOfMangas searches the mangas by title.
+//
+// As for the PlaywrightScraper, the returned mangas do not contain the last chapter
+func (s *MangaDexScraper) FindListOfMangas(ctx context.Context, query string) ([]model.Manga, error) {
+	if query == "" {
+		return nil, errors.New("query is empty")
+	}
+
+	params := url.Values{}
+	params.Set("title", query)
+	params.Set("limit", "10")
+	params.Add("order[relevance]", "desc")
+
+	var list mangaDexMangaList
+	if err := s.getJSON(ctx, "/manga", params, &list); err != nil {
+		return nil, err
+	}
+
+	mangas := make([]model.Manga, 0, len(list.Data))
+	for _, m := range list.Data {
+		mangas = append(mangas, model.Manga{
+			Title:       pickMangaDexTitle(m.Attributes.Title, m.Attributes.AltTitles, s.lang),
+			Url:         fmt.Sprintf("%s/title/%s", MangaDexBaseURL, m.ID),
+			LastChapter: nil,
+		})
+	}
+
+	log.Printf("Query \"%s\" gave %d results", query, len(mangas))
+	return mangas, nil
+}
+
+// FindMangaDetails reads the manga with its authors, artists and cover
+func (s *MangaDexScraper) FindMangaDetails(ctx context.Context, mangaURL string) (model.Manga, error) {
+	mangaID, err := mangaDexIDFromURL(mangaURL, "title")
+	if err != nil {
+		return model.Manga{}, err
+	}
+
+	params := url.Values{}
+	params.Add("includes[]", "author")
+	params.Add("includes[]", "artist")
+	params.Add("includes[]", "cover_art")
+
+	var details mangaDexMangaDetails
+	if err := s.getJSON(ctx, fmt.Sprintf("/manga/%s", mangaID), params, &details); err != nil {
+		return model.Manga{}, err
+	}
+	attrs := details.Data.Attributes
+	if details.Data.ID == "" {
+		return model.Manga{}, fmt.Errorf("%w: manga %s not in the response", ErrLayoutChanged, mangaID)
+	}
+
+	manga := model.Manga{
+		Title:       pickMangaDexTitle(attrs.Title, attrs.AltTitles, s.lang),
+		Url:         fmt.Sprintf("%s/title/%s", MangaDexBaseURL, details.Data.ID),
+		Status:      model.ParseMangaStatus(attrs.Status),
+		Description: pickMangaDexText(attrs.Description, s.lang),
+	}
+	for _, tag := range attrs.Tags {
+		// the other groups are format and content warnings
+		if tag.Attributes.Group == "genre" || tag.Attributes.Group == "theme" {
+			manga.Genres = append(manga.Genres, pickMangaDexText(tag.Attributes.Name, s.lang))
+		}
+	}
+	for _, rel := range details.Data.Relationships {
+		switch rel.Type {
+		case "author", "artist":
+			if rel.Attributes.Name != "" && !slices.Contains(manga.Authors, rel.Attributes.Name) {
+				manga.Authors = append(manga.Authors, rel.Attributes.Name)
+			}
+		case "cover_art":
+			if rel.Attributes.FileName != "" {
+				// .512.jpg is the thumbnail, the original can be very big
+				manga.CoverUrl = fmt.Sprintf("%s/%s/%s.512.jpg", MangaDexCoversURL, details.Data.ID, rel.Attributes.FileName)
+			}
+		}
+	}
+	// the alt titles are in every language, only the readable ones are kept
+	for _, alt := range attrs.AltTitles {
+		for _, l := range []string{s.lang, "en", "ja-ro"} {
+			if t, ok := alt[l]; ok && t != "" && t != manga.Title && !slices.Contains(manga.AltTitles, t) {
+				manga.AltTitles = append(manga.AltTitles, t)
+			}
+		}
+	}
+
+	return manga, nil
+}
+
+// FindListOfChapters finds the required number of most recent chapters from a manga.
+// Chapters uploaded by different groups with the same number are returned only once
+func (s *MangaDexScraper) FindListOfChapters(ctx context.Context, mangaURL string, nChaps int) ([]model.Chapter, error) {
+	mangaID, err := mangaDexIDFromURL(mangaURL, "title")
+	if err != nil {
+		return nil, err
+	}
+	if nChaps != AllChapters && nChaps <= 0 {
+		return []model.Chapter{}, nil
+	}
+
+	chapters := make([]model.Chapter, 0, max(nChaps, 0))
+	wanted := func() bool { return nChaps == AllChapters || len(chapters) < nChaps }
+	seen := make(map[string]bool)
+	for offset := 0; wanted(); offset += mangaDexMaxLimit {
+		params := url.Values{}
+		params.Set("limit", strconv.Itoa(mangaDexMaxLimit))
+		params.Set("offset", strconv.Itoa(offset))
+		params.Add("order[chapter]", "desc")
+		params.Add("order[publishAt]", "desc")
+		params.Set("includeExternalUrl", "0")
+		if s.lang != "" {
+			params.Add("translatedLanguage[]", s.lang)
+		}
+
+		var list mangaDexChapterList
+		if err := s.getJSON(ctx, fmt.Sprintf("/manga/%s/feed", mangaID), params, &list); err != nil {
+			return nil, err
+		}
+
+		for _, ch := range list.Data {
+			if ch.Attributes.ExternalURL != nil || ch.Attributes.Pages == 0 {
+				continue
+			}
+			number := ""
+			if ch.Attributes.Chapter != nil {
+				number = *ch.Attributes.Chapter
+			}
+			if number != "" && seen[number] {
+				continue
+			}
+			seen[number] = true
+
+			chapterNumber := float64(model.NoChapterNumber)
+			if n, err := strconv.ParseFloat(number, 64); err == nil {
+				chapterNumber = n
+			}
+			chapters = append(chapters, model.Chapter{
+				Title:      mangaDexChapterTitle(number, ch.Attributes.Title),
+				Url:        fmt.Sprintf("%s/chapter/%s", MangaDexBaseURL, ch.ID),
+				Number:     chapterNumber,
+				ReleasedAt: ch.Attributes.PublishAt,
+				Language:   ch.Attributes.Language,
+			})
+			if !wanted() {
+				break
+			}
+		}
+
+		if len(list.Data) == 0 || list.Offset+len(list.Data) >= list.Total {
+			break
+		}
+	}
+
+	return chapters, nil
+}
+
+// FindImgUrlsOfChapter asks the at-home server where the pages of the chapter are stored
+func (s *MangaDexScraper) FindImgUrlsOfChapter(ctx context.Context, chapterURL string) ([]string, error) {
+	if chapterURL == "" {
+		return nil, fmt.Errorf("chapterURL is empty")
+	}
+	chapterID, err := mangaDexIDFromURL(chapterURL, "chapter")
+	if err != nil {
+		return nil, err
+	}
+
+	var atHome mangaDexAtHome
+	if err := s.getJSON(ctx, fmt.Sprintf("/at-home/server/%s", chapterID), nil, &atHome); err != nil {
+		return nil, err
+	}
+	if atHome.BaseURL == "" || atHome.Chapter.Hash == "" {
+		return nil, fmt.Errorf("%w: at-home server did not return the chapter %s", ErrLayoutChanged, chapterID)
+	}
+
+	imgs := make([]string, 0, len(atHome.Chapter.Data))
+	for _, file := range atHome.Chapter.Data {
+		imgs = append(imgs, fmt.Sprintf("%s/data/%s/%s", atHome.BaseURL, atHome.Chapter.Hash, file))
+	}
+
+	log.Printf("Found %d images", len(imgs))
+	return imgs, nil
+}
+
+// CurrentUrl returns the last api url requested
+func (s *MangaDexScraper) CurrentUrl() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastURL
+}
+
+// CurrentPageTitle has no meaning for a json api
+func (s *MangaDexScraper) CurrentPageTitle() (string, error) {
+	return "", errors.New("title of the page not found")
+}
+
+func (s *MangaDexScraper) Close() {
+	s.client.CloseIdleConnections()
+}
+
+func (s *MangaDexScraper) getJSON(ctx context.Context, path string, params url.Values, out any) error {
+	reqURL := s.apiURL + path
+	if len(params) > 0 {
+		reqURL += "?" + params.Encode()
+	}
+
+	s.mu.Lock()
+	s.lastURL = reqURL
+	s.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return err
+	}
+	// the api rejects requests without a user agent
+	req.Header.Set("User-Agent", "gomanga-tbot")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return requestError(ctx, reqURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp.StatusCode, reqURL, isChallenge(resp.Header, ""))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		if ctx.Err() != nil {
+			return wrapContextError(ctx, reqURL, err)
+		}
+		return fmt.Errorf("%w: could not decode response of %s: %w", ErrLayoutChanged, reqURL, err)
+	}
+	return nil
+}
+
+// https://mangadex.org/title/<id>/<optional-slug> => <id>
+func mangaDexIDFromURL(rawURL string, kind string) (string, error) {
+	prefix := fmt.Sprintf("%s/%s/", MangaDexBaseURL, kind)
+	if !strings.HasPrefix(rawURL, prefix) {
+		return "", fmt.Errorf("url %q does not have prefix %q", rawURL, prefix)
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(rawURL, prefix), "/")
+	if id == "" {
+		return "", fmt.Errorf("url %q does not contain an id", rawURL)
+	}
+	return id, nil
+}
+
+// titles are localized. Prefer the requested language, then english, then whatever is there
+func pickMangaDexTitle(titles map[string]string, altTitles []map[string]string, lang string) string {
+	for _, l := range []string{lang, "en"} {
+		if t, ok := titles[l]; ok && t != "" {
+			return t
+		}
+	}
+	for _, alt := range altTitles {
+		if t, ok := alt[lang]; ok && t != "" {
+			return t
+		}
+	}
+	for _, t := range titles {
+		return t
+	}
+	return ""
+}
+
+// pickMangaDexText picks the localized text in the requested language, or in english
+func pickMangaDexText(texts map[string]string, lang string) string {
+	for _, l := range []string{lang, "en"} {
+		if t, ok := texts[l]; ok && t != "" {
+			return t
+		}
+	}
+	return ""
+}
+
+func mangaDexChapterTitle(number string, title *string) string {
+	name := "Oneshot"
+	if number != "" {
+		name = "Chapter " + number
+	}
+	if title != nil && *title != "" {
+		name = fmt.Sprintf("%s - %s", name, *title)
+	}
+	return name
+}
@@ -0,0 +1,276 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+)
+
+// newMangaDexTestServer serves the recorded api responses saved in testdata/mangadex
+func newMangaDexTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	fixture := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("User-Agent") == "" {
+				http.Error(w, "missing user agent", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			http.ServeFile(w, r, filepath.Join("testdata", "mangadex", name))
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/manga", fixture("search.json"))
+	mux.HandleFunc("/manga/801513ba-a712-498c-8f57-cae55b38cc92", func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.Query()["includes[]"]) != 3 {
+			http.Error(w, "missing includes", http.StatusBadRequest)
+			return
+		}
+		fixture("manga.json")(w, r)
+	})
+	mux.HandleFunc("/manga/801513ba-a712-498c-8f57-cae55b38cc92/feed", fixture("feed.json"))
+	mux.HandleFunc("/at-home/server/c4d2a0b5-0e1f-4a57-9d63-2f0f5b6a7c01", fixture("at_home.json"))
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestMangaDexFindListOfMangas(t *testing.T) {
+	srv := newMangaDexTestServer(t)
+	s := NewMangaDexScraper(srv.Client(), srv.URL, "en")
+
+	t.Run("GoodQuery", func(t *testing.T) {
+		mangas, err := s.FindListOfMangas(context.Background(), "Berserk")
+		if err != nil {
+			t.Fatalf("Failed to find list of mangas: %v", err)
+		}
+		if len(mangas) != 2 {
+			t.Fatalf("Expected 2 mangas, got %d", len(mangas))
+		}
+		if mangas[0].Title != "Berserk" {
+			t.Errorf("Expected title Berserk, got %q", mangas[0].Title)
+		}
+		if mangas[0].Url != "https://mangadex.org/title/801513ba-a712-498c-8f57-cae55b38cc92" {
+			t.Errorf("Unexpected url %q", mangas[0].Url)
+		}
+		// no english title, the english alt title is used
+		if mangas[1].Title != "Berserk: God of the Abyss" {
+			t.Errorf("Expected alt title, got %q", mangas[1].Title)
+		}
+		if !strings.Contains(s.CurrentUrl(), "title=Berserk") {
+			t.Errorf("Query not sent to the api: %s", s.CurrentUrl())
+		}
+	})
+
+	t.Run("EmptyQuery", func(t *testing.T) {
+		mangas, err := s.FindListOfMangas(context.Background(), "")
+		if err == nil {
+			t.Error("Expected error, got nil")
+		}
+		if mangas != nil {
+			t.Error("Expected nil mangas")
+		}
+	})
+}
+
+func TestMangaDexFindMangaDetails(t *testing.T) {
+	srv := newMangaDexTestServer(t)
+	s := NewMangaDexScraper(srv.Client(), srv.URL, "en")
+
+	t.Run("GoodQuery", func(t *testing.T) {
+		manga, err := s.FindMangaDetails(context.Background(), "https://mangadex.org/title/801513ba-a712-498c-8f57-cae55b38cc92/berserk")
+		if err != nil {
+			t.Fatalf("Failed to find details: %v", err)
+		}
+		if manga.Title != "Berserk" || manga.Url != "https://mangadex.org/title/801513ba-a712-498c-8f57-cae55b38cc92" {
+			t.Errorf("Unexpected manga %q %q", manga.Title, manga.Url)
+		}
+		// the author is also the artist
+		if !slices.Equal(manga.Authors, []string{"Miura Kentarou", "Studio Gaga"}) {
+			t.Errorf("Unexpected authors %v", manga.Authors)
+		}
+		if !slices.Equal(manga.Genres, []string{"Action", "Horror", "Supernatural"}) {
+			t.Errorf("Unexpected genres %v", manga.Genres)
+		}
+		if manga.Status != model.StatusHiatus {
+			t.Errorf("Expected hiatus, got %q", manga.Status)
+		}
+		if !slices.Equal(manga.AltTitles, []string{"Beruseruku"}) {
+			t.Errorf("Unexpected alt titles %v", manga.AltTitles)
+		}
+		if !strings.HasPrefix(manga.Description, "Guts, a former mercenary") {
+			t.Errorf("Unexpected description %q", manga.Description)
+		}
+		expected := "https://uploads.mangadex.org/covers/801513ba-a712-498c-8f57-cae55b38cc92/d5d2a5e8-8f1e-4b7a-9f3c-6b3e2f1a0c9d.jpg.512.jpg"
+		if manga.CoverUrl != expected {
+			t.Errorf("Expected cover %q, got %q", expected, manga.CoverUrl)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		_, err := s.FindMangaDetails(context.Background(), "https://mangadex.org/title/00000000-0000-0000-0000-000000000000")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected not found error, got %v", err)
+		}
+	})
+}
+
+func TestMangaDexFindListOfChapters(t *testing.T) {
+	srv := newMangaDexTestServer(t)
+	s := NewMangaDexScraper(srv.Client(), srv.URL, "en")
+	mangaURL := "https://mangadex.org/title/801513ba-a712-498c-8f57-cae55b38cc92/berserk"
+
+	t.Run("SkipsDuplicatesAndExternal", func(t *testing.T) {
+		chapters, err := s.FindListOfChapters(context.Background(), mangaURL, 10)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		expected := []string{
+			"Chapter 376 - Sunset of the Nostalgic Days",
+			"Chapter 374",
+			"Chapter 373.5 - Extra",
+		}
+		if len(chapters) != len(expected) {
+			t.Fatalf("Expected %d chapters, got %d", len(expected), len(chapters))
+		}
+		for i, ch := range chapters {
+			if ch.Title != expected[i] {
+				t.Errorf("Expected title %q, got %q", expected[i], ch.Title)
+			}
+		}
+		if chapters[0].Url != "https://mangadex.org/chapter/c4d2a0b5-0e1f-4a57-9d63-2f0f5b6a7c01" {
+			t.Errorf("Unexpected chapter url %q", chapters[0].Url)
+		}
+		if chapters[0].ReleasedAt.IsZero() {
+			t.Error("Expected release date")
+		}
+		if chapters[0].Language != "en" {
+			t.Errorf("Expected the chapter in english, got %q", chapters[0].Language)
+		}
+	})
+
+	t.Run("AllChapters", func(t *testing.T) {
+		chapters, err := s.FindListOfChapters(context.Background(), mangaURL, AllChapters)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		expected := []float64{376, 374, 373.5}
+		if len(chapters) != len(expected) {
+			t.Fatalf("Expected %d chapters, got %d", len(expected), len(chapters))
+		}
+		for i, ch := range chapters {
+			if ch.Number != expected[i] {
+				t.Errorf("Expected chapter number %v, got %v", expected[i], ch.Number)
+			}
+		}
+	})
+
+	t.Run("LimitChapters", func(t *testing.T) {
+		chapters, err := s.FindListOfChapters(context.Background(), mangaURL, 1)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(chapters) != 1 {
+			t.Errorf("Expected 1 chapter, got %d", len(chapters))
+		}
+	})
+
+	t.Run("ZeroChaptersRequested", func(t *testing.T) {
+		chapters, err := s.FindListOfChapters(context.Background(), mangaURL, 0)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(chapters) != 0 {
+			t.Errorf("Expected 0 chapters, got %d", len(chapters))
+		}
+	})
+
+	t.Run("WrongBaseUrl", func(t *testing.T) {
+		_, err := s.FindListOfChapters(context.Background(), "https://weebcentral.com/series/01J76XYFXM8RHFVVCN0PJBPAT8", 1)
+		if err == nil {
+			t.Error("Expected error, got nil")
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		_, err := s.FindListOfChapters(context.Background(), "https://mangadex.org/title/00000000-0000-0000-0000-000000000000", 1)
+		if err == nil {
+			t.Error("Expected error, got nil")
+		}
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected not found error, got %v", err)
+		}
+	})
+}
+
+func TestMangaDexFindImgUrlsOfChapter(t *testing.T) {
+	srv := newMangaDexTestServer(t)
+	s := NewMangaDexScraper(srv.Client(), srv.URL, "en")
+
+	t.Run("GoodQuery", func(t *testing.T) {
+		imgs, err := s.FindImgUrlsOfChapter(context.Background(), "https://mangadex.org/chapter/c4d2a0b5-0e1f-4a57-9d63-2f0f5b6a7c01")
+		if err != nil {
+			t.Fatalf("Failed to find urls of the chapter: %v", err)
+		}
+		if len(imgs) != 3 {
+			t.Fatalf("Expected 3 urls, got %d", len(imgs))
+		}
+		expected := "https://uploads.mangadex.org/data/3303dd03ac8d27452cce3f2a882e94b2/" +
+			"1-f7a76de10d346de7ba01786762ebbedc666b412ad0d4b73baa330a2a392dbcdd.png"
+		if imgs[0] != expected {
+			t.Errorf("Expected %q, got %q", expected, imgs[0])
+		}
+	})
+
+	t.Run("EmptyChapterURL", func(t *testing.T) {
+		_, err := s.FindImgUrlsOfChapter(context.Background(), "")
+		if err == nil {
+			t.Error("Expected error, got nil")
+		}
+	})
+}
+
+func TestMangaDexHonorsContext(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a hung api
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+	s := NewMangaDexScraper(srv.Client(), srv.URL, "en")
+
+	t.Run("Deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := s.FindListOfMangas(ctx, "Berserk")
+		if !IsTimeout(err) {
+			t.Errorf("Expected timeout error, got %v", err)
+		}
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		_, err := s.FindListOfMangas(ctx, "Berserk")
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected canceled error, got %v", err)
+		}
+		if IsTimeout(err) {
+			t.Error("Cancellation is not a timeout")
+		}
+	})
+}
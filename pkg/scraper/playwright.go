@@ -1,16 +1,19 @@
 package scraper
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"log/slog"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/akarakai/gomanga-tbot/pkg/logger"
 	"github.com/akarakai/gomanga-tbot/pkg/model"
 	"github.com/playwright-community/playwright-go"
+	"golang.org/x/net/html"
 )
 
 type PlaywrightScraper struct {
@@ -24,23 +27,7 @@ type PlaywrightScraper struct {
 // NewWeebCentralScraper creates a new instance of WeebCentralScraper and returns its pointer.
 // this function will also open a new page from a context
 func NewWeebCentralScraper(cfg Configuration) (*PlaywrightScraper, error) {
-	pw, err := playwright.Run()
-	if err != nil {
-		return nil, err
-	}
-
-	browserOpt := getBrowserOptions(cfg)
-	var browser playwright.Browser
-	switch cfg.browserType {
-	case Chromium:
-		browser, err = pw.Chromium.Launch(*browserOpt)
-	case Firefox:
-		browser, err = pw.Firefox.Launch(*browserOpt)
-	case Webkit:
-		browser, err = pw.WebKit.Launch(*browserOpt)
-	default:
-		return nil, errors.New("browser is not supported")
-	}
+	pw, browser, err := launchBrowser(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -49,6 +36,11 @@ func NewWeebCentralScraper(cfg Configuration) (*PlaywrightScraper, error) {
 	if err != nil {
 		return nil, err
 	}
+	if cfg.route != nil {
+		if err := cfg.route(context); err != nil {
+			return nil, fmt.Errorf("could not route the context: %w", err)
+		}
+	}
 	log.Println("scraper created successfully")
 	return &PlaywrightScraper{
 		pw:      pw,
@@ -75,13 +67,11 @@ func NewWeebCentralScraperDefault() (*PlaywrightScraper, error) {
 // Returns an empty slice if no manga is found or if it encounters an error
 // the retourned mangas do not contain the last chapter, for that you must use the FindListOfChapters
 // with nChaps = 1
-func (s *PlaywrightScraper) FindListOfMangas(query string) ([]model.Manga, error) {
+func (s *PlaywrightScraper) FindListOfMangas(ctx context.Context, query string) ([]model.Manga, error) {
 	if query == "" {
 		log.Println("query is empty")
 		return nil, errors.New("query is empty")
 	}
-	const XPATH_MANGAS_CONTAINER = "/html/body/header/section[1]/div[2]/section/div[2]"
-	const ID_SEARCH_BOX = "#quick-search-input"
 
 	if s.page == nil {
 		log.Println("Page is empty. Creating a new one.")
@@ -90,37 +80,52 @@ func (s *PlaywrightScraper) FindListOfMangas(query string) ([]model.Manga, error
 		}
 	}
 
+	mangas, err := findListOfMangas(ctx, s.page, query)
+	s.dropPageIfDone(ctx)
+	return mangas, err
+}
+
+// findListOfMangas does the work of FindListOfMangas in the given page
+func findListOfMangas(ctx context.Context, page playwright.Page, query string) (mangas []model.Manga, err error) {
+	const XPATH_MANGAS_CONTAINER = "/html/body/header/section[1]/div[2]/section/div[2]"
+	const ID_SEARCH_BOX = "#quick-search-input"
+
+	if err = ctx.Err(); err != nil {
+		return nil, wrapContextError(ctx, WeebCentralBaseURL, err)
+	}
+	defer bindContext(ctx, page)()
+	defer func() { err = wrapContextError(ctx, WeebCentralBaseURL, err) }()
+
 	log.Printf("Going to %s\n", WeebCentralBaseURL)
-	r, err := s.page.Goto(WeebCentralBaseURL, playwright.PageGotoOptions{
+	r, err := page.Goto(WeebCentralBaseURL, playwright.PageGotoOptions{
 		WaitUntil: playwright.WaitUntilStateDomcontentloaded,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("problem navigating to %s", WeebCentralBaseURL)
+		return nil, requestError(ctx, WeebCentralBaseURL, err)
 	}
-	if !r.Ok() {
-		return nil, fmt.Errorf("problem navigating to %s. Status: %s", WeebCentralBaseURL, r.StatusText())
+	if err := responseError(page, r, WeebCentralBaseURL); err != nil {
+		return nil, err
 	}
 
 	log.Println("Looking for the search bar...")
 	// insert the text in the searchbar
-	searchBar := s.page.Locator(ID_SEARCH_BOX)
+	searchBar := page.Locator(ID_SEARCH_BOX)
 	if err := searchBar.Fill(query); err != nil {
 		return nil, err
 	}
-	container := s.page.Locator(fmt.Sprintf("xpath=%s", XPATH_MANGAS_CONTAINER))
+	container := page.Locator(fmt.Sprintf("xpath=%s", XPATH_MANGAS_CONTAINER))
 	err = container.WaitFor()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: search results not found: %w", ErrLayoutChanged, err)
 	}
 	links := container.Locator("a")
 
 	locators, err := links.All()
 	if err != nil {
-		log.Fatalf("Failed to get locators: %v", err)
-		return nil, err
+		return nil, fmt.Errorf("%w: failed to get locators: %w", ErrLayoutChanged, err)
 	}
 
-	mangas := make([]model.Manga, 0, 10)
+	mangas = make([]model.Manga, 0, 10)
 	for _, aLoc := range locators {
 		// for each a tag, find the url and the name
 		title, _ := aLoc.InnerText()
@@ -136,13 +141,58 @@ func (s *PlaywrightScraper) FindListOfMangas(query string) ([]model.Manga, error
 	return mangas, nil
 }
 
-// FindListOfChapters finds the required number of most recent chapters from a manga.
-func (s *PlaywrightScraper) FindListOfChapters(mangaURL string, nChaps int) ([]model.Chapter, error) {
-	if !strings.HasPrefix(mangaURL, WeebCentralBaseURL) {
-		return nil, fmt.Errorf("url %q does not have prefix %q", mangaURL, WeebCentralBaseURL)
+// FindMangaDetails reads the metadata and the cover of the manga from its page.
+// The returned manga does not contain the last chapter, for that use FindListOfChapters
+func (s *PlaywrightScraper) FindMangaDetails(ctx context.Context, mangaURL string) (model.Manga, error) {
+	if err := checkWeebCentralURL(mangaURL); err != nil {
+		return model.Manga{}, err
+	}
+	if s.page == nil {
+		log.Println("Page is empty. Creating a new one.")
+		if err := makeNewPage(s); err != nil {
+			return model.Manga{}, err
+		}
 	}
 
-	const chapterListSelector = "#chapter-list"
+	manga, err := findMangaDetails(ctx, s.page, mangaURL)
+	s.dropPageIfDone(ctx)
+	return manga, err
+}
+
+// findMangaDetails parses the content of the page of the series, in the same way of the http scraper
+func findMangaDetails(ctx context.Context, page playwright.Page, mangaURL string) (manga model.Manga, err error) {
+	if err = ctx.Err(); err != nil {
+		return model.Manga{}, wrapContextError(ctx, mangaURL, err)
+	}
+	defer bindContext(ctx, page)()
+	defer func() { err = wrapContextError(ctx, mangaURL, err) }()
+
+	resp, err := page.Goto(mangaURL)
+	if err != nil {
+		return model.Manga{}, requestError(ctx, mangaURL, err)
+	}
+	if err := responseError(page, resp, mangaURL); err != nil {
+		return model.Manga{}, err
+	}
+	if resp.URL() == "https://weebcentral.com/404" {
+		return model.Manga{}, fmt.Errorf("%w: manga with url %s was not found", ErrNotFound, mangaURL)
+	}
+
+	content, err := page.Content()
+	if err != nil {
+		return model.Manga{}, err
+	}
+	doc, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return model.Manga{}, fmt.Errorf("%w: could not parse %s: %w", ErrLayoutChanged, mangaURL, err)
+	}
+	return extractSeriesInfo(doc, mangaURL)
+}
+
+func (s *PlaywrightScraper) FindListOfChapters(ctx context.Context, mangaURL string, nChaps int) ([]model.Chapter, error) {
+	if err := checkWeebCentralURL(mangaURL); err != nil {
+		return nil, err
+	}
 
 	if s.page == nil {
 		log.Println("Page is empty. Creating a new one.")
@@ -151,34 +201,62 @@ func (s *PlaywrightScraper) FindListOfChapters(mangaURL string, nChaps int) ([]m
 		}
 	}
 
+	chapters, err := findListOfChapters(ctx, s.page, mangaURL, nChaps)
+	s.dropPageIfDone(ctx)
+	return chapters, err
+}
+
+// findListOfChapters does the work of FindListOfChapters in the given page
+func findListOfChapters(ctx context.Context, page playwright.Page, mangaURL string, nChaps int) (chapters []model.Chapter, err error) {
+	const chapterListSelector = "#chapter-list"
+
+	if err = ctx.Err(); err != nil {
+		return nil, wrapContextError(ctx, mangaURL, err)
+	}
+	defer bindContext(ctx, page)()
+	defer func() { err = wrapContextError(ctx, mangaURL, err) }()
+
 	log.Printf("Navigating to manga URL: %s", mangaURL)
-	resp, err := s.page.Goto(mangaURL)
+	resp, err := page.Goto(mangaURL)
 	if err != nil {
-		return nil, fmt.Errorf("error navigating to %s: %w", mangaURL, err)
+		return nil, requestError(ctx, mangaURL, err)
 	}
-	if !resp.Ok() {
-		return nil, fmt.Errorf("received non-OK status %s for URL %s", resp.StatusText(), mangaURL)
+	if err := responseError(page, resp, mangaURL); err != nil {
+		return nil, err
 	}
 
 	// Check for redirect to 404 page
 	if resp.URL() == "https://weebcentral.com/404" {
-		return nil, fmt.Errorf("manga with url %s was not found", mangaURL)
+		return nil, fmt.Errorf("%w: manga with url %s was not found", ErrNotFound, mangaURL)
 	}
 
 	// Locate chapter elements
-	chapterDivs, err := s.page.Locator(chapterListSelector).Locator("div").All()
+	if n, err := page.Locator(chapterListSelector).Count(); err == nil && n == 0 {
+		return nil, fmt.Errorf("%w: %s not found in %s", ErrLayoutChanged, chapterListSelector, mangaURL)
+	}
+	chapterDivs, err := page.Locator(chapterListSelector).Locator("div").All()
 	if err != nil {
-		return nil, fmt.Errorf("failed to locate chapters: %w", err)
+		return nil, fmt.Errorf("%w: failed to locate chapters: %w", ErrLayoutChanged, err)
+	}
+
+	// only the last chapters are shown, the button loads the others in the list
+	if nChaps == AllChapters || len(chapterDivs) < nChaps {
+		if chapterDivs, err = showAllChapters(page, chapterListSelector, chapterDivs); err != nil {
+			return nil, err
+		}
 	}
 
 	// Limit chapters if requested nChaps is less than found chapters
 	limit := nChaps
-	if limit > len(chapterDivs) {
+	if limit == AllChapters || limit > len(chapterDivs) {
 		limit = len(chapterDivs)
 	}
+	if limit < 0 {
+		limit = 0
+	}
 	chapterDivs = chapterDivs[:limit]
 
-	chapters := make([]model.Chapter, 0, limit)
+	chapters = make([]model.Chapter, 0, limit)
 
 	for _, chDiv := range chapterDivs {
 		title, date, href := extractChapterData(chDiv)
@@ -186,16 +264,18 @@ func (s *PlaywrightScraper) FindListOfChapters(mangaURL string, nChaps int) ([]m
 		chapters = append(chapters, model.Chapter{
 			Title:      title,
 			Url:        href,
+			Number:     model.ParseChapterNumber(title),
 			ReleasedAt: date,
+			Language:   weebCentralLanguage,
 		})
 	}
 
 	return chapters, nil
 }
 
-func (s *PlaywrightScraper) FindImgUrlsOfChapter(chapterURL string) ([]string, error) {
-	if !strings.HasPrefix(chapterURL, WeebCentralBaseURL) {
-		return nil, fmt.Errorf("url %q does not have prefix %q", chapterURL, WeebCentralBaseURL)
+func (s *PlaywrightScraper) FindImgUrlsOfChapter(ctx context.Context, chapterURL string) ([]string, error) {
+	if err := checkWeebCentralURL(chapterURL); err != nil {
+		return nil, err
 	}
 	if chapterURL == "" {
 		return nil, fmt.Errorf("chapterURL is empty")
@@ -207,26 +287,39 @@ func (s *PlaywrightScraper) FindImgUrlsOfChapter(chapterURL string) ([]string, e
 		}
 	}
 
-	r, err := s.page.Goto(chapterURL)
+	imgs, err := findImgUrlsOfChapter(ctx, s.page, chapterURL)
+	s.dropPageIfDone(ctx)
+	return imgs, err
+}
+
+// findImgUrlsOfChapter does the work of FindImgUrlsOfChapter in the given page
+func findImgUrlsOfChapter(ctx context.Context, page playwright.Page, chapterURL string) (imgs []string, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, wrapContextError(ctx, chapterURL, err)
+	}
+	defer bindContext(ctx, page)()
+	defer func() { err = wrapContextError(ctx, chapterURL, err) }()
+
+	r, err := page.Goto(chapterURL)
 	if err != nil {
-		return nil, fmt.Errorf("error navigating to %s: %w", chapterURL, err)
+		return nil, requestError(ctx, chapterURL, err)
 	}
-	if !r.Ok() {
-		return nil, fmt.Errorf("received non-OK status %s for URL %s", r.StatusText(), chapterURL)
+	if err := responseError(page, r, chapterURL); err != nil {
+		return nil, err
 	}
 
 	const xpathImgsContainer = "/html/body/main/section[3]"
-	container := s.page.Locator(fmt.Sprintf("xpath=%s", xpathImgsContainer))
+	container := page.Locator(fmt.Sprintf("xpath=%s", xpathImgsContainer))
 	err = container.WaitFor()
 	if err != nil {
-		return nil, fmt.Errorf("container not found: %w", err)
+		return nil, fmt.Errorf("%w: container not found: %w", ErrLayoutChanged, err)
 	}
 	imgList, err := container.Locator("img").All()
 	if err != nil {
-		return nil, fmt.Errorf("failed to locate imgs: %w", err)
+		return nil, fmt.Errorf("%w: failed to locate imgs: %w", ErrLayoutChanged, err)
 	}
 
-	imgs := make([]string, 0, len(imgList))
+	imgs = make([]string, 0, len(imgList))
 	for _, img := range imgList {
 		src, err := img.GetAttribute("src")
 		if err != nil {
@@ -258,11 +351,43 @@ func (s *PlaywrightScraper) CurrentPageTitle() (string, error) {
 
 func (s *PlaywrightScraper) Close() {
 	slog.Info("Closing the browser...")
+	if err := s.context.Close(); err != nil {
+		logger.Log.Errorw("failed to close context", "err", err)
+	}
 	err := s.browser.Close()
 	if err != nil {
 		logger.Log.Errorw("failed to close browser", "err", err)
 		return
 	}
+	if err := s.pw.Stop(); err != nil {
+		logger.Log.Errorw("failed to stop playwright", "err", err)
+	}
+}
+
+// launchBrowser starts playwright and the browser of the configuration
+func launchBrowser(cfg Configuration) (*playwright.Playwright, playwright.Browser, error) {
+	pw, err := playwright.Run()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	browserOpt := getBrowserOptions(cfg)
+	var browser playwright.Browser
+	switch cfg.browserType {
+	case Chromium:
+		browser, err = pw.Chromium.Launch(*browserOpt)
+	case Firefox:
+		browser, err = pw.Firefox.Launch(*browserOpt)
+	case Webkit:
+		browser, err = pw.WebKit.Launch(*browserOpt)
+	default:
+		err = errors.New("browser is not supported")
+	}
+	if err != nil {
+		_ = pw.Stop()
+		return nil, nil, err
+	}
+	return pw, browser, nil
 }
 
 func getBrowserOptions(cfg Configuration) *playwright.BrowserTypeLaunchOptions {
@@ -313,6 +438,27 @@ func getContextOptions() playwright.BrowserNewContextOptions {
 
 }
 
+// showAllChapters clicks the "Show All Chapters" button and waits for htmx to replace the list.
+// When there is no button the list is already complete
+func showAllChapters(page playwright.Page, listSelector string, shown []playwright.Locator) ([]playwright.Locator, error) {
+	button := page.Locator(listSelector + ` button[hx-get*="full-chapter-list"]`)
+	if n, err := button.Count(); err != nil || n == 0 {
+		return shown, nil
+	}
+	if err := button.Click(); err != nil {
+		return nil, fmt.Errorf("%w: could not show all chapters: %w", ErrLayoutChanged, err)
+	}
+	err := button.WaitFor(playwright.LocatorWaitForOptions{State: playwright.WaitForSelectorStateDetached})
+	if err != nil {
+		return nil, fmt.Errorf("%w: full chapter list not loaded: %w", ErrLayoutChanged, err)
+	}
+	all, err := page.Locator(listSelector).Locator("div").All()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to locate chapters: %w", ErrLayoutChanged, err)
+	}
+	return all, nil
+}
+
 func extractChapterData(divLoc playwright.Locator) (title string, releasedAt time.Time, href string) {
 	aLoc := divLoc.Locator("a")
 
@@ -348,6 +494,61 @@ func extractChapterData(divLoc playwright.Locator) (title string, releasedAt tim
 	return title, releasedAt, href
 }
 
+// responseError classifies the answer of a navigation, nil if the page can be read
+func responseError(page playwright.Page, r playwright.Response, url string) error {
+	if r == nil {
+		return nil
+	}
+	header := http.Header{}
+	for k, v := range r.Headers() {
+		header.Set(k, v)
+	}
+	title, _ := page.Title()
+	challenge := isChallenge(header, title)
+	if !r.Ok() || challenge {
+		return statusError(r.Status(), url, challenge)
+	}
+	return nil
+}
+
+// default timeout of navigations and waits when the context has no deadline, as in playwright
+const defaultPageTimeout = 30 * time.Second
+
+// bindContext makes the page honor the context: the deadline of the context becomes the timeout
+// of navigations and waits, and when the context is done the page is closed, which aborts them.
+// The returned function must be called when the work with the page is over
+func bindContext(ctx context.Context, page playwright.Page) func() {
+	timeout := defaultPageTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	// for playwright 0 means no timeout
+	ms := max(float64(timeout.Milliseconds()), 1)
+	page.SetDefaultTimeout(ms)
+	page.SetDefaultNavigationTimeout(ms)
+
+	stop := context.AfterFunc(ctx, func() {
+		if err := page.Close(); err != nil {
+			log.Printf("failed to close page of a done context: %v", err)
+		}
+	})
+	return func() { stop() }
+}
+
+// dropPageIfDone forgets the page closed by bindContext, a new one is created by the next call
+func (s *PlaywrightScraper) dropPageIfDone(ctx context.Context) {
+	if ctx.Err() != nil {
+		s.page = nil
+	}
+}
+
+func checkWeebCentralURL(url string) error {
+	if !strings.HasPrefix(url, WeebCentralBaseURL) {
+		return fmt.Errorf("url %q does not have prefix %q", url, WeebCentralBaseURL)
+	}
+	return nil
+}
+
 func makeNewPage(s *PlaywrightScraper) error {
 	page, err := s.context.NewPage()
 	if err != nil {
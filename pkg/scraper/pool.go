@@ -0,0 +1,249 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/akarakai/gomanga-tbot/pkg/logger"
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+	"github.com/playwright-community/playwright-go"
+)
+
+// PlaywrightPool is a Scraper which can be used by more goroutines at the same time.
+// The browser is shared, but every call gets its own context and page, closed when the call ends.
+// At most maxConcurrency calls run together, the others wait in queue for a free slot
+type PlaywrightPool struct {
+	pw      *playwright.Playwright
+	browser playwright.Browser
+	cfg     Configuration
+	slots   *slots
+
+	mu        sync.Mutex
+	lastURL   string
+	lastTitle string
+}
+
+// NewWeebCentralScraperPool launches the browser of the configuration.
+// maxConcurrency is the number of pages which can be open at the same time
+func NewWeebCentralScraperPool(cfg Configuration, maxConcurrency int) (*PlaywrightPool, error) {
+	if maxConcurrency < 1 {
+		return nil, fmt.Errorf("max concurrency must be at least 1, got %d", maxConcurrency)
+	}
+
+	pw, browser, err := launchBrowser(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("scraper pool created successfully with %d slots", maxConcurrency)
+	return &PlaywrightPool{
+		pw:      pw,
+		browser: browser,
+		cfg:     cfg,
+		slots:   newSlots(maxConcurrency),
+	}, nil
+}
+
+// No need to pass configuration
+func NewWeebCentralScraperPoolDefault(maxConcurrency int) (*PlaywrightPool, error) {
+	cfg := Configuration{
+		headless:    true,
+		isOptimized: false,
+		browserType: Chromium,
+	}
+
+	return NewWeebCentralScraperPool(cfg, maxConcurrency)
+}
+
+func (p *PlaywrightPool) FindListOfMangas(ctx context.Context, query string) ([]model.Manga, error) {
+	if query == "" {
+		return nil, errors.New("query is empty")
+	}
+	var mangas []model.Manga
+	err := p.withPage(ctx, WeebCentralBaseURL, func(page playwright.Page) error {
+		var err error
+		mangas, err = findListOfMangas(ctx, page, query)
+		return err
+	})
+	return mangas, err
+}
+
+func (p *PlaywrightPool) FindMangaDetails(ctx context.Context, mangaURL string) (model.Manga, error) {
+	if err := checkWeebCentralURL(mangaURL); err != nil {
+		return model.Manga{}, err
+	}
+	var manga model.Manga
+	err := p.withPage(ctx, mangaURL, func(page playwright.Page) error {
+		var err error
+		manga, err = findMangaDetails(ctx, page, mangaURL)
+		return err
+	})
+	return manga, err
+}
+
+func (p *PlaywrightPool) FindListOfChapters(ctx context.Context, mangaURL string, nChaps int) ([]model.Chapter, error) {
+	if err := checkWeebCentralURL(mangaURL); err != nil {
+		return nil, err
+	}
+	var chapters []model.Chapter
+	err := p.withPage(ctx, mangaURL, func(page playwright.Page) error {
+		var err error
+		chapters, err = findListOfChapters(ctx, page, mangaURL, nChaps)
+		return err
+	})
+	return chapters, err
+}
+
+func (p *PlaywrightPool) FindImgUrlsOfChapter(ctx context.Context, chapterURL string) ([]string, error) {
+	if chapterURL == "" {
+		return nil, fmt.Errorf("chapterURL is empty")
+	}
+	if err := checkWeebCentralURL(chapterURL); err != nil {
+		return nil, err
+	}
+	var imgs []string
+	err := p.withPage(ctx, chapterURL, func(page playwright.Page) error {
+		var err error
+		imgs, err = findImgUrlsOfChapter(ctx, page, chapterURL)
+		return err
+	})
+	return imgs, err
+}
+
+// CurrentUrl returns the url of the page of the last call which ended
+func (p *PlaywrightPool) CurrentUrl() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastURL
+}
+
+// CurrentPageTitle returns the title of the page of the last call which ended
+func (p *PlaywrightPool) CurrentPageTitle() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lastTitle == "" {
+		return "", errors.New("title of the page not found")
+	}
+	return p.lastTitle, nil
+}
+
+// Close waits for the running calls to end, then closes the browser.
+// The calls made after Close return an error
+func (p *PlaywrightPool) Close() {
+	if !p.slots.close() {
+		return
+	}
+
+	log.Println("Closing the browser of the pool...")
+	if err := p.browser.Close(); err != nil {
+		logger.Log.Errorw("failed to close browser", "err", err)
+	}
+	if err := p.pw.Stop(); err != nil {
+		logger.Log.Errorw("failed to stop playwright", "err", err)
+	}
+}
+
+// withPage waits for a free slot, then runs f in a new page of a new context.
+// Context and page are closed and the slot released when f returns.
+// If ctx is done while waiting, the call leaves the queue
+func (p *PlaywrightPool) withPage(ctx context.Context, url string, f func(page playwright.Page) error) error {
+	if err := p.slots.acquire(ctx); err != nil {
+		return wrapContextError(ctx, url, err)
+	}
+	defer p.slots.release()
+
+	context, err := p.browser.NewContext(getContextOptions())
+	if err != nil {
+		return fmt.Errorf("could not create context: %w", err)
+	}
+	defer func() {
+		if err := context.Close(); err != nil {
+			logger.Log.Errorw("failed to close context", "err", err)
+		}
+	}()
+	if p.cfg.route != nil {
+		if err := p.cfg.route(context); err != nil {
+			return fmt.Errorf("could not route the context: %w", err)
+		}
+	}
+
+	page, err := context.NewPage()
+	if err != nil {
+		return fmt.Errorf("could not create page: %w", err)
+	}
+
+	err = f(page)
+
+	title, _ := page.Title()
+	p.mu.Lock()
+	p.lastURL = page.URL()
+	p.lastTitle = title
+	p.mu.Unlock()
+
+	return err
+}
+
+var errScraperClosed = errors.New("scraper is closed")
+
+// slots limits the calls running at the same time, the others wait in queue
+type slots struct {
+	ch   chan struct{}
+	done chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newSlots(n int) *slots {
+	return &slots{
+		ch:   make(chan struct{}, n),
+		done: make(chan struct{}),
+	}
+}
+
+// acquire waits in queue for a free slot. The waiting ends with an error if the slots are closed
+// or ctx is done
+func (s *slots) acquire(ctx context.Context) error {
+	select {
+	case s.ch <- struct{}{}:
+	case <-s.done:
+		return errScraperClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		s.release()
+		return errScraperClosed
+	}
+	return nil
+}
+
+func (s *slots) release() {
+	<-s.ch
+}
+
+// close makes the waiting and the next calls of acquire fail, then waits for the slots
+// in use to be released. It returns false if the slots were already closed
+func (s *slots) close() bool {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return false
+	}
+	s.closed = true
+	close(s.done)
+	s.mu.Unlock()
+
+	// taking all the slots means that no call is running
+	for i := 0; i < cap(s.ch); i++ {
+		s.ch <- struct{}{}
+	}
+	return true
+}
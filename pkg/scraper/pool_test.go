@@ -0,0 +1,159 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewWeebCentralScraperPoolBadConcurrency(t *testing.T) {
+	if _, err := NewWeebCentralScraperPoolDefault(0); err == nil {
+		t.Error("Expected error, got nil")
+	}
+}
+
+func TestPlaywrightPoolConcurrentCalls(t *testing.T) {
+	if file := missingFixture(); file != "" {
+		t.Fatalf("%s is not saved in %s, run the tests with -record to save the pages", file, fixturesDir)
+	}
+
+	cfg := Configuration{
+		headless:    true,
+		browserType: Chromium,
+		route:       routeWeebCentral(false),
+	}
+	p, err := NewWeebCentralScraperPool(cfg, 2)
+	if err != nil {
+		t.Fatalf("failed to initialize pool: %v", err)
+	}
+	defer p.Close()
+
+	const mangaURL = "https://weebcentral.com/series/01J76XYFXM8RHFVVCN0PJBPAT8/Hikaru-ga-Shinda-Natsu"
+	const calls = 4
+
+	results := make([]string, calls)
+	errs := make([]error, calls)
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			chapters, err := p.FindListOfChapters(context.Background(), mangaURL, 1)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			if len(chapters) == 1 {
+				results[i] = chapters[0].Url
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < calls; i++ {
+		if errs[i] != nil {
+			t.Fatalf("call %d failed: %v", i, errs[i])
+		}
+		if results[i] == "" || results[i] != results[0] {
+			t.Errorf("calls stomped on each other: %v", results)
+		}
+	}
+
+	p.Close()
+	if _, err := p.FindListOfChapters(context.Background(), mangaURL, 1); err == nil {
+		t.Error("Expected error after Close, got nil")
+	}
+}
+
+func TestSlotsAcquireRelease(t *testing.T) {
+	s := newSlots(2)
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := s.acquire(ctx); err != nil {
+			t.Fatalf("acquire %d: %v", i, err)
+		}
+	}
+
+	acquired := make(chan error)
+	go func() { acquired <- s.acquire(ctx) }()
+	select {
+	case err := <-acquired:
+		t.Fatalf("Expected the third call to wait, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.release()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("Expected the waiting call to get the released slot, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The waiting call did not get the released slot")
+	}
+}
+
+func TestSlotsAcquireCanceled(t *testing.T) {
+	s := newSlots(1)
+	if err := s.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	acquired := make(chan error)
+	go func() { acquired <- s.acquire(ctx) }()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if err := <-acquired; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context canceled, got %v", err)
+	}
+
+	// the canceled call left the queue without taking the slot
+	s.release()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.acquire(ctx); err != nil {
+		t.Errorf("Expected the slot to be free, got %v", err)
+	}
+}
+
+func TestSlotsClose(t *testing.T) {
+	s := newSlots(1)
+	ctx := context.Background()
+	if err := s.acquire(ctx); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	waiting := make(chan error)
+	go func() { waiting <- s.acquire(ctx) }()
+	closed := make(chan bool)
+	go func() { closed <- s.close() }()
+
+	if err := <-waiting; !errors.Is(err, errScraperClosed) {
+		t.Errorf("Expected the waiting call to fail, got %v", err)
+	}
+	select {
+	case <-closed:
+		t.Fatal("Expected close to wait for the running call")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.release()
+	select {
+	case ok := <-closed:
+		if !ok {
+			t.Error("Expected the first close to return true")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("close did not return after the release")
+	}
+
+	if err := s.acquire(ctx); !errors.Is(err, errScraperClosed) {
+		t.Errorf("Expected acquire after close to fail, got %v", err)
+	}
+	if s.close() {
+		t.Error("Expected the second close to return false")
+	}
+}
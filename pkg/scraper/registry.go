@@ -0,0 +1,226 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+)
+
+const WeebCentralSource = "weebcentral"
+const MangaDexSource = "mangadex"
+
+type source struct {
+	name     string
+	prefixes []string
+	scraper  Scraper
+}
+
+// Registry maps the url prefixes of the sites to the Scraper which knows how to read them.
+// The registry is itself a Scraper: searches are made on every source and the other calls
+// are dispatched to the source owning the url
+type Registry struct {
+	mu      sync.Mutex
+	sources []source
+	last    Scraper
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a source. The order of registration is the order of the search results
+func (r *Registry) Register(name string, s Scraper, urlPrefixes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sources = append(r.sources, source{
+		name:     name,
+		prefixes: urlPrefixes,
+		scraper:  s,
+	})
+}
+
+// ForURL returns the scraper and the name of the source owning the url.
+// When more prefixes match, the longest wins
+func (r *Registry) ForURL(rawURL string) (Scraper, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, "", fmt.Errorf("no source registered for url %q", rawURL)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var found *source
+	longest := -1
+	for i, src := range r.sources {
+		for _, prefix := range src.prefixes {
+			if n := matchPrefix(u, prefix); n > longest {
+				found = &r.sources[i]
+				longest = n
+			}
+		}
+	}
+	if found == nil {
+		return nil, "", fmt.Errorf("no source registered for url %q", rawURL)
+	}
+	return found.scraper, found.name, nil
+}
+
+// matchPrefix returns the length of the path of the prefix when the url is under it, -1 otherwise.
+// The hosts must be the same, www. apart: https://mangadex.org.evil.com is not https://mangadex.org
+func matchPrefix(u *url.URL, prefix string) int {
+	p, err := url.Parse(prefix)
+	if err != nil || siteHost(p) != siteHost(u) {
+		return -1
+	}
+	path := strings.TrimSuffix(p.Path, "/")
+	if u.Path != path && !strings.HasPrefix(u.Path, path+"/") {
+		return -1
+	}
+	return len(path)
+}
+
+func siteHost(u *url.URL) string {
+	return strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+}
+
+// Source returns the scraper registered with the given name
+func (r *Registry) Source(name string) (Scraper, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, src := range r.sources {
+		if src.name == name {
+			return src.scraper, nil
+		}
+	}
+	return nil, fmt.Errorf("source %q is not registered", name)
+}
+
+// Names returns the names of the sources in order of registration
+func (r *Registry) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.sources))
+	for _, src := range r.sources {
+		names = append(names, src.name)
+	}
+	return names
+}
+
+// FindListOfMangas searches the query in every source and merges the results.
+// A failing source is skipped, the error is returned only if no source worked
+func (r *Registry) FindListOfMangas(ctx context.Context, query string) ([]model.Manga, error) {
+	if query == "" {
+		return nil, errors.New("query is empty")
+	}
+
+	r.mu.Lock()
+	sources := make([]source, len(r.sources))
+	copy(sources, r.sources)
+	r.mu.Unlock()
+	if len(sources) == 0 {
+		return nil, errors.New("no source registered")
+	}
+
+	var mangas []model.Manga
+	var errs []error
+	for _, src := range sources {
+		found, err := src.scraper.FindListOfMangas(ctx, query)
+		if err != nil && ctx.Err() != nil {
+			// the other sources would fail too
+			return nil, err
+		}
+		if err != nil {
+			log.Printf("source %s failed to search %q: %v", src.name, query, err)
+			errs = append(errs, fmt.Errorf("%s: %w", src.name, err))
+			continue
+		}
+		for _, m := range found {
+			m.Source = src.name
+			mangas = append(mangas, m)
+		}
+	}
+	if len(errs) == len(sources) {
+		return nil, errors.Join(errs...)
+	}
+	if mangas == nil {
+		mangas = []model.Manga{}
+	}
+	return mangas, nil
+}
+
+// FindMangaDetails asks the details to the source owning the url, the source of the manga is set
+func (r *Registry) FindMangaDetails(ctx context.Context, mangaURL string) (model.Manga, error) {
+	s, name, err := r.ForURL(mangaURL)
+	if err != nil {
+		return model.Manga{}, err
+	}
+	r.setLast(s)
+	manga, err := s.FindMangaDetails(ctx, mangaURL)
+	if err != nil {
+		return model.Manga{}, err
+	}
+	manga.Source = name
+	return manga, nil
+}
+
+func (r *Registry) FindListOfChapters(ctx context.Context, mangaURL string, nChaps int) ([]model.Chapter, error) {
+	s, _, err := r.ForURL(mangaURL)
+	if err != nil {
+		return nil, err
+	}
+	r.setLast(s)
+	return s.FindListOfChapters(ctx, mangaURL, nChaps)
+}
+
+func (r *Registry) FindImgUrlsOfChapter(ctx context.Context, chapterURL string) ([]string, error) {
+	s, _, err := r.ForURL(chapterURL)
+	if err != nil {
+		return nil, err
+	}
+	r.setLast(s)
+	return s.FindImgUrlsOfChapter(ctx, chapterURL)
+}
+
+// CurrentUrl returns the url of the last source used
+func (r *Registry) CurrentUrl() string {
+	r.mu.Lock()
+	last := r.last
+	r.mu.Unlock()
+	if last == nil {
+		return ""
+	}
+	return last.CurrentUrl()
+}
+
+// CurrentPageTitle returns the title of the page of the last source used
+func (r *Registry) CurrentPageTitle() (string, error) {
+	r.mu.Lock()
+	last := r.last
+	r.mu.Unlock()
+	if last == nil {
+		return "", errors.New("title of the page not found")
+	}
+	return last.CurrentPageTitle()
+}
+
+// Close closes every registered source
+func (r *Registry) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, src := range r.sources {
+		src.scraper.Close()
+	}
+}
+
+func (r *Registry) setLast(s Scraper) {
+	r.mu.Lock()
+	r.last = s
+	r.mu.Unlock()
+}
@@ -0,0 +1,193 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+)
+
+// fakeScraper returns always the same data and remembers the urls requested
+type fakeScraper struct {
+	mangas    []model.Manga
+	chapters  []model.Chapter
+	imgs      []string
+	err       error
+	requested []string
+	closed    bool
+}
+
+func (f *fakeScraper) FindListOfMangas(ctx context.Context, query string) ([]model.Manga, error) {
+	f.requested = append(f.requested, query)
+	return f.mangas, f.err
+}
+
+func (f *fakeScraper) FindMangaDetails(ctx context.Context, mangaURL string) (model.Manga, error) {
+	f.requested = append(f.requested, mangaURL)
+	if len(f.mangas) == 0 {
+		return model.Manga{}, f.err
+	}
+	return f.mangas[0], f.err
+}
+
+func (f *fakeScraper) FindListOfChapters(ctx context.Context, mangaURL string, nChaps int) ([]model.Chapter, error) {
+	f.requested = append(f.requested, mangaURL)
+	return f.chapters, f.err
+}
+
+func (f *fakeScraper) FindImgUrlsOfChapter(ctx context.Context, chapterURL string) ([]string, error) {
+	f.requested = append(f.requested, chapterURL)
+	return f.imgs, f.err
+}
+
+func (f *fakeScraper) CurrentUrl() string {
+	if len(f.requested) == 0 {
+		return ""
+	}
+	return f.requested[len(f.requested)-1]
+}
+
+func (f *fakeScraper) CurrentPageTitle() (string, error) { return "", nil }
+
+func (f *fakeScraper) Close() { f.closed = true }
+
+func TestRegistryForURL(t *testing.T) {
+	weeb := &fakeScraper{}
+	dex := &fakeScraper{}
+	r := NewRegistry()
+	r.Register(WeebCentralSource, weeb, WeebCentralBaseURL)
+	r.Register(MangaDexSource, dex, MangaDexBaseURL)
+
+	cases := map[string]string{
+		"https://weebcentral.com/series/01J76XYFXM8RHFVVCN0PJBPAT8/Hikaru-ga-Shinda-Natsu": WeebCentralSource,
+		"https://mangadex.org/title/801513ba-a712-498c-8f57-cae55b38cc92":                  MangaDexSource,
+	}
+	for url, expected := range cases {
+		_, name, err := r.ForURL(url)
+		if err != nil {
+			t.Fatalf("ForURL(%q): %v", url, err)
+		}
+		if name != expected {
+			t.Errorf("ForURL(%q) = %q, want %q", url, name, expected)
+		}
+	}
+
+	if _, _, err := r.ForURL("https://www.mangadex.org/title/801513ba-a712-498c-8f57-cae55b38cc92"); err != nil {
+		t.Errorf("Expected the www. host of mangadex to match, got %v", err)
+	}
+	for _, url := range []string{
+		"https://example.com/manga/1",
+		"https://mangadex.org.evil.com/title/801513ba-a712-498c-8f57-cae55b38cc92",
+		"https://weebcentral.community/series/1",
+		"not a url",
+	} {
+		if _, _, err := r.ForURL(url); err == nil {
+			t.Errorf("Expected error for %q, got nil", url)
+		}
+	}
+}
+
+func TestRegistryLongestPrefixWins(t *testing.T) {
+	r := NewRegistry()
+	r.Register("generic", &fakeScraper{}, "https://example.com")
+	r.Register("special", &fakeScraper{}, "https://example.com/special")
+
+	_, name, err := r.ForURL("https://example.com/special/manga")
+	if err != nil {
+		t.Fatalf("ForURL: %v", err)
+	}
+	if name != "special" {
+		t.Errorf("Expected special, got %q", name)
+	}
+}
+
+func TestRegistryDispatch(t *testing.T) {
+	weeb := &fakeScraper{chapters: []model.Chapter{{Title: "weeb"}}}
+	dex := &fakeScraper{chapters: []model.Chapter{{Title: "dex"}}, imgs: []string{"img"}}
+	r := NewRegistry()
+	r.Register(WeebCentralSource, weeb, WeebCentralBaseURL)
+	r.Register(MangaDexSource, dex, MangaDexBaseURL)
+
+	chs, err := r.FindListOfChapters(context.Background(), "https://mangadex.org/title/abc", 1)
+	if err != nil {
+		t.Fatalf("FindListOfChapters: %v", err)
+	}
+	if chs[0].Title != "dex" {
+		t.Errorf("Expected chapters of mangadex, got %q", chs[0].Title)
+	}
+	if len(weeb.requested) != 0 {
+		t.Errorf("Weebcentral should not be called, got %v", weeb.requested)
+	}
+
+	dex.mangas = []model.Manga{{Title: "Berserk"}}
+	manga, err := r.FindMangaDetails(context.Background(), "https://mangadex.org/title/abc")
+	if err != nil {
+		t.Fatalf("FindMangaDetails: %v", err)
+	}
+	if manga.Source != MangaDexSource {
+		t.Errorf("Expected source %q, got %q", MangaDexSource, manga.Source)
+	}
+
+	if _, err := r.FindImgUrlsOfChapter(context.Background(), "https://mangadex.org/chapter/abc"); err != nil {
+		t.Fatalf("FindImgUrlsOfChapter: %v", err)
+	}
+	if r.CurrentUrl() != "https://mangadex.org/chapter/abc" {
+		t.Errorf("Unexpected current url %q", r.CurrentUrl())
+	}
+
+	r.Close()
+	if !weeb.closed || !dex.closed {
+		t.Error("Expected all sources to be closed")
+	}
+}
+
+func TestRegistryFindListOfMangas(t *testing.T) {
+	t.Run("MergesAndTagsSources", func(t *testing.T) {
+		r := NewRegistry()
+		r.Register(WeebCentralSource, &fakeScraper{mangas: []model.Manga{{Title: "Berserk"}}}, WeebCentralBaseURL)
+		r.Register(MangaDexSource, &fakeScraper{mangas: []model.Manga{{Title: "Berserk"}}}, MangaDexBaseURL)
+
+		mangas, err := r.FindListOfMangas(context.Background(), "Berserk")
+		if err != nil {
+			t.Fatalf("FindListOfMangas: %v", err)
+		}
+		if len(mangas) != 2 {
+			t.Fatalf("Expected 2 mangas, got %d", len(mangas))
+		}
+		if mangas[0].Source != WeebCentralSource || mangas[1].Source != MangaDexSource {
+			t.Errorf("Unexpected sources %q %q", mangas[0].Source, mangas[1].Source)
+		}
+	})
+
+	t.Run("FailingSourceIsSkipped", func(t *testing.T) {
+		r := NewRegistry()
+		r.Register(WeebCentralSource, &fakeScraper{err: errors.New("down")}, WeebCentralBaseURL)
+		r.Register(MangaDexSource, &fakeScraper{mangas: []model.Manga{{Title: "Berserk"}}}, MangaDexBaseURL)
+
+		mangas, err := r.FindListOfMangas(context.Background(), "Berserk")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(mangas) != 1 {
+			t.Errorf("Expected 1 manga, got %d", len(mangas))
+		}
+	})
+
+	t.Run("AllSourcesFail", func(t *testing.T) {
+		r := NewRegistry()
+		r.Register(WeebCentralSource, &fakeScraper{err: errors.New("down")}, WeebCentralBaseURL)
+
+		if _, err := r.FindListOfMangas(context.Background(), "Berserk"); err == nil {
+			t.Error("Expected error, got nil")
+		}
+	})
+
+	t.Run("EmptyQuery", func(t *testing.T) {
+		r := NewRegistry()
+		r.Register(WeebCentralSource, &fakeScraper{}, WeebCentralBaseURL)
+		if _, err := r.FindListOfMangas(context.Background(), ""); err == nil {
+			t.Error("Expected error, got nil")
+		}
+	})
+}
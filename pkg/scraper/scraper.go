@@ -1,17 +1,33 @@
 package scraper
 
 import (
+	"context"
+
 	"github.com/akarakai/gomanga-tbot/pkg/model"
+	"github.com/playwright-community/playwright-go"
 )
 
 const WeebCentralBaseURL = "https://weebcentral.com"
+
+// the chapters of WeebCentral are all translated in english
+const weebCentralLanguage = "en"
+
 const WindowHeight = 400
 const WindowWidth = 400
 
+// AllChapters can be passed as nChaps to FindListOfChapters for getting the full list
+const AllChapters = -1
+
+// Scraper reads mangas, chapters and pages from a site.
+// The methods which access the site stop when ctx is done. When the deadline of ctx
+// expires, or the site does not answer in time, the error is a *TimeoutError.
+// FindMangaDetails returns the manga with its metadata, but without the last chapter.
+// FindListOfChapters returns the chapters from the most recent, with their number parsed
 type Scraper interface {
-	FindListOfMangas(query string) ([]model.Manga, error)
-	FindListOfChapters(mangaURL string, nChaps int) ([]model.Chapter, error)
-	FindImgUrlsOfChapter(chapterURL string) ([]string, error)
+	FindListOfMangas(ctx context.Context, query string) ([]model.Manga, error)
+	FindMangaDetails(ctx context.Context, mangaURL string) (model.Manga, error)
+	FindListOfChapters(ctx context.Context, mangaURL string, nChaps int) ([]model.Chapter, error)
+	FindImgUrlsOfChapter(ctx context.Context, chapterURL string) ([]string, error)
 	CurrentUrl() string
 	CurrentPageTitle() (string, error)
 	Close()
@@ -29,4 +45,7 @@ type Configuration struct {
 	headless    bool
 	isOptimized bool
 	browserType BrowserType
+	// when set, it is called with every new browser context before its pages are opened.
+	// The tests use it to serve the saved pages instead of the network
+	route func(context playwright.BrowserContext) error
 }
@@ -1,28 +1,57 @@
 package scraper
 
 import (
-	"log"
+	"context"
+	"flag"
+	"os"
 	"testing"
+
+	"github.com/akarakai/gomanga-tbot/pkg/logger"
+	"go.uber.org/zap"
 )
 
+// The PlaywrightScraper tests read weebcentral.com from the pages saved in testdata/weebcentral,
+// so they do not need the site to be reachable. To save the pages again from the site run
+//
+//	go test ./pkg/scraper -run 'WeebCentralScraper|FindListOf|FindImgUrls' -record
+var record = flag.Bool("record", false, "save again the weebcentral pages used by the playwright tests")
+
+// keep logger safe in tests
+func init() { logger.Log = zap.NewNop().Sugar() }
+
 // TODO avoid to open a new instance of the scraper each time
 // maybe the logic should be done in the scraper itself
 var cachedScraper *PlaywrightScraper
 
-func getScraper() *PlaywrightScraper {
+func TestMain(m *testing.M) {
+	flag.Parse()
+	code := m.Run()
+	if cachedScraper != nil {
+		cachedScraper.Close()
+	}
+	os.Exit(code)
+}
+
+func getScraper(t *testing.T) *PlaywrightScraper {
+	t.Helper()
 	if cachedScraper != nil {
 		return cachedScraper
 	}
 
+	if file := missingFixture(); file != "" && !*record {
+		t.Fatalf("%s is not saved in %s, run the tests with -record to save the pages", file, fixturesDir)
+	}
+
 	cfg := Configuration{
-		headless:    false,
+		headless:    true,
 		isOptimized: false,
 		browserType: Chromium,
+		route:       routeWeebCentral(*record),
 	}
 
 	s, err := NewWeebCentralScraper(cfg)
 	if err != nil {
-		log.Fatalf("failed to initialize scraper: %v", err)
+		t.Fatalf("failed to initialize scraper: %v", err)
 	}
 
 	cachedScraper = s
@@ -30,7 +59,7 @@ func getScraper() *PlaywrightScraper {
 }
 
 func TestNewWeebCentralScraper(t *testing.T) {
-	scraper := getScraper()
+	scraper := getScraper(t)
 
 	if scraper == nil {
 		t.Fatal("Expected scraper instance, got nil")
@@ -38,11 +67,11 @@ func TestNewWeebCentralScraper(t *testing.T) {
 }
 
 func TestFindListOfMangas(t *testing.T) {
-	s := getScraper()
+	s := getScraper(t)
 
 	t.Run("GoodQuery", func(t *testing.T) {
 		query := "Naruto"
-		mangas, err := s.FindListOfMangas(query)
+		mangas, err := s.FindListOfMangas(context.Background(), query)
 		if err != nil {
 			t.Errorf("Failed to find list of mangas: %v", err)
 		}
@@ -53,7 +82,7 @@ func TestFindListOfMangas(t *testing.T) {
 
 	t.Run("EmptyQuery", func(t *testing.T) {
 		bad := ""
-		mangas, err := s.FindListOfMangas(bad)
+		mangas, err := s.FindListOfMangas(context.Background(), bad)
 		if err == nil {
 			t.Error("Expected error, got nil")
 		}
@@ -64,11 +93,11 @@ func TestFindListOfMangas(t *testing.T) {
 }
 
 func TestFindListOfChapters_Extra(t *testing.T) {
-	s := getScraper()
+	s := getScraper(t)
 	validMangaUrl := "https://weebcentral.com/series/01J76XYFXM8RHFVVCN0PJBPAT8/Hikaru-ga-Shinda-Natsu"
 
 	t.Run("ZeroChaptersRequested", func(t *testing.T) {
-		chapters, err := s.FindListOfChapters(validMangaUrl, 0)
+		chapters, err := s.FindListOfChapters(context.Background(), validMangaUrl, 0)
 		if err != nil {
 			t.Fatalf("Expected no error, got %v", err)
 		}
@@ -79,7 +108,7 @@ func TestFindListOfChapters_Extra(t *testing.T) {
 
 	t.Run("RequestMoreChaptersThanAvailable", func(t *testing.T) {
 		nChaps := 1000
-		chapters, err := s.FindListOfChapters(validMangaUrl, nChaps)
+		chapters, err := s.FindListOfChapters(context.Background(), validMangaUrl, nChaps)
 		if err != nil {
 			t.Fatalf("Expected no error, got %v", err)
 		}
@@ -89,7 +118,7 @@ func TestFindListOfChapters_Extra(t *testing.T) {
 	})
 
 	t.Run("EmptyMangaURL", func(t *testing.T) {
-		_, err := s.FindListOfChapters("", 5)
+		_, err := s.FindListOfChapters(context.Background(), "", 5)
 		if err == nil {
 			t.Errorf("Expected error for empty URL, got nil")
 		}
@@ -97,7 +126,7 @@ func TestFindListOfChapters_Extra(t *testing.T) {
 
 	t.Run("NilPageInitialization", func(t *testing.T) {
 		s.page = nil
-		chapters, err := s.FindListOfChapters(validMangaUrl, 2)
+		chapters, err := s.FindListOfChapters(context.Background(), validMangaUrl, 2)
 		if err != nil {
 			t.Fatalf("Expected no error, got %v", err)
 		}
@@ -108,10 +137,10 @@ func TestFindListOfChapters_Extra(t *testing.T) {
 }
 
 func TestFindImgUrlsOfChapter(t *testing.T) {
-	s := getScraper()
+	s := getScraper(t)
 	t.Run("GoodQuery", func(t *testing.T) {
-		chapterURL := "https://weebcentral.com/chapters/01J76XYYGMWHPGZ0EW6T7BAJKA"
-		url, err := s.FindImgUrlsOfChapter(chapterURL)
+		chapterURL := "https://weebcentral.com/chapters/01JN8ZQ2H6WX5S4A0M9B7C3D21"
+		url, err := s.FindImgUrlsOfChapter(context.Background(), chapterURL)
 		if err != nil {
 			t.Errorf("Failed to find urls of the chapter: %v", err)
 		}
@@ -122,7 +151,7 @@ func TestFindImgUrlsOfChapter(t *testing.T) {
 
 	t.Run("EmptyChapterURL", func(t *testing.T) {
 		chapterURL := ""
-		_, err := s.FindImgUrlsOfChapter(chapterURL)
+		_, err := s.FindImgUrlsOfChapter(context.Background(), chapterURL)
 		if err == nil {
 			t.Errorf("Expected error, got nil")
 		}
@@ -130,7 +159,7 @@ func TestFindImgUrlsOfChapter(t *testing.T) {
 
 	t.Run("WrongBaseUrl", func(t *testing.T) {
 		chapterURL := "https://mangadex.com/chapters/01J76XYYGMWHPGZ0EW6T7BAJKA"
-		_, err := s.FindImgUrlsOfChapter(chapterURL)
+		_, err := s.FindImgUrlsOfChapter(context.Background(), chapterURL)
 		if err == nil {
 			t.Errorf("Expected error, got nil")
 		}
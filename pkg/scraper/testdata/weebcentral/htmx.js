@@ -0,0 +1,35 @@
+// A stand-in for htmx with only what the saved pages use: the elements with hx-get or hx-post
+// send their request on hx-trigger (load, input, or click by default) and the answer is swapped
+// into hx-target with hx-swap (innerHTML or outerHTML). The fragments are not processed again.
+document.addEventListener("DOMContentLoaded", function () {
+	document.querySelectorAll("[hx-get], [hx-post]").forEach(function (el) {
+		var method = el.hasAttribute("hx-post") ? "POST" : "GET";
+		var url = el.getAttribute(method === "POST" ? "hx-post" : "hx-get");
+
+		var send = function () {
+			var init = { method: method, headers: { "HX-Request": "true" } };
+			if (method === "POST" && el.name) {
+				init.headers["Content-Type"] = "application/x-www-form-urlencoded";
+				init.body = new URLSearchParams([[el.name, el.value]]).toString();
+			}
+			fetch(url, init)
+				.then(function (resp) { return resp.text(); })
+				.then(function (text) {
+					var selector = el.getAttribute("hx-target");
+					var target = selector ? document.querySelector(selector) : el;
+					if (el.getAttribute("hx-swap") === "outerHTML") {
+						target.outerHTML = text;
+					} else {
+						target.innerHTML = text;
+					}
+				});
+		};
+
+		var trigger = el.getAttribute("hx-trigger") || "click";
+		if (trigger === "load") {
+			send();
+		} else {
+			el.addEventListener(trigger, send);
+		}
+	});
+});
@@ -0,0 +1,90 @@
+package scraper
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+	"golang.org/x/net/html"
+)
+
+// extractSeriesInfo reads the metadata from the html of the page of a series.
+// It is shared by the http and the playwright scraper, the latter parses the content of the page.
+//
+// The details are in a list of <li>, each starting with a <strong> label:
+//
+//	<li><strong>Author(s): </strong><span><a>Mokumokuren</a></span></li>
+//	<li><strong>Tags(s): </strong><span><a>Drama</a>,</span><span><a>Horror</a></span></li>
+//	<li><strong>Status: </strong><a>Ongoing</a></li>
+//	<li><strong>Description</strong><p>...</p></li>
+//	<li><strong>Associated Name(s)</strong><ul><li>The Summer Hikaru Died</li></ul></li>
+func extractSeriesInfo(doc *html.Node, mangaURL string) (model.Manga, error) {
+	manga := model.Manga{Url: mangaURL}
+
+	if h1 := findFirst(doc, isElement("h1")); h1 != nil {
+		manga.Title = textContent(h1)
+	}
+	if manga.Title == "" {
+		return manga, fmt.Errorf("%w: title not found in %s", ErrLayoutChanged, mangaURL)
+	}
+
+	manga.CoverUrl = seriesCover(doc)
+
+	for _, li := range findAll(doc, isElement("li")) {
+		strong := findFirst(li, isElement("strong"))
+		if strong == nil {
+			continue
+		}
+		label := strings.ToLower(strings.TrimSuffix(textContent(strong), ":"))
+		label = strings.TrimSpace(strings.ReplaceAll(label, "(s)", ""))
+
+		switch {
+		case label == "author":
+			manga.Authors = linkTexts(li)
+		case strings.HasPrefix(label, "tag"):
+			manga.Genres = linkTexts(li)
+		case label == "status":
+			if links := linkTexts(li); len(links) > 0 {
+				manga.Status = model.ParseMangaStatus(links[0])
+			}
+		case label == "description":
+			if p := findFirst(li, isElement("p")); p != nil {
+				manga.Description = textContent(p)
+			}
+		case label == "associated name":
+			for _, name := range findAll(li, isElement("li")) {
+				if text := textContent(name); text != "" {
+					manga.AltTitles = append(manga.AltTitles, text)
+				}
+			}
+		}
+	}
+
+	return manga, nil
+}
+
+// the cover is in the og:image meta, the <img> of the page is the fallback
+func seriesCover(doc *html.Node) string {
+	isCoverMeta := func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "meta" && attr(n, "property") == "og:image"
+	}
+	if meta := findFirst(doc, isCoverMeta); meta != nil && attr(meta, "content") != "" {
+		return attr(meta, "content")
+	}
+	if picture := findFirst(doc, isElement("picture")); picture != nil {
+		if img := findFirst(picture, isElement("img")); img != nil {
+			return attr(img, "src")
+		}
+	}
+	return ""
+}
+
+func linkTexts(n *html.Node) []string {
+	var texts []string
+	for _, a := range findAll(n, isElement("a")) {
+		if text := textContent(a); text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return texts
+}
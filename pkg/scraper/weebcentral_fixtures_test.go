@@ -0,0 +1,161 @@
+package scraper
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+var fixturesDir = filepath.Join("testdata", "weebcentral")
+
+// weebCentralPages are the pages of weebcentral.com saved in testdata/weebcentral.
+// The htmx ones are fragments requested with the HX-Request header, as the site does
+var weebCentralPages = []struct {
+	method string
+	path   string
+	file   string
+	htmx   bool
+}{
+	{http.MethodGet, "/", "home.html", false},
+	{http.MethodPost, "/search/simple", "search.html", true},
+	{http.MethodGet, "/series/01J76XYFXM8RHFVVCN0PJBPAT8/Hikaru-ga-Shinda-Natsu", "series.html", false},
+	{http.MethodGet, "/series/01J76XYFXM8RHFVVCN0PJBPAT8/full-chapter-list", "full_chapter_list.html", true},
+	{http.MethodGet, "/chapters/01JN8ZQ2H6WX5S4A0M9B7C3D21", "chapter.html", false},
+	{http.MethodGet, "/chapters/01JN8ZQ2H6WX5S4A0M9B7C3D21/images", "chapter_images.html", true},
+}
+
+// newWeebCentralFixtures serves the saved pages with the paths of the site,
+// plus some pages for the errors
+func newWeebCentralFixtures() http.Handler {
+	mux := http.NewServeMux()
+	for _, p := range weebCentralPages {
+		pattern := p.method + " " + p.path
+		if p.path == "/" {
+			pattern += "{$}"
+		}
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			if p.htmx && r.Header.Get("HX-Request") != "true" {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			if r.Method == http.MethodPost && r.FormValue("text") == "" {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			http.ServeFile(w, r, filepath.Join(fixturesDir, p.file))
+		})
+	}
+
+	mux.HandleFunc("GET /series/00000000000000000000000000/Unknown", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/404", http.StatusFound)
+	})
+	mux.HandleFunc("GET /404", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("<html><head><title>Not Found</title></head></html>"))
+	})
+	// the captcha of cloudflare
+	mux.HandleFunc("GET /series/01J76XYBLOCKED0000000000000/Blocked", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("cf-mitigated", "challenge")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte("<html><head><title>Just a moment...</title></head></html>"))
+	})
+	// a page without the chapter list
+	mux.HandleFunc("GET /series/01J76XYCHANGED0000000000000/Changed", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("<html><head><title>Changed | Weeb Central</title></head><body></body></html>"))
+	})
+	return mux
+}
+
+// missingFixture returns the first saved file which is not in testdata, "" if there are all
+func missingFixture() string {
+	files := []string{"htmx.js"}
+	for _, p := range weebCentralPages {
+		files = append(files, p.file)
+	}
+	for _, file := range files {
+		if _, err := os.Stat(filepath.Join(fixturesDir, file)); err != nil {
+			return file
+		}
+	}
+	return ""
+}
+
+// routeWeebCentral makes the browser context read weebcentral.com from the saved pages,
+// the requests to the other sites are aborted. The saved pages do not load htmx, so
+// testdata/weebcentral/htmx.js is added to them in its place.
+// When recording, the pages are read from the site and saved again
+func routeWeebCentral(recording bool) func(context playwright.BrowserContext) error {
+	fixtures := newWeebCentralFixtures()
+	return func(context playwright.BrowserContext) error {
+		if recording {
+			return context.Route("**/*", recordRoute)
+		}
+		stub, err := os.ReadFile(filepath.Join(fixturesDir, "htmx.js"))
+		if err != nil {
+			return err
+		}
+		script := []byte("<script>" + string(stub) + "</script></body>")
+
+		return context.Route("**/*", func(route playwright.Route) {
+			req := route.Request()
+			u, err := url.Parse(req.URL())
+			if err != nil || "https://"+u.Host != WeebCentralBaseURL {
+				_ = route.Abort()
+				return
+			}
+			body, _ := req.PostData()
+			r := httptest.NewRequest(req.Method(), req.URL(), strings.NewReader(body))
+			for k, v := range req.Headers() {
+				r.Header.Set(k, v)
+			}
+			// the browser must always get the whole page
+			r.Header.Del("If-Modified-Since")
+			r.Header.Del("If-None-Match")
+			w := httptest.NewRecorder()
+			fixtures.ServeHTTP(w, r)
+
+			headers := make(map[string]string, len(w.Header()))
+			for k := range w.Header() {
+				headers[k] = w.Header().Get(k)
+			}
+			delete(headers, "Content-Length")
+			_ = route.Fulfill(playwright.RouteFulfillOptions{
+				Status:  playwright.Int(w.Code),
+				Headers: headers,
+				Body:    bytes.Replace(w.Body.Bytes(), []byte("</body>"), script, 1),
+			})
+		})
+	}
+}
+
+// recordRoute sends the request to the site and saves the answer if it is one of weebCentralPages
+func recordRoute(route playwright.Route) {
+	req := route.Request()
+	resp, err := route.Fetch()
+	if err != nil {
+		_ = route.Abort()
+		return
+	}
+	if u, err := url.Parse(req.URL()); err == nil && "https://"+u.Host == WeebCentralBaseURL {
+		for _, p := range weebCentralPages {
+			if p.method != req.Method() || p.path != u.Path {
+				continue
+			}
+			body, err := resp.Body()
+			if err == nil {
+				err = os.WriteFile(filepath.Join(fixturesDir, p.file), body, 0o644)
+			}
+			if err != nil {
+				log.Printf("could not save %s: %v", p.file, err)
+			}
+		}
+	}
+	_ = route.Fulfill(playwright.RouteFulfillOptions{Response: resp})
+}
@@ -0,0 +1,305 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+	"golang.org/x/net/html"
+)
+
+// WeebCentralHttpScraper reads WeebCentral without a browser.
+// The site is built with htmx: the search box and the chapter reader load html fragments
+// from endpoints that can be requested directly
+type WeebCentralHttpScraper struct {
+	client    *http.Client
+	mu        sync.Mutex
+	lastURL   string
+	lastTitle string
+}
+
+// NewWeebCentralHttpScraper creates the scraper with the given client.
+// In the tests the client transport points to a local server
+func NewWeebCentralHttpScraper(client *http.Client) *WeebCentralHttpScraper {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &WeebCentralHttpScraper{client: client}
+}
+
+// No need to pass configuration
+func NewWeebCentralHttpScraperDefault() *WeebCentralHttpScraper {
+	return NewWeebCentralHttpScraper(nil)
+}
+
+// FindListOfMangas posts the query to the endpoint used by the quick search box.
+//
+// As for the PlaywrightScraper, the returned mangas do not contain the last chapter
+func (s *WeebCentralHttpScraper) FindListOfMangas(ctx context.Context, query string) ([]model.Manga, error) {
+	if query == "" {
+		return nil, errors.New("query is empty")
+	}
+
+	form := url.Values{}
+	form.Set("text", query)
+	searchURL := WeebCentralBaseURL + "/search/simple?location=main"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, searchURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("HX-Request", "true")
+
+	doc, _, err := s.fetch(req)
+	if err != nil {
+		return nil, err
+	}
+
+	mangas := make([]model.Manga, 0, 10)
+	for _, a := range findAll(doc, isElement("a")) {
+		href := attr(a, "href")
+		if !strings.HasPrefix(href, WeebCentralBaseURL+"/series/") {
+			continue
+		}
+		mangas = append(mangas, model.Manga{
+			Title:       textContent(a),
+			Url:         href,
+			LastChapter: nil,
+		})
+	}
+
+	log.Printf("Query \"%s\" gave %d results", query, len(mangas))
+	return mangas, nil
+}
+
+// FindMangaDetails reads the metadata from the page of the series
+func (s *WeebCentralHttpScraper) FindMangaDetails(ctx context.Context, mangaURL string) (model.Manga, error) {
+	if err := checkWeebCentralURL(mangaURL); err != nil {
+		return model.Manga{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mangaURL, nil)
+	if err != nil {
+		return model.Manga{}, err
+	}
+	doc, finalURL, err := s.fetch(req)
+	if err != nil {
+		return model.Manga{}, err
+	}
+	if finalURL.Path == "/404" {
+		return model.Manga{}, fmt.Errorf("%w: manga with url %s was not found", ErrNotFound, mangaURL)
+	}
+
+	return extractSeriesInfo(doc, mangaURL)
+}
+
+// FindListOfChapters finds the required number of most recent chapters from a manga.
+// The page of the series shows only the last chapters, when more are required
+// the full list is requested to the endpoint of the "Show All Chapters" button
+func (s *WeebCentralHttpScraper) FindListOfChapters(ctx context.Context, mangaURL string, nChaps int) ([]model.Chapter, error) {
+	if !strings.HasPrefix(mangaURL, WeebCentralBaseURL) {
+		return nil, fmt.Errorf("url %q does not have prefix %q", mangaURL, WeebCentralBaseURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mangaURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	doc, finalURL, err := s.fetch(req)
+	if err != nil {
+		return nil, err
+	}
+	// Check for redirect to 404 page
+	if finalURL.Path == "/404" {
+		return nil, fmt.Errorf("%w: manga with url %s was not found", ErrNotFound, mangaURL)
+	}
+
+	list := findFirst(doc, hasID("chapter-list"))
+	if list == nil {
+		return nil, fmt.Errorf("%w: failed to locate chapters of %s", ErrLayoutChanged, mangaURL)
+	}
+
+	chapters := extractChapterNodes(list, nChaps)
+	if nChaps == AllChapters || len(chapters) < nChaps {
+		if fullListURL := showAllChaptersURL(list); fullListURL != "" {
+			fullList, err := s.fetchFullChapterList(ctx, fullListURL)
+			if err != nil {
+				return nil, err
+			}
+			chapters = extractChapterNodes(fullList, nChaps)
+		}
+	}
+
+	return chapters, nil
+}
+
+func (s *WeebCentralHttpScraper) fetchFullChapterList(ctx context.Context, fullListURL string) (*html.Node, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullListURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("HX-Request", "true")
+	doc, _, err := s.fetch(req)
+	if err != nil {
+		return nil, err
+	}
+	// the fragment replaces the list, it has the same id
+	if list := findFirst(doc, hasID("chapter-list")); list != nil {
+		return list, nil
+	}
+	return doc, nil
+}
+
+// showAllChaptersURL returns the endpoint of the button which loads the full list, "" when all chapters are shown
+func showAllChaptersURL(list *html.Node) string {
+	for _, button := range findAll(list, isElement("button")) {
+		if hxGet := attr(button, "hx-get"); strings.Contains(hxGet, "full-chapter-list") {
+			return hxGet
+		}
+	}
+	return ""
+}
+
+// extractChapterNodes reads at most nChaps chapters from the links of the list, all with AllChapters
+func extractChapterNodes(list *html.Node, nChaps int) []model.Chapter {
+	chapters := make([]model.Chapter, 0)
+	for _, a := range findAll(list, isElement("a")) {
+		if nChaps != AllChapters && len(chapters) >= nChaps {
+			break
+		}
+		href := attr(a, "href")
+		if !strings.Contains(href, "/chapters/") {
+			continue
+		}
+		title, releasedAt := extractChapterNode(a)
+		chapters = append(chapters, model.Chapter{
+			Title:      title,
+			Url:        href,
+			Number:     model.ParseChapterNumber(title),
+			ReleasedAt: releasedAt,
+			Language:   weebCentralLanguage,
+		})
+	}
+	return chapters
+}
+
+// FindImgUrlsOfChapter requests the fragment the reader loads in long strip mode,
+// which contains all the pages of the chapter
+func (s *WeebCentralHttpScraper) FindImgUrlsOfChapter(ctx context.Context, chapterURL string) ([]string, error) {
+	if chapterURL == "" {
+		return nil, fmt.Errorf("chapterURL is empty")
+	}
+	if !strings.HasPrefix(chapterURL, WeebCentralBaseURL) {
+		return nil, fmt.Errorf("url %q does not have prefix %q", chapterURL, WeebCentralBaseURL)
+	}
+
+	imagesURL := strings.TrimSuffix(chapterURL, "/") + "/images?is_prev=False&current_page=1&reading_style=long_strip"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imagesURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("HX-Request", "true")
+
+	doc, _, err := s.fetch(req)
+	if err != nil {
+		return nil, err
+	}
+
+	imgs := make([]string, 0)
+	for _, img := range findAll(doc, isElement("img")) {
+		src := attr(img, "src")
+		if src == "" {
+			continue
+		}
+		imgs = append(imgs, src)
+	}
+	if len(imgs) == 0 {
+		return nil, fmt.Errorf("%w: no images found for chapter %s", ErrLayoutChanged, chapterURL)
+	}
+
+	log.Printf("Found %d images", len(imgs))
+	return imgs, nil
+}
+
+func (s *WeebCentralHttpScraper) CurrentUrl() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastURL
+}
+
+func (s *WeebCentralHttpScraper) CurrentPageTitle() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastTitle == "" {
+		return "", errors.New("title of the page not found")
+	}
+	return s.lastTitle, nil
+}
+
+func (s *WeebCentralHttpScraper) Close() {
+	s.client.CloseIdleConnections()
+}
+
+// fetch executes the request and parses the html of the response.
+// The final url is returned too, so that redirects can be checked
+func (s *WeebCentralHttpScraper) fetch(req *http.Request) (*html.Node, *url.URL, error) {
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, requestError(req.Context(), req.URL.String(), err)
+	}
+	defer resp.Body.Close()
+
+	doc, err := html.Parse(resp.Body)
+	if err != nil {
+		return nil, nil, requestError(req.Context(), req.URL.String(), err)
+	}
+
+	title := ""
+	if t := findFirst(doc, isElement("title")); t != nil {
+		title = textContent(t)
+	}
+	if resp.StatusCode != http.StatusOK || isChallenge(resp.Header, title) {
+		return nil, nil, statusError(resp.StatusCode, req.URL.String(), isChallenge(resp.Header, title))
+	}
+	s.mu.Lock()
+	s.lastURL = resp.Request.URL.String()
+	s.lastTitle = title
+	s.mu.Unlock()
+
+	return doc, resp.Request.URL, nil
+}
+
+// extractChapterNode reads the same elements of extractChapterData,
+// but from the parsed html of the <a> of a chapter
+func extractChapterNode(a *html.Node) (title string, releasedAt time.Time) {
+	if t := findFirst(a, isElement("time")); t != nil {
+		dateStr := attr(t, "datetime")
+		parsed, err := time.Parse(time.RFC3339Nano, dateStr)
+		if err != nil {
+			log.Printf("Failed to parse datetime %q: %v", dateStr, err)
+		} else {
+			releasedAt = parsed
+		}
+	}
+
+	// the title is in the first span of the second span
+	spans := findAll(a, isElement("span"))
+	if len(spans) > 1 {
+		if inner := findFirst(spans[1], isElement("span")); inner != nil {
+			title = textContent(inner)
+		}
+	}
+	if title == "" {
+		log.Printf("Failed to get title text of chapter %s", attr(a, "href"))
+	}
+	return title, releasedAt
+}
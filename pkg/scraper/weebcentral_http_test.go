@@ -0,0 +1,265 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+)
+
+// rewriteTransport sends every request to the test server, keeping path and query.
+// This way the scraper works with the real urls of the site
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (rt rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.URL.Scheme = rt.target.Scheme
+	r.URL.Host = rt.target.Host
+	r.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// newWeebCentralTestClient serves the pages saved in testdata/weebcentral
+func newWeebCentralTestClient(t *testing.T) *http.Client {
+	t.Helper()
+	srv := httptest.NewServer(newWeebCentralFixtures())
+	t.Cleanup(srv.Close)
+	target, _ := url.Parse(srv.URL)
+	return &http.Client{Transport: rewriteTransport{target: target}}
+}
+
+func TestWeebCentralHttpFindListOfMangas(t *testing.T) {
+	s := NewWeebCentralHttpScraper(newWeebCentralTestClient(t))
+
+	t.Run("GoodQuery", func(t *testing.T) {
+		mangas, err := s.FindListOfMangas(context.Background(), "Naruto")
+		if err != nil {
+			t.Fatalf("Failed to find list of mangas: %v", err)
+		}
+		// the "view all results" link is not a manga
+		if len(mangas) != 2 {
+			t.Fatalf("Expected 2 mangas, got %d", len(mangas))
+		}
+		if mangas[0].Title != "Naruto" {
+			t.Errorf("Expected title Naruto, got %q", mangas[0].Title)
+		}
+		if mangas[0].Url != "https://weebcentral.com/series/01J76XY7E4KZ3GJPRTYV0FS58H/Naruto" {
+			t.Errorf("Unexpected url %q", mangas[0].Url)
+		}
+		if mangas[1].Title != "Naruto (Digital Colored Comics)" {
+			t.Errorf("Unexpected title %q", mangas[1].Title)
+		}
+	})
+
+	t.Run("EmptyQuery", func(t *testing.T) {
+		mangas, err := s.FindListOfMangas(context.Background(), "")
+		if err == nil {
+			t.Error("Expected error, got nil")
+		}
+		if mangas != nil {
+			t.Error("Expected nil mangas")
+		}
+	})
+}
+
+func TestWeebCentralHttpFindMangaDetails(t *testing.T) {
+	s := NewWeebCentralHttpScraper(newWeebCentralTestClient(t))
+
+	t.Run("GoodQuery", func(t *testing.T) {
+		manga, err := s.FindMangaDetails(context.Background(), "https://weebcentral.com/series/01J76XYFXM8RHFVVCN0PJBPAT8/Hikaru-ga-Shinda-Natsu")
+		if err != nil {
+			t.Fatalf("Failed to find details: %v", err)
+		}
+		if manga.Title != "Hikaru ga Shinda Natsu" {
+			t.Errorf("Unexpected title %q", manga.Title)
+		}
+		if manga.CoverUrl != "https://temp.compsci88.com/cover/normal/01J76XYFXM8RHFVVCN0PJBPAT8.webp" {
+			t.Errorf("Unexpected cover %q", manga.CoverUrl)
+		}
+		if !slices.Equal(manga.Authors, []string{"Mokumokuren"}) {
+			t.Errorf("Unexpected authors %v", manga.Authors)
+		}
+		if !slices.Equal(manga.Genres, []string{"Drama", "Horror", "Mystery"}) {
+			t.Errorf("Unexpected genres %v", manga.Genres)
+		}
+		if manga.Status != model.StatusOngoing {
+			t.Errorf("Expected ongoing, got %q", manga.Status)
+		}
+		if !slices.Equal(manga.AltTitles, []string{"The Summer Hikaru Died", "光が死んだ夏"}) {
+			t.Errorf("Unexpected alt titles %v", manga.AltTitles)
+		}
+		if !strings.HasPrefix(manga.Description, "Yoshiki and Hikaru have been friends") {
+			t.Errorf("Unexpected description %q", manga.Description)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		_, err := s.FindMangaDetails(context.Background(), "https://weebcentral.com/series/00000000000000000000000000/Unknown")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected not found error, got %v", err)
+		}
+	})
+
+	t.Run("LayoutChanged", func(t *testing.T) {
+		_, err := s.FindMangaDetails(context.Background(), "https://weebcentral.com/series/01J76XYCHANGED0000000000000/Changed")
+		if !errors.Is(err, ErrLayoutChanged) {
+			t.Errorf("Expected layout changed error, got %v", err)
+		}
+	})
+}
+
+func TestWeebCentralHttpFindListOfChapters(t *testing.T) {
+	s := NewWeebCentralHttpScraper(newWeebCentralTestClient(t))
+	validMangaUrl := "https://weebcentral.com/series/01J76XYFXM8RHFVVCN0PJBPAT8/Hikaru-ga-Shinda-Natsu"
+
+	t.Run("GoodQuery", func(t *testing.T) {
+		chapters, err := s.FindListOfChapters(context.Background(), validMangaUrl, 2)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(chapters) != 2 {
+			t.Fatalf("Expected 2 chapters, got %d", len(chapters))
+		}
+		if chapters[0].Title != "Chapter 34" || chapters[1].Title != "Chapter 33.5" {
+			t.Errorf("Unexpected titles %q %q", chapters[0].Title, chapters[1].Title)
+		}
+		if chapters[0].Url != "https://weebcentral.com/chapters/01JN8ZQ2H6WX5S4A0M9B7C3D21" {
+			t.Errorf("Unexpected url %q", chapters[0].Url)
+		}
+		if chapters[0].ReleasedAt.IsZero() {
+			t.Error("Expected release date")
+		}
+		if chapters[0].Language != "en" {
+			t.Errorf("Expected the chapter in english, got %q", chapters[0].Language)
+		}
+		title, _ := s.CurrentPageTitle()
+		if title != "Hikaru ga Shinda Natsu | Weeb Central" {
+			t.Errorf("Unexpected page title %q", title)
+		}
+	})
+
+	t.Run("ZeroChaptersRequested", func(t *testing.T) {
+		chapters, err := s.FindListOfChapters(context.Background(), validMangaUrl, 0)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(chapters) != 0 {
+			t.Errorf("Expected 0 chapters, got %d", len(chapters))
+		}
+	})
+
+	t.Run("RequestMoreChaptersThanAvailable", func(t *testing.T) {
+		chapters, err := s.FindListOfChapters(context.Background(), validMangaUrl, 1000)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		// the full list is loaded
+		if len(chapters) != 5 {
+			t.Errorf("Expected 5 chapters, got %d", len(chapters))
+		}
+	})
+
+	t.Run("AllChapters", func(t *testing.T) {
+		chapters, err := s.FindListOfChapters(context.Background(), validMangaUrl, AllChapters)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		expected := []float64{34, 33.5, 33, 2, 1}
+		if len(chapters) != len(expected) {
+			t.Fatalf("Expected %d chapters, got %d", len(expected), len(chapters))
+		}
+		for i, ch := range chapters {
+			if ch.Number != expected[i] {
+				t.Errorf("Expected chapter number %v, got %v", expected[i], ch.Number)
+			}
+		}
+		if chapters[4].Url != "https://weebcentral.com/chapters/01J76XYZ6N8P0Q2R4S6T8V0W2B" {
+			t.Errorf("Unexpected url of the first chapter %q", chapters[4].Url)
+		}
+	})
+
+	t.Run("FewChaptersDoNotLoadFullList", func(t *testing.T) {
+		if _, err := s.FindListOfChapters(context.Background(), validMangaUrl, 3); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if strings.Contains(s.CurrentUrl(), "full-chapter-list") {
+			t.Error("Full list requested for chapters shown in the page")
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		_, err := s.FindListOfChapters(context.Background(), "https://weebcentral.com/series/00000000000000000000000000/Unknown", 1)
+		if err == nil {
+			t.Error("Expected error, got nil")
+		}
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected not found error, got %v", err)
+		}
+	})
+
+	t.Run("Blocked", func(t *testing.T) {
+		_, err := s.FindListOfChapters(context.Background(), "https://weebcentral.com/series/01J76XYBLOCKED0000000000000/Blocked", 1)
+		if !errors.Is(err, ErrBlocked) {
+			t.Errorf("Expected blocked error, got %v", err)
+		}
+		if !IsRetryable(err) {
+			t.Error("Expected blocked error to be retryable")
+		}
+	})
+
+	t.Run("LayoutChanged", func(t *testing.T) {
+		_, err := s.FindListOfChapters(context.Background(), "https://weebcentral.com/series/01J76XYCHANGED0000000000000/Changed", 1)
+		if !errors.Is(err, ErrLayoutChanged) {
+			t.Errorf("Expected layout changed error, got %v", err)
+		}
+		if IsRetryable(err) {
+			t.Error("Expected layout changed error not to be retryable")
+		}
+	})
+
+	t.Run("EmptyMangaURL", func(t *testing.T) {
+		_, err := s.FindListOfChapters(context.Background(), "", 5)
+		if err == nil {
+			t.Error("Expected error for empty URL, got nil")
+		}
+	})
+}
+
+func TestWeebCentralHttpFindImgUrlsOfChapter(t *testing.T) {
+	s := NewWeebCentralHttpScraper(newWeebCentralTestClient(t))
+
+	t.Run("GoodQuery", func(t *testing.T) {
+		imgs, err := s.FindImgUrlsOfChapter(context.Background(), "https://weebcentral.com/chapters/01JN8ZQ2H6WX5S4A0M9B7C3D21")
+		if err != nil {
+			t.Fatalf("Failed to find urls of the chapter: %v", err)
+		}
+		if len(imgs) != 3 {
+			t.Fatalf("Expected 3 urls, got %d", len(imgs))
+		}
+		if imgs[0] != "https://hot.planeptune.us/manga/Hikaru-ga-Shinda-Natsu/0034-001.png" {
+			t.Errorf("Unexpected url %q", imgs[0])
+		}
+	})
+
+	t.Run("EmptyChapterURL", func(t *testing.T) {
+		_, err := s.FindImgUrlsOfChapter(context.Background(), "")
+		if err == nil {
+			t.Error("Expected error, got nil")
+		}
+	})
+
+	t.Run("WrongBaseUrl", func(t *testing.T) {
+		_, err := s.FindImgUrlsOfChapter(context.Background(), "https://mangadex.com/chapters/01J76XYYGMWHPGZ0EW6T7BAJKA")
+		if err == nil {
+			t.Error("Expected error, got nil")
+		}
+	})
+}
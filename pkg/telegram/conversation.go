@@ -1,99 +1,263 @@
 package telegram
 
 import (
-	"fmt"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
 
+	"github.com/akarakai/gomanga-tbot/pkg/logger"
 	"github.com/akarakai/gomanga-tbot/pkg/model"
+	"github.com/akarakai/gomanga-tbot/pkg/repository"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
 )
 
-type AddMangaConversationState int
-type CommandManga string
+// ConversationState is the step a conversation is waiting for
+type ConversationState string
 
-const (
-	StartConversation AddMangaConversationState = iota + 1
-	ChosenManga
-	ChoseWhatToDo
-)
+// EndConversation is returned by a step when the conversation is over
+const EndConversation ConversationState = ""
 
-const (
-	Download   CommandManga = "Download"
-	ReadOnline CommandManga = "Read Online"
-	DoNothing  CommandManga = "Do Nothing"
-)
+// conversations without an answer of the user for this long are forgotten
+const conversationTTL = 15 * time.Minute
 
-var convStore = NewConversationStore()
+// Step handles the update received while the conversation is in its state and returns the next state.
+// The data of the session can be changed, it is kept for the next steps
+type Step[T any] func(ctx context.Context, b *bot.Bot, update *models.Update, chatID model.ChatID, data *T) ConversationState
 
-// ConversationsStore is used to save the choices of the user during a conversation with the bot.
-// the encapsulated maps are not thread safe, but it is not needed now because of very low usage
-// when the user is done with the conversation, it is important to call the Candel method
-type ConversationsStore struct {
-	addManga     map[model.ChatID]AddMangaConversationState
-	commandManga map[model.ChatID]CommandManga
-	mangas       map[model.ChatID][]model.Manga
-	chosenManga  map[model.ChatID]model.Manga
+// Conversation is a command made of more steps, like /add: every chat has its own session
+// with a state and the data T collected so far. The sessions are saved in the store as json,
+// so that the conversations continue after a restart: the fields of T must be exported.
+// It is safe for concurrent use, the updates of the same chat are handled one at a time
+type Conversation[T any] struct {
+	name    string
+	ttl     time.Duration
+	now     func() time.Time
+	store   repository.SessionRepo
+	mu      sync.Mutex // held while the sessions are read or written, never while a step runs
+	steps   map[ConversationState]Step[T]
+	gens    map[model.ChatID]uint64   // changed by Start and End, a step of an older session is not saved
+	running map[model.ChatID]*stepRun // the step in progress in the chat
 }
 
-func NewConversationStore() ConversationsStore {
-	return ConversationsStore{
-		addManga:     make(map[model.ChatID]AddMangaConversationState),
-		commandManga: make(map[model.ChatID]CommandManga),
-		mangas:       make(map[model.ChatID][]model.Manga),
-		chosenManga:  make(map[model.ChatID]model.Manga),
+// stepRun is a step in progress, done is closed when it returns
+type stepRun struct {
+	gen  uint64
+	done chan struct{}
+}
+
+// NewConversation returns a conversation without steps, the sessions not answered for ttl expire
+func NewConversation[T any](name string, ttl time.Duration, store repository.SessionRepo) *Conversation[T] {
+	return &Conversation[T]{
+		name:    name,
+		ttl:     ttl,
+		now:     time.Now,
+		store:   store,
+		steps:   make(map[ConversationState]Step[T]),
+		gens:    make(map[model.ChatID]uint64),
+		running: make(map[model.ChatID]*stepRun),
 	}
 }
 
-func (s ConversationsStore) InsertAddMangaState(chatID model.ChatID, state AddMangaConversationState) {
-	// i think for now its irrelevant to check if the chatID has already a state
-	s.addManga[chatID] = state
+// On registers the step handling the updates received in the state
+func (c *Conversation[T]) On(state ConversationState, step Step[T]) *Conversation[T] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.steps[state] = step
+	return c
 }
 
-func (s ConversationsStore) InsertCommandManga(chatID model.ChatID, command CommandManga) {
-	s.commandManga[chatID] = command
+// Start begins a new conversation in the chat, replacing the one in progress
+func (c *Conversation[T]) Start(chatID model.ChatID, state ConversationState, data T) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.save(chatID, state, data); err != nil {
+		return err
+	}
+	c.gens[chatID]++
+	logger.Log.Debugw("conversation started", "conversation", c.name, "chat_id", chatID, "state", state)
+	return nil
 }
 
-func (s ConversationsStore) InsertMangas(chatID model.ChatID, mangas []model.Manga) {
-	s.mangas[chatID] = mangas
+// End forgets the conversation of the chat. It does not wait for the step in progress,
+// which can not continue the conversation anymore
+func (c *Conversation[T]) End(chatID model.ChatID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gens[chatID]++
+	_ = c.store.DeleteSession(c.name, chatID)
 }
 
-func (s ConversationsStore) InsertChosenManga(chatID model.ChatID, manga model.Manga) {
-	s.chosenManga[chatID] = manga
+// State returns the state of the conversation of the chat, false when there is none
+func (c *Conversation[T]) State(chatID model.ChatID) (ConversationState, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	session := c.session(chatID)
+	if session == nil {
+		return EndConversation, false
+	}
+	return ConversationState(session.State), true
 }
 
-func (s ConversationsStore) GetAddMangaState(chatID model.ChatID) (AddMangaConversationState, error) {
-	state, ok := s.addManga[chatID]
+// Handle runs the step of the state the conversation of the chat is in.
+// It returns false when the chat has no conversation in progress or it expired.
+// The step runs without locks, as it can take long, like a download: when the conversation
+// is ended or started again in the meantime, the state returned by the step is dropped
+func (c *Conversation[T]) Handle(ctx context.Context, b *bot.Bot, update *models.Update) bool {
+	chatID, ok := updateChatID(update)
 	if !ok {
-		return 0, fmt.Errorf("addManga state not found for chatID %v", chatID)
+		return false
 	}
-	return state, nil
-}
+	session, run := c.begin(ctx, chatID)
+	if session == nil {
+		return false
+	}
+	defer c.finish(chatID, run)
 
-func (s ConversationsStore) GetCommandManga(chatID model.ChatID) (CommandManga, error) {
-	command, ok := s.commandManga[chatID]
+	state := ConversationState(session.State)
+	c.mu.Lock()
+	step, ok := c.steps[state]
+	c.mu.Unlock()
+	var data T
+	if ok {
+		if err := json.Unmarshal(session.Data, &data); err != nil {
+			// saved by an older version of the bot
+			logger.Log.Errorw("could not decode the session", "conversation", c.name, "chat_id", chatID, "err", err)
+			ok = false
+		}
+	} else {
+		logger.Log.Errorw("no step for the state of the conversation", "conversation", c.name, "state", state)
+	}
 	if !ok {
-		return "", fmt.Errorf("commandManga not found for chatID %v", chatID)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.gens[chatID] == run.gen {
+			_ = c.store.DeleteSession(c.name, chatID)
+		}
+		return false
+	}
+
+	next := step(ctx, b, update, chatID, &data)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gens[chatID] != run.gen {
+		logger.Log.Debugw("conversation replaced while the step ran", "conversation", c.name, "chat_id", chatID, "state", state)
+		return true
 	}
-	return command, nil
+	if next == EndConversation {
+		_ = c.store.DeleteSession(c.name, chatID)
+		return true
+	}
+	if err := c.save(chatID, next, data); err != nil {
+		return true
+	}
+	logger.Log.Debugw("conversation continues", "conversation", c.name, "chat_id", chatID, "state", next)
+	return true
 }
 
-func (s ConversationsStore) GetMangas(chatID model.ChatID) ([]model.Manga, error) {
-	mangas, ok := s.mangas[chatID]
-	if !ok {
-		return nil, fmt.Errorf("mangas not found for chatID %v", chatID)
+// Expire deletes the conversations without an answer for longer than the ttl
+func (c *Conversation[T]) Expire() int {
+	n, err := c.store.DeleteExpiredSessions(c.name, c.now())
+	if err != nil {
+		return 0
 	}
-	return mangas, nil
+	return int(n)
 }
 
-func (s ConversationsStore) GetChosenManga(chatID model.ChatID) (model.Manga, error) {
-	manga, ok := s.chosenManga[chatID]
-	if !ok {
-		return model.Manga{}, fmt.Errorf("chosenManga not found for chatID %v", chatID)
+// begin waits for the step in progress in the chat, then returns the session with the step
+// of the update registered as running. The steps of an older session are not waited for.
+// The session is nil when there is none or ctx is done while waiting
+func (c *Conversation[T]) begin(ctx context.Context, chatID model.ChatID) (*model.Session, *stepRun) {
+	c.mu.Lock()
+	for {
+		prev := c.running[chatID]
+		if prev == nil || prev.gen != c.gens[chatID] {
+			break
+		}
+		c.mu.Unlock()
+		select {
+		case <-prev.done:
+		case <-ctx.Done():
+			return nil, nil
+		}
+		c.mu.Lock()
+	}
+	defer c.mu.Unlock()
+
+	session := c.session(chatID)
+	if session == nil {
+		return nil, nil
+	}
+	run := &stepRun{gen: c.gens[chatID], done: make(chan struct{})}
+	c.running[chatID] = run
+	return session, run
+}
+
+// finish lets the next update of the chat be handled
+func (c *Conversation[T]) finish(chatID model.ChatID, run *stepRun) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running[chatID] == run {
+		delete(c.running, chatID)
+	}
+	close(run.done)
+}
+
+// session returns the session of the chat if not expired. c.mu must be held
+func (c *Conversation[T]) session(chatID model.ChatID) *model.Session {
+	session, err := c.store.FindSession(c.name, chatID)
+	if err != nil || session == nil {
+		return nil
+	}
+	if c.now().After(session.ExpiresAt) {
+		_ = c.store.DeleteSession(c.name, chatID)
+		return nil
+	}
+	return session
+}
+
+// save stores the session with the expiry moved forward. c.mu must be held
+func (c *Conversation[T]) save(chatID model.ChatID, state ConversationState, data T) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		logger.Log.Errorw("could not encode the session", "conversation", c.name, "chat_id", chatID, "err", err)
+		return err
 	}
-	return manga, nil
+	return c.store.SaveSession(model.Session{
+		Conversation: c.name,
+		ChatID:       chatID,
+		State:        string(state),
+		Data:         encoded,
+		ExpiresAt:    c.now().Add(c.ttl),
+	})
 }
 
-func (s ConversationsStore) Clean(chatID model.ChatID) {
-	delete(s.addManga, chatID)
-	delete(s.commandManga, chatID)
-	delete(s.mangas, chatID)
-	delete(s.chosenManga, chatID)
+// updateChatID is the chat of a message or of the message with the pressed button
+func updateChatID(update *models.Update) (model.ChatID, bool) {
+	switch {
+	case update.Message != nil:
+		return model.ChatID(update.Message.Chat.ID), true
+	case update.CallbackQuery != nil:
+		return callbackChatID(update.CallbackQuery), true
+	default:
+		return 0, false
+	}
 }
+
+// the states of /add and /remove
+const (
+	ChosenManga         ConversationState = "chosen_manga"
+	ChoseWhatToDo       ConversationState = "chose_what_to_do"
+	ChosenMangaToRemove ConversationState = "chosen_manga_to_remove"
+)
+
+type CommandManga string
+
+// the actions on the chosen manga, sent as callback data of the buttons
+const (
+	Download   CommandManga = "download"
+	ReadOnline CommandManga = "read"
+	DoNothing  CommandManga = "nothing"
+)
@@ -0,0 +1,263 @@
+package telegram
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/akarakai/gomanga-tbot/pkg/logger"
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+	"github.com/akarakai/gomanga-tbot/pkg/repository"
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+)
+
+const (
+	askedName ConversationState = "asked_name"
+	askedAge  ConversationState = "asked_age"
+)
+
+type signupSession struct {
+	Name string
+	Age  string
+}
+
+// newSessionRepo returns the sessions of a new database
+func newSessionRepo(t *testing.T) repository.SessionRepo {
+	t.Helper()
+	logger.Log = zap.NewNop().Sugar()
+	db, err := repository.NewSqlite3Database(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSqlite3Database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db.SessionRepo
+}
+
+// newSignupConversation asks the name and then the age, the collected data is sent when it ends
+func newSignupConversation(store repository.SessionRepo) *Conversation[signupSession] {
+	return NewConversation[signupSession]("signup", time.Minute, store).
+		On(askedName, func(ctx context.Context, b *bot.Bot, update *models.Update, chatID model.ChatID, data *signupSession) ConversationState {
+			data.Name = update.Message.Text
+			sendMessage(ctx, b, int64(chatID), "age?", nil)
+			return askedAge
+		}).
+		On(askedAge, func(ctx context.Context, b *bot.Bot, update *models.Update, chatID model.ChatID, data *signupSession) ConversationState {
+			data.Age = update.Message.Text
+			sendMessage(ctx, b, int64(chatID), data.Name+" "+data.Age, nil)
+			return EndConversation
+		})
+}
+
+func TestConversationSteps(t *testing.T) {
+	store := newSessionRepo(t)
+	b, api := newFakeBot(t)
+	ctx := context.Background()
+	conv := newSignupConversation(store)
+
+	if conv.Handle(ctx, b, textUpdate(1, "Guts")) {
+		t.Fatal("Expected no conversation before Start")
+	}
+
+	conv.Start(1, askedName, signupSession{})
+	conv.Start(2, askedName, signupSession{})
+	if !conv.Handle(ctx, b, textUpdate(1, "Guts")) {
+		t.Fatal("Expected the name to be handled")
+	}
+	if state, _ := conv.State(1); state != askedAge {
+		t.Errorf("Expected state %q, got %q", askedAge, state)
+	}
+	// the other chat has its own session
+	if state, _ := conv.State(2); state != askedName {
+		t.Errorf("Expected chat 2 in state %q, got %q", askedName, state)
+	}
+
+	conv.Handle(ctx, b, textUpdate(1, "25"))
+	msgs := api.messages(1)
+	if len(msgs) != 2 || msgs[1].Text() != "Guts 25" {
+		t.Fatalf("Unexpected messages %v", msgs)
+	}
+	if _, ok := conv.State(1); ok {
+		t.Error("Expected the conversation to be over")
+	}
+	if conv.Handle(ctx, b, textUpdate(1, "again")) {
+		t.Error("Expected no step after the end")
+	}
+}
+
+func TestConversationRestart(t *testing.T) {
+	store := newSessionRepo(t)
+	b, api := newFakeBot(t)
+	ctx := context.Background()
+
+	before := newSignupConversation(store)
+	before.Start(1, askedName, signupSession{})
+	before.Handle(ctx, b, textUpdate(1, "Guts"))
+
+	// the bot restarted, the session is read from the store
+	after := newSignupConversation(store)
+	if !after.Handle(ctx, b, textUpdate(1, "25")) {
+		t.Fatal("Expected the conversation to continue after the restart")
+	}
+	if msgs := api.messages(1); len(msgs) != 2 || msgs[1].Text() != "Guts 25" {
+		t.Errorf("Expected the data collected before the restart, got %v", msgs)
+	}
+}
+
+func TestConversationExpiry(t *testing.T) {
+	store := newSessionRepo(t)
+	b, api := newFakeBot(t)
+	ctx := context.Background()
+	conv := newSignupConversation(store)
+	now := time.Now()
+	conv.now = func() time.Time { return now }
+
+	conv.Start(1, askedName, signupSession{})
+	conv.Start(2, askedName, signupSession{})
+	now = now.Add(50 * time.Second)
+	conv.Handle(ctx, b, textUpdate(1, "Guts"))
+
+	// every answer extends the session of its chat only
+	now = now.Add(20 * time.Second)
+	if n := conv.Expire(); n != 1 {
+		t.Errorf("Expected 1 expired conversation, got %d", n)
+	}
+	if conv.Handle(ctx, b, textUpdate(2, "Casca")) {
+		t.Error("Expected the conversation of chat 2 to be expired")
+	}
+	if !conv.Handle(ctx, b, textUpdate(1, "25")) {
+		t.Error("Expected the conversation of chat 1 to continue")
+	}
+
+	// an expired session is not handled even before Expire runs
+	conv.Start(1, askedName, signupSession{})
+	now = now.Add(2 * time.Minute)
+	if conv.Handle(ctx, b, textUpdate(1, "Guts")) {
+		t.Error("Expected the conversation to be expired")
+	}
+	if msgs := api.messages(2); len(msgs) != 0 {
+		t.Errorf("Expected no message to chat 2, got %v", msgs)
+	}
+}
+
+func TestConversationUnknownState(t *testing.T) {
+	store := newSessionRepo(t)
+	b, _ := newFakeBot(t)
+	conv := newSignupConversation(store)
+
+	conv.Start(1, "missing", signupSession{})
+	if conv.Handle(context.Background(), b, textUpdate(1, "Guts")) {
+		t.Error("Expected a state without step not to be handled")
+	}
+	if _, ok := conv.State(1); ok {
+		t.Error("Expected the conversation to be ended")
+	}
+}
+
+func TestConversationConcurrent(t *testing.T) {
+	store := newSessionRepo(t)
+	b, _ := newFakeBot(t)
+	ctx := context.Background()
+
+	counted := 0
+	conv := NewConversation[int]("count", time.Minute, store).
+		On(askedName, func(ctx context.Context, b *bot.Bot, update *models.Update, chatID model.ChatID, data *int) ConversationState {
+			*data++
+			counted++ // the steps of the same chat never run together
+			return askedName
+		})
+	conv.Start(1, askedName, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conv.Handle(ctx, b, textUpdate(1, "x"))
+			conv.State(1)
+			conv.Expire()
+		}()
+	}
+	wg.Wait()
+	if counted != 50 {
+		t.Errorf("Expected 50 steps, got %d", counted)
+	}
+}
+
+func TestConversationSlowStep(t *testing.T) {
+	store := newSessionRepo(t)
+	b, _ := newFakeBot(t)
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	proceed := make(chan struct{})
+	conv := NewConversation[int]("slow", time.Minute, store).
+		On(askedName, func(ctx context.Context, b *bot.Bot, update *models.Update, chatID model.ChatID, data *int) ConversationState {
+			if chatID == 1 && *data == 0 {
+				close(started)
+				<-proceed // a download
+			}
+			return askedAge
+		})
+	conv.Start(1, askedName, 0)
+	conv.Start(65, askedName, 0)
+
+	handled := make(chan bool)
+	go func() { handled <- conv.Handle(ctx, b, textUpdate(1, "download")) }()
+	<-started
+
+	// neither the other chats nor /cancel wait for the step
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conv.Handle(ctx, b, textUpdate(65, "x"))
+		conv.End(1)
+		conv.Start(1, askedName, 1)
+		conv.Handle(ctx, b, textUpdate(1, "x"))
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the other updates not to wait for the step in progress")
+	}
+	if state, _ := conv.State(65); state != askedAge {
+		t.Errorf("Expected chat 65 in state %q, got %q", askedAge, state)
+	}
+
+	// the new conversation of the chat is not replaced by the step of the old one
+	conv.End(1)
+	close(proceed)
+	if !<-handled {
+		t.Error("Expected the slow step to be handled")
+	}
+	if _, ok := conv.State(1); ok {
+		t.Error("Expected the step of the ended conversation not to be saved")
+	}
+}
+
+func TestDefaultHandlerConversations(t *testing.T) {
+	store := newSessionRepo(t)
+	b, api := newFakeBot(t)
+	ctx := context.Background()
+	conv := newSignupConversation(store)
+	handler := newDefaultHandler([]conversationHandler{conv})
+
+	conv.Start(1, askedName, signupSession{})
+	handler(ctx, b, textUpdate(1, "Guts"))
+	if msgs := api.messages(1); len(msgs) != 1 || msgs[0].Text() != "age?" {
+		t.Errorf("Expected the text to reach the conversation, got %v", msgs)
+	}
+
+	handler(ctx, b, textUpdate(2, "hello"))
+	if msgs := api.messages(2); len(msgs) != 1 || msgs[0].Text() != "I did not understand. Use /help to see the commands" {
+		t.Errorf("Unexpected messages %v", msgs)
+	}
+
+	cancelHandler(ctx, b, textUpdate(1, "/cancel"), []conversationHandler{conv})
+	if _, ok := conv.State(1); ok {
+		t.Error("Expected /cancel to end the conversation")
+	}
+}
@@ -0,0 +1,168 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+	"github.com/go-telegram/bot"
+)
+
+// sentMessage is a request received by the fake bot api
+type sentMessage struct {
+	Method string
+	ChatID int64
+	Fields map[string]string
+}
+
+func (m sentMessage) Text() string {
+	if text, ok := m.Fields["text"]; ok {
+		return text
+	}
+	return m.Fields["caption"]
+}
+
+// fakeBotAPI answers every method of the bot api with success and remembers the requests
+type fakeBotAPI struct {
+	mu   sync.Mutex
+	sent []sentMessage
+}
+
+// newFakeBot returns a bot talking to a local fake of the telegram api
+func newFakeBot(t *testing.T) (*bot.Bot, *fakeBotAPI) {
+	t.Helper()
+	api := &fakeBotAPI{}
+	srv := httptest.NewServer(http.HandlerFunc(api.handle))
+	t.Cleanup(srv.Close)
+
+	b, err := bot.New("123:test", bot.WithServerURL(srv.URL), bot.WithSkipGetMe())
+	if err != nil {
+		t.Fatalf("bot.New: %v", err)
+	}
+	return b, api
+}
+
+func (api *fakeBotAPI) handle(w http.ResponseWriter, r *http.Request) {
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	fields := make(map[string]string)
+	if err := r.ParseMultipartForm(32 << 20); err == nil {
+		for k, v := range r.MultipartForm.Value {
+			fields[k] = v[0]
+		}
+		for k, files := range r.MultipartForm.File {
+			fields[k] = files[0].Filename
+		}
+	}
+	chatID, _ := strconv.ParseInt(fields["chat_id"], 10, 64)
+
+	api.mu.Lock()
+	api.sent = append(api.sent, sentMessage{Method: method, ChatID: chatID, Fields: fields})
+	id := len(api.sent)
+	api.mu.Unlock()
+
+	var result any = map[string]any{"message_id": id, "date": 0, "chat": map[string]any{"id": chatID, "type": "private"}}
+	if method == "answerCallbackQuery" || method == "answerInlineQuery" || method == "deleteMessage" {
+		result = true
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
+
+// messages returns the requests sent to the chat, in order
+func (api *fakeBotAPI) messages(chatID int64) []sentMessage {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	var msgs []sentMessage
+	for _, m := range api.sent {
+		if m.ChatID == chatID {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+// calls returns the requests of the method, in order
+func (api *fakeBotAPI) calls(method string) []sentMessage {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	var msgs []sentMessage
+	for _, m := range api.sent {
+		if m.Method == method {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+func (api *fakeBotAPI) reset() {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.sent = nil
+}
+
+// fakeScraper serves the chapters of the mangas from memory.
+// The most recent chapter is the first, as on the sites
+type fakeScraper struct {
+	mu       sync.Mutex
+	mangas   []model.Manga
+	chapters map[string][]model.Chapter
+	imgs     []string
+	err      error
+	calls    []int // nChaps of every FindListOfChapters
+}
+
+// release adds the chapters to the manga, the last one is the most recent
+func (f *fakeScraper) release(mangaURL string, chapters ...model.Chapter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.chapters == nil {
+		f.chapters = make(map[string][]model.Chapter)
+	}
+	for _, ch := range chapters {
+		f.chapters[mangaURL] = append([]model.Chapter{ch}, f.chapters[mangaURL]...)
+	}
+}
+
+func (f *fakeScraper) FindListOfMangas(ctx context.Context, query string) ([]model.Manga, error) {
+	// a new slice as the scrapers return, the handlers change the mangas
+	return slices.Clone(f.mangas), f.err
+}
+
+func (f *fakeScraper) FindMangaDetails(ctx context.Context, mangaURL string) (model.Manga, error) {
+	for _, m := range f.mangas {
+		if m.Url == mangaURL {
+			return m, f.err
+		}
+	}
+	return model.Manga{Url: mangaURL}, f.err
+}
+
+func (f *fakeScraper) FindListOfChapters(ctx context.Context, mangaURL string, nChaps int) ([]model.Chapter, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, nChaps)
+	if f.err != nil {
+		return nil, f.err
+	}
+	chs := f.chapters[mangaURL]
+	if nChaps >= 0 && nChaps < len(chs) {
+		chs = chs[:nChaps]
+	}
+	return append([]model.Chapter(nil), chs...), nil
+}
+
+func (f *fakeScraper) FindImgUrlsOfChapter(ctx context.Context, chapterURL string) ([]string, error) {
+	return f.imgs, f.err
+}
+
+func (f *fakeScraper) CurrentUrl() string { return "" }
+
+func (f *fakeScraper) CurrentPageTitle() (string, error) { return "", nil }
+
+func (f *fakeScraper) Close() {}
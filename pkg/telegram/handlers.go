@@ -1,10 +1,11 @@
 package telegram
 
 import (
-	bytes2 "bytes"
 	"context"
 	"fmt"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/akarakai/gomanga-tbot/pkg/downloader"
 	"github.com/akarakai/gomanga-tbot/pkg/logger"
@@ -15,9 +16,123 @@ import (
 	"github.com/go-telegram/bot/models"
 )
 
-func startHandler(ctx context.Context, b *bot.Bot, update *models.Update, userRepo repository.UserRepo) {
+func startHandler(ctx context.Context, b *bot.Bot, update *models.Update, db repository.Database,
+	conv *Conversation[addSession], scraper scraper.Scraper) {
+	// the link of a manga shared with the inline mode opens the chat with /start add_<key>
+	payload := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "/start"))
+	if key, ok := strings.CutPrefix(payload, deepLinkAdd); ok {
+		sharedMangaHandler(ctx, b, update, key, db, conv, scraper)
+		return
+	}
 	infoHandler(ctx, b, update)
-	registrationHandler(ctx, b, update, userRepo)
+	registrationHandler(ctx, b, update, db.GetUserRepo())
+}
+
+// sharedMangaHandler starts /add with the shared manga already chosen: the user is subscribed
+// and the conversation continues with the actions
+func sharedMangaHandler(ctx context.Context, b *bot.Bot, update *models.Update, key string, db repository.Database,
+	conv *Conversation[addSession], scraper scraper.Scraper) {
+	chatID := model.ChatID(update.Message.Chat.ID)
+	logger.Log.Infow("new add request from a shared manga", "chat_id", chatID, "key", key)
+
+	// the user may open the bot for the first time with the link
+	if err := db.GetUserRepo().SaveUser(chatID); err != nil {
+		sendMessage(ctx, b, int64(chatID), "There was a problem with the server, try again with /start", nil)
+		return
+	}
+	manga, err := db.GetShareRepo().FindShared(key)
+	if err != nil {
+		sendMessage(ctx, b, int64(chatID), "there was an error, search the manga with /add", nil)
+		return
+	}
+	if manga == nil {
+		sendMessage(ctx, b, int64(chatID), "This link is expired, search the manga with /add", nil)
+		return
+	}
+
+	chosen, ok := subscribeManga(ctx, b, chatID, *manga, db, scraper)
+	if !ok {
+		return
+	}
+	session := addSession{Chosen: chosen, Nonce: newNonce()}
+	if err := conv.Start(chatID, ChoseWhatToDo, session); err != nil {
+		return
+	}
+	sendMessage(ctx, b, int64(chatID), "Please choose an action:", createActionKeyboard(session.Nonce))
+}
+
+// inline mode handler
+// @gomangabot one piece answers with the mangas found, which can be sent in any chat.
+// The message has a button for subscribing, which opens the chat with the bot. Without the username
+// of the bot, which is read when the bot starts, the button is missing
+func inlineQueryHandler(ctx context.Context, b *bot.Bot, update *models.Update, shareRepo repository.ShareRepo,
+	scraper scraper.Scraper, username string) {
+	query := update.InlineQuery
+	text := strings.TrimSpace(query.Query)
+	if text == "" {
+		answerInlineQuery(ctx, b, query, nil)
+		return
+	}
+	logger.Log.Infow("new inline query", "user_id", query.From.ID, "query", text)
+
+	searchCtx, cancel := context.WithTimeout(ctx, inlineTimeout)
+	defer cancel()
+	mangas, err := scraper.FindListOfMangas(searchCtx, text)
+	if err != nil {
+		logger.Log.Errorw("error searching the mangas of the inline query", "err", err)
+		answerInlineQuery(ctx, b, query, nil)
+		return
+	}
+	mangas = mangas[:min(len(mangas), maxInlineResults)]
+	inlineDetails(searchCtx, scraper, mangas)
+
+	results := make([]models.InlineQueryResult, 0, len(mangas))
+	for _, manga := range mangas {
+		key := shareKey(manga.Url)
+		if err := shareRepo.SaveShared(key, manga); err != nil {
+			continue
+		}
+		results = append(results, inlineArticle(manga, key, username))
+	}
+	answerInlineQuery(ctx, b, query, results)
+}
+
+// inlineDetails scrapes the cover and the last chapter of the mangas found. The inline answers must
+// be quick, the mangas are scraped together but with few calls for all the users, the others are
+// left to the updater and the commands. The mangas without them are shown anyway
+func inlineDetails(ctx context.Context, scraper scraper.Scraper, mangas []model.Manga) {
+	var wg sync.WaitGroup
+	for i := range mangas {
+		if cached, ok := inlineMangas.get(mangas[i].Url); ok {
+			mangas[i] = cached
+			continue
+		}
+		wg.Add(1)
+		go func(manga *model.Manga) {
+			defer wg.Done()
+			if !inlineMangas.acquire(ctx) {
+				return
+			}
+			defer inlineMangas.release()
+
+			details, err := scraper.FindMangaDetails(ctx, manga.Url)
+			if err == nil {
+				mergeDetails(manga, details)
+			} else {
+				logger.Log.Warnw("could not find the details of the manga", "err", err, "manga_title", manga.Title)
+			}
+			chs, chErr := scraper.FindListOfChapters(ctx, manga.Url, 1)
+			if chErr == nil && len(chs) > 0 {
+				manga.LastChapter = &chs[0]
+			} else {
+				logger.Log.Warnw("could not find the last chapter of the manga", "err", chErr, "manga_title", manga.Title)
+			}
+			if err == nil && manga.LastChapter != nil {
+				inlineMangas.put(*manga)
+			}
+		}(&mangas[i])
+	}
+	wg.Wait()
 }
 
 func registrationHandler(ctx context.Context, b *bot.Bot, update *models.Update, userRepo repository.UserRepo) {
@@ -53,21 +168,29 @@ Try again by inserting the command /start again.
 
 func infoHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
 	const welcomeMsg = `Welcome to gomanga-tbot!
-Here you can keep track of your favourite mangas published in WeebCentral.
-You can also download the latest chapter or read directly on WeebCentral.
-Subscribe to a manga, and as soon as it's ready on WeebCentral you will be notified via this bot.
+Here you can keep track of your favourite mangas published in WeebCentral and MangaDex.
+You can also download the latest chapter or read it directly on the site.
+Subscribe to a manga, and as soon as it's ready on the site you will be notified via this bot.
 
 Commands:
 /info - Show this help message
 /register - Register yourself to get updates. Normally you are automatically registered when you entered the chat (only your chat_id is saved in the server). Call this command if you have problems.
 /add <manga name> - Add a manga to your subscription list
+/remove - Remove a manga from your subscription list
+/download <manga name> [chapter] - Download the latest chapter, a chapter (100) or a range of chapters (100-105)
+/format [pdf|cbz|epub] - Show or change the format of the downloaded chapters. CBZ is for comic readers like Mihon, Komga or Kavita, EPUB for e-readers like Kobo or Kindle
+/profile [name] - Show or change how the pages are optimized for your device, like kobo-libra or kindle-paperwhite
+/webtoon [on|off] - Slice the long strips of the webtoons in pages of the size of a screen
 /list - List all mangas available from the subscription list
 /cancel - Use this if you have problems
+
+In any chat, write @ with the name of this bot followed by a manga name to search it and share it with your friends
 `
 	sendMessage(ctx, b, update.Message.Chat.ID, welcomeMsg, nil)
 }
 
 // /list handler
+// the subscriptions are shown in pages, changed with the buttons handled by listPageCallback
 func mangaListHandler(ctx context.Context, b *bot.Bot, update *models.Update, mangaRepo repository.MangaRepo) {
 	chatID := model.ChatID(update.Message.Chat.ID)
 	mangas, err := mangaRepo.FindMangasOfUser(chatID)
@@ -76,18 +199,117 @@ func mangaListHandler(ctx context.Context, b *bot.Bot, update *models.Update, ma
 		sendMessage(ctx, b, int64(update.Message.Chat.ID), "there was an error, could not find the list of mangas", nil)
 		return
 	}
+	if len(mangas) == 0 {
+		sendMessage(ctx, b, int64(chatID), "You are not subscribed to any manga. Use /add to subscribe", nil)
+		return
+	}
 	model.SortMangaByRecentChapter(mangas)
-	msgList := make([]string, 0, len(mangas))
-	for i, m := range mangas {
+	sendMessage(ctx, b, update.Message.Chat.ID, mangaListPage(mangas, 0), listKeyboard(len(mangas), 0))
+	logger.Log.Infow("manga list sent to user", "chat_id", chatID)
+}
+
+// listPageCallback shows another page of /list. The list is read again, it could be changed meanwhile
+func listPageCallback(ctx context.Context, b *bot.Bot, update *models.Update, mangaRepo repository.MangaRepo) {
+	query := update.CallbackQuery
+	chatID := callbackChatID(query)
+	mangas, err := mangaRepo.FindMangasOfUser(chatID)
+	if err != nil {
+		logger.Log.Errorw("error when finding mangas", "err", err)
+		answerCallback(ctx, b, query, "there was an error, could not find the list of mangas")
+		return
+	}
+	page, ok := callbackPageNumber(query.Data, callbackList, pageCount(len(mangas)))
+	if !ok || len(mangas) == 0 {
+		expiredCallback(ctx, b, query, "This list is expired, use /list again")
+		return
+	}
+	model.SortMangaByRecentChapter(mangas)
+	answerCallback(ctx, b, query, "")
+	keyboard, _ := listKeyboard(len(mangas), page).(*models.InlineKeyboardMarkup)
+	editCallbackMessage(ctx, b, query, mangaListPage(mangas, page), keyboard)
+}
+
+// mangaListPage is the text of a page of /list, the mangas are numbered from the first page
+func mangaListPage(mangas []model.Manga, page int) string {
+	from, to := pageBounds(len(mangas), page)
+	msgList := make([]string, 0, to-from)
+	for i := from; i < to; i++ {
+		m := mangas[i]
 		row := fmt.Sprintf("%d. %s.\nLast chapter on: %s", i+1, m.Title, formatReleaseDate(m.LastChapter.ReleasedAt))
 		msgList = append(msgList, row)
 	}
-	msg := strings.Join(msgList, "\n\n")
-	sendMessage(ctx, b, update.Message.Chat.ID, msg, nil)
-	logger.Log.Infow("manga list sent to user", "chat_id", chatID)
+	return strings.Join(msgList, "\n\n")
+}
+
+// listKeyboard has only the buttons for changing page, nil when the list fits in one page.
+// The nil is untyped, a nil pointer in the interface would be sent to telegram as null
+func listKeyboard(n int, page int) models.ReplyMarkup {
+	row := pageButtons(callbackList, page, pageCount(n))
+	if row == nil {
+		return nil
+	}
+	return &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{row}}
+}
+
+// addSession is the data collected by /add
+type addSession struct {
+	Results []model.Manga // the search results, the buttons refer to them by index
+	Chosen  model.Manga   // the manga the user subscribed to
+	Nonce   string        // in the callback data of the buttons, see newNonce
+}
+
+// removeSession is the data collected by /remove
+type removeSession struct {
+	Mangas []model.Manga // the subscriptions, the buttons refer to them by index
+	Nonce  string        // in the callback data of the buttons, see newNonce
 }
 
-func addHandler(ctx context.Context, b *bot.Bot, update *models.Update, db repository.Database, scraper scraper.Scraper) {
+// newAddConversation returns the steps of /add, started by addHandler
+func newAddConversation(db repository.Database, scraper scraper.Scraper) *Conversation[addSession] {
+	return NewConversation[addSession]("add", conversationTTL, db.GetSessionRepo()).
+		On(ChosenManga, func(ctx context.Context, b *bot.Bot, update *models.Update, chatID model.ChatID, data *addSession) ConversationState {
+			return mangaChosenStep(ctx, b, update, chatID, data, db, scraper)
+		}).
+		On(ChoseWhatToDo, func(ctx context.Context, b *bot.Bot, update *models.Update, chatID model.ChatID, data *addSession) ConversationState {
+			return actionStep(ctx, b, update, chatID, data, db.GetUserRepo(), scraper)
+		})
+}
+
+// newRemoveConversation returns the steps of /remove, started by removeHandler
+func newRemoveConversation(db repository.Database) *Conversation[removeSession] {
+	return NewConversation[removeSession]("remove", conversationTTL, db.GetSessionRepo()).
+		On(ChosenMangaToRemove, func(ctx context.Context, b *bot.Bot, update *models.Update, chatID model.ChatID, data *removeSession) ConversationState {
+			return removeStep(ctx, b, update, chatID, data, db)
+		})
+}
+
+// conversationCallback passes the pressed buttons to the conversation.
+// The buttons of a conversation which is over or expired only get the expired message
+func conversationCallback[T any](conv *Conversation[T], expired string) bot.HandlerFunc {
+	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		if update.CallbackQuery == nil || conv.Handle(ctx, b, update) {
+			return
+		}
+		query := update.CallbackQuery
+		logger.Log.Debugw("button of no conversation pressed", "chat_id", callbackChatID(query), "data", query.Data)
+		expiredCallback(ctx, b, query, expired)
+	}
+}
+
+// expiredCallback tells the user that the pressed button is old, and removes the buttons
+func expiredCallback(ctx context.Context, b *bot.Bot, query *models.CallbackQuery, text string) {
+	answerCallback(ctx, b, query, text)
+	editCallbackMessage(ctx, b, query, text, nil)
+}
+
+const (
+	addExpired    = "This list is expired, search again with /add"
+	actionExpired = "This manga is expired, try again with /add"
+	removeExpired = "This list is expired, try again with /remove"
+)
+
+// first step for /add, the mangas found are sent as buttons
+func addHandler(ctx context.Context, b *bot.Bot, update *models.Update, conv *Conversation[addSession], scraper scraper.Scraper) {
 	const cmd = "/add"
 	if update.Message == nil {
 		logger.Log.Error("Update message is nil")
@@ -97,8 +319,6 @@ func addHandler(ctx context.Context, b *bot.Bot, update *models.Update, db repos
 		logger.Log.Error("Message.From is nil")
 		return
 	}
-	_ = db.GetMangaRepo()
-	_ = db.GetUserRepo()
 
 	userId := update.Message.From.ID
 	logger.Log.Infow("new add request", "userId", userId)
@@ -110,242 +330,516 @@ func addHandler(ctx context.Context, b *bot.Bot, update *models.Update, db repos
 		return
 	}
 
-	mangas, err := scraper.FindListOfMangas(msg)
+	scrapeCtx, cancel := context.WithTimeout(ctx, scraperTimeout)
+	defer cancel()
+	mangas, err := scraper.FindListOfMangas(scrapeCtx, msg)
 	if err != nil {
-		logger.Log.Errorw("error creating scraper", "err", err)
-		sendMessage(ctx, b, update.Message.Chat.ID, "there are some problems with the bot, try again", nil)
+		logger.Log.Errorw("error searching the mangas", "err", err)
+		sendMessage(ctx, b, update.Message.Chat.ID, scraperErrorMessage(err), nil)
+		return
+	}
+	if len(mangas) == 0 {
+		sendMessage(ctx, b, update.Message.Chat.ID, fmt.Sprintf("No manga found with the name %s", msg), nil)
 		return
 	}
 
-	chatId := model.ChatID(update.Message.Chat.ID)
-	convStore.InsertMangas(chatId, mangas)
-
-	// send manga titles as buttons, in the same order of the slices
-	keyboard := createMangaKeyboard(mangas)
-	sendMessage(ctx, b, update.Message.Chat.ID, fmt.Sprintf("You have chosen: %s", msg), &models.ReplyKeyboardMarkup{
-		Keyboard:        keyboard,
-		ResizeKeyboard:  true,
-		OneTimeKeyboard: true,
-	})
+	session := addSession{Results: mangas, Nonce: newNonce()}
+	if err := conv.Start(model.ChatID(update.Message.Chat.ID), ChosenManga, session); err != nil {
+		sendMessage(ctx, b, update.Message.Chat.ID, "there was an error, try again with /add", nil)
+		return
+	}
+
+	// send manga titles as buttons, the callback data is the index in the slice
+	sendMessage(ctx, b, update.Message.Chat.ID, fmt.Sprintf("You have searched: %s. Choose the manga", msg),
+		createMangaKeyboard(mangas, callbackPrefix(callbackAdd, session.Nonce), 0))
 
 	logger.Log.Infow("Add message sent successfully", "chatId", update.Message.Chat.ID)
-	convStore.InsertAddMangaState(chatId, ChosenManga)
 }
 
-// for now it supports only /add
-// maybe a more complex arch is needed for supporting conversations
-// which start with different commands
-func conversationHandler(ctx context.Context, b *bot.Bot, update *models.Update, db repository.Database, scraper scraper.Scraper) {
-	logger.Log.Debugln("starting a conversation")
-	// get the state from the map
-	chatId := model.ChatID(update.Message.Chat.ID)
-	state, err := convStore.GetAddMangaState(chatId)
-	// handle no chatId saved
-	if err != nil {
-		logger.Log.Errorln("userId not found in the conversation map")
-		return
+// second step for /add, the user pressed the button of a manga
+// the user is subscribed to the manga and the message with the actions (download, read online, nothing) is sent
+func mangaChosenStep(ctx context.Context, b *bot.Bot, update *models.Update, chatID model.ChatID, data *addSession,
+	db repository.Database, scraper scraper.Scraper) ConversationState {
+	query := update.CallbackQuery
+	if query == nil {
+		sendMessage(ctx, b, int64(chatID), "Choose the manga with the buttons, or use /cancel", nil)
+		return ChosenManga
 	}
 
-	switch state {
-	case ChosenManga:
-		mangaChosenStep(ctx, b, update, db, scraper)
-	case ChoseWhatToDo:
-		actionOnMangaStep(ctx, b, update)
-	default:
-		panic("unhandled default case")
+	prefix := callbackPrefix(callbackAdd, data.Nonce)
+	if page, ok := callbackPageNumber(query.Data, prefix, pageCount(len(data.Results))); ok {
+		answerCallback(ctx, b, query, "")
+		editCallbackKeyboard(ctx, b, query, createMangaKeyboard(data.Results, prefix, page))
+		return ChosenManga
 	}
-}
-
-// second step for /add
-// manage the chosen manga from the list
-// replies the user with list of actions (download, read online, nothing)
-func mangaChosenStep(ctx context.Context, b *bot.Bot, update *models.Update, db repository.Database, scraper scraper.Scraper) {
-	mangaRepo := db.GetMangaRepo()
-	userRepo := db.GetUserRepo()
 
 	logger.Log.Debugf("conversation continues.. Manga was chosen. Now its time for the action")
-	chatID := model.ChatID(update.Message.Chat.ID)
-	chosenManga := update.Message.Text // sanitation not required
-	// get the manga chosen
-	mangas, err := convStore.GetMangas(chatID)
+	idx, err := callbackIndex(query.Data, prefix, len(data.Results))
 	if err != nil {
-		logger.Log.Errorf("could not find the manga in the cache")
-		sendMessage(ctx, b, int64(chatID), "Could not find the manga in cache", nil)
-		convStore.Clean(chatID)
-		return
+		// a button of an older message, the conversation keeps waiting for the current one
+		logger.Log.Debugw("old or invalid button pressed", "chat_id", chatID, "data", query.Data)
+		expiredCallback(ctx, b, query, addExpired)
+		return ChosenManga
 	}
-
 	// this manga does not have the last chapter
-	var manga model.Manga
-	for _, mangaa := range mangas {
-		if mangaa.Title == chosenManga {
-			logger.Log.Debugw("manga found", "manga", chosenManga)
-			manga = mangaa
-			break
-		}
-	}
-	if manga == (model.Manga{}) {
-		logger.Log.Errorf("manga was not present in the cache")
-		sendMessage(ctx, b, int64(chatID), "Manga not found in cache", nil)
-		convStore.Clean(chatID)
-		return
+	manga := data.Results[idx]
+	answerCallback(ctx, b, query, "")
+	editCallbackMessage(ctx, b, query, fmt.Sprintf("You have chosen: %s", mangaButtonText(manga)), nil)
+
+	chosen, ok := subscribeManga(ctx, b, chatID, manga, db, scraper)
+	if !ok {
+		return EndConversation
 	}
+	data.Chosen = chosen
+	sendMessage(ctx, b, int64(chatID), "Please choose an action:", createActionKeyboard(data.Nonce))
+	return ChoseWhatToDo
+}
 
-	// find if the manga exists in the database
-	mangaDb, err := mangaRepo.FindMangaByUrl(manga.Url)
+// subscribeManga subscribes the user to the manga chosen with /add or with the link of a shared manga.
+// The manga is scraped again, the one with the last chapter and the details is returned.
+// It returns false when the user could not be subscribed, the reason was sent to the user
+func subscribeManga(ctx context.Context, b *bot.Bot, chatID model.ChatID, manga model.Manga,
+	db repository.Database, scraper scraper.Scraper) (model.Manga, bool) {
+	mangaRepo := db.GetMangaRepo()
+	userRepo := db.GetUserRepo()
+
+	// check if the user is already subscribed
+	userMangas, err := mangaRepo.FindMangasOfUser(chatID)
 	if err != nil {
 		logger.Log.Errorw("err", err)
-	} // manga is present in the database
-	if mangaDb != nil {
-		// check if the user is subscribed
-		userMangas, err := mangaRepo.FindMangasOfUser(chatID)
-		if err != nil {
-			logger.Log.Errorw("err", err)
-
-		}
-		for _, userManga := range userMangas {
-			if *mangaDb == userManga {
-				logger.Log.Infow("user already subscribed manga", "chat_id", chatID, "manga_title", userManga.Title)
-				sendMessage(ctx, b, int64(chatID), "You are already subscribed on this manga", nil)
-				convStore.Clean(chatID)
-				return
-			}
-		}
-		// user is not subscribed. Subscribe now
-		if err := userRepo.SaveManga(chatID, mangaDb.Url); err != nil {
-			logger.Log.Errorw("could not save the manga in user repo", "err", err)
-			sendMessage(ctx, b, int64(chatID), "Could not save the manga", nil)
-			convStore.Clean(chatID)
-			return
+	}
+	for _, userManga := range userMangas {
+		if manga.Url == userManga.Url {
+			logger.Log.Infow("user already subscribed manga", "chat_id", chatID, "manga_title", userManga.Title)
+			sendMessage(ctx, b, int64(chatID), "You are already subscribed on this manga", nil)
+			return manga, false
 		}
 	}
 
-	// manga doesn't exist in the database, we have to scrape it and then save in the user repository
-	chs, err := scraper.FindListOfChapters(manga.Url, 1)
-	if err != nil {
+	// the manga is scraped again even when saved by another user, so that the last chapter is up to date
+	scrapeCtx, cancel := context.WithTimeout(ctx, scraperTimeout)
+	defer cancel()
+	chs, err := scraper.FindListOfChapters(scrapeCtx, manga.Url, 1)
+	if err != nil || len(chs) == 0 {
 		logger.Log.Errorw("could not find the chapters of the manga", "err", err, "manga_title", manga.Title)
-		sendMessage(ctx, b, int64(chatID), "Could not find the chapters of the manga", nil)
-		convStore.Clean(chatID)
-		return
+		sendMessage(ctx, b, int64(chatID), "Could not find the chapters of the manga. "+scraperErrorMessage(err), nil)
+		return manga, false
 	}
 	ch := chs[0]
 	manga.LastChapter = &ch
+
+	// the metadata is not required for following the manga, the manga is saved without it
+	details, err := scraper.FindMangaDetails(scrapeCtx, manga.Url)
+	if err != nil {
+		logger.Log.Warnw("could not find the details of the manga", "err", err, "manga_title", manga.Title)
+	} else {
+		mergeDetails(&manga, details)
+	}
+
 	if err := mangaRepo.SaveManga(&manga); err != nil {
 		logger.Log.Errorw("could not save the manga in the database", "err", err)
 		sendMessage(ctx, b, int64(chatID), "Could not save the manga", nil)
-		convStore.Clean(chatID)
-		return
+		return manga, false
 	}
 	if err := userRepo.SaveManga(chatID, manga.Url); err != nil {
 		logger.Log.Errorw("could not save the manga in user repo", "err", err)
 		sendMessage(ctx, b, int64(chatID), "Could not save the manga", nil)
-		convStore.Clean(chatID)
-		return
+		return manga, false
 	}
 
-	releaseDate := formatReleaseDate(manga.LastChapter.ReleasedAt)
-	mangaInfoStr := fmt.Sprintf("📚 **%s**\n📖 Latest Chapter: %s\n📅 Released: %s\n\nWhat would you like to do?",
-		manga.Title, manga.LastChapter.Title, releaseDate)
-	sendMessage(ctx, b, int64(chatID), mangaInfoStr, nil)
-
-	convStore.InsertChosenManga(chatID, manga)
-	convStore.InsertAddMangaState(chatID, ChoseWhatToDo)
-
-	keyboard := createActionKeyboard()
-	sendMessage(ctx, b, update.Message.Chat.ID, "Please choose an action:", &models.ReplyKeyboardMarkup{
-		Keyboard:        keyboard,
-		ResizeKeyboard:  true,
-		OneTimeKeyboard: true,
-	})
+	sendPhoto(ctx, b, int64(chatID), manga.CoverUrl, mangaInfoText(manga))
+	return manga, true
 }
 
 // final step for /add
 // user chooses what to do with the last manga
-func actionOnMangaStep(ctx context.Context, b *bot.Bot, update *models.Update) {
+func actionStep(ctx context.Context, b *bot.Bot, update *models.Update, chatID model.ChatID, data *addSession,
+	userRepo repository.UserRepo, scraper scraper.Scraper) ConversationState {
 	logger.Log.Debugf("conversation continues.. Action was chosen")
-	chatID := model.ChatID(update.Message.Chat.ID)
-	defer convStore.Clean(chatID)
-	choice := CommandManga(update.Message.Text)
+	query := update.CallbackQuery
+	if query == nil {
+		sendMessage(ctx, b, int64(chatID), "Choose the action with the buttons, or use /cancel", nil)
+		return ChoseWhatToDo
+	}
+	command, ok := strings.CutPrefix(query.Data, callbackPrefix(callbackAction, data.Nonce))
+	if !ok {
+		logger.Log.Debugw("old or invalid button pressed", "chat_id", chatID, "data", query.Data)
+		expiredCallback(ctx, b, query, addExpired)
+		return ChoseWhatToDo
+	}
+	choice := CommandManga(command)
 	logger.Log.Debugf("user chose: %s", choice)
+	manga := data.Chosen
+	answerCallback(ctx, b, query, "")
+
+	switch choice {
+	case Download:
+		logger.Log.Infow("user decided to download manga", "manga", manga)
+		editCallbackMessage(ctx, b, query, fmt.Sprintf("Downloading %s...", manga.LastChapter.Title), nil)
+		if err := sendChapter(ctx, b, int64(chatID), scraper, manga, *manga.LastChapter, userSettings(userRepo, chatID)); err != nil {
+			logger.Log.Errorw("error when sending the chapter", "err", err)
+			editCallbackMessage(ctx, b, query,
+				"there was a problem when downloading the chapter. "+scraperErrorMessage(err), nil)
+		}
+
+	case ReadOnline:
+		logger.Log.Infow("user decided to read the manga online", "manga", manga)
+		editCallbackMessage(ctx, b, query, manga.LastChapter.Url, nil)
+		sendMessage(ctx, b, int64(chatID),
+			fmt.Sprintf("You will get a message when the last chapter of %s is released on %s", manga.Title, manga.Source), nil)
+	case DoNothing:
+		logger.Log.Infow("user decided to do nothing", "manga", manga)
+		editCallbackMessage(ctx, b, query,
+			fmt.Sprintf("You will get a message when the last chapter of %s is released on %s", manga.Title, manga.Source), nil)
+	default:
+		editCallbackMessage(ctx, b, query, "Invalid choice. Please try again with /add command.", nil)
+	}
+	return EndConversation
+}
 
-	manga, err := convStore.GetChosenManga(chatID)
+// /remove handler
+// shows the mangas of the user as buttons, the chosen one is removed in removeStep
+func removeHandler(ctx context.Context, b *bot.Bot, update *models.Update, conv *Conversation[removeSession], mangaRepo repository.MangaRepo) {
+	chatID := model.ChatID(update.Message.Chat.ID)
+	mangas, err := mangaRepo.FindMangasOfUser(chatID)
 	if err != nil {
-		logger.Log.Errorf("manga not found")
-		sendMessage(ctx, b, update.Message.Chat.ID, "Manga not found in cache", nil)
+		logger.Log.Errorw("error when finding mangas", "err", err)
+		sendMessage(ctx, b, int64(chatID), "there was an error, could not find the list of mangas", nil)
+		return
+	}
+	if len(mangas) == 0 {
+		conv.End(chatID)
+		sendMessage(ctx, b, int64(chatID), "You are not subscribed to any manga. Use /add to subscribe", nil)
 		return
 	}
 
-	switch choice {
-	case Download:
-		logger.Log.Infow("user decided to download manga", "manga", manga)
-		s, err := scraper.NewWeebCentralScraperDefault()
-		if err != nil {
-			logger.Log.Errorw("error when creating a scraper", "err", err)
-			removeKeyboardFromUser(ctx, b, update.Message.Chat.ID,
-				"there was a problem when downloading the chapter, try later")
-			break
+	session := removeSession{Mangas: mangas, Nonce: newNonce()}
+	if err := conv.Start(chatID, ChosenMangaToRemove, session); err != nil {
+		sendMessage(ctx, b, int64(chatID), "there was an error, try again with /remove", nil)
+		return
+	}
+	sendMessage(ctx, b, int64(chatID), "Choose the manga you want to unsubscribe from",
+		createMangaKeyboard(mangas, callbackPrefix(callbackRemove, session.Nonce), 0))
+}
+
+// second and last step of /remove
+// only the subscription of the user is deleted. Mangas without subscribers are deleted too,
+// so that the updater does not scrape them anymore
+func removeStep(ctx context.Context, b *bot.Bot, update *models.Update, chatID model.ChatID, data *removeSession,
+	db repository.Database) ConversationState {
+	query := update.CallbackQuery
+	if query == nil {
+		sendMessage(ctx, b, int64(chatID), "Choose the manga with the buttons, or use /cancel", nil)
+		return ChosenMangaToRemove
+	}
+	prefix := callbackPrefix(callbackRemove, data.Nonce)
+	if page, ok := callbackPageNumber(query.Data, prefix, pageCount(len(data.Mangas))); ok {
+		answerCallback(ctx, b, query, "")
+		editCallbackKeyboard(ctx, b, query, createMangaKeyboard(data.Mangas, prefix, page))
+		return ChosenMangaToRemove
+	}
+	idx, err := callbackIndex(query.Data, prefix, len(data.Mangas))
+	if err != nil {
+		logger.Log.Debugw("old or invalid button pressed", "chat_id", chatID, "data", query.Data)
+		expiredCallback(ctx, b, query, removeExpired)
+		return ChosenMangaToRemove
+	}
+	manga := data.Mangas[idx]
+
+	if err := db.GetUserRepo().RemoveManga(chatID, manga.Url); err != nil {
+		logger.Log.Errorw("could not remove the manga of the user", "chat_id", chatID, "manga", manga.Title, "err", err)
+		answerCallback(ctx, b, query, "Could not remove the manga, try again")
+		editCallbackMessage(ctx, b, query, "Could not remove the manga, try again with /remove", nil)
+		return EndConversation
+	}
+	if _, err := db.GetMangaRepo().DeleteUnfollowedMangas(); err != nil {
+		logger.Log.Errorw("could not delete the unfollowed mangas", "err", err)
+	}
+
+	logger.Log.Infow("user unsubscribed from manga", "chat_id", chatID, "manga", manga.Title)
+	answerCallback(ctx, b, query, "")
+	editCallbackMessage(ctx, b, query, fmt.Sprintf("You will not receive the updates of %s anymore", manga.Title), nil)
+	return EndConversation
+}
+
+// conversationHandler is what the bot needs to know of a Conversation, whatever its data
+type conversationHandler interface {
+	Handle(ctx context.Context, b *bot.Bot, update *models.Update) bool
+	End(chatID model.ChatID)
+	Expire() int
+}
+
+// newDefaultHandler answers the messages which are not a command.
+// They are passed to the conversations first, which can wait for a text
+func newDefaultHandler(conversations []conversationHandler) bot.HandlerFunc {
+	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		for _, conv := range conversations {
+			if conv.Handle(ctx, b, update) {
+				return
+			}
 		}
-		defer s.Close()
+		if update.Message == nil || update.Message.Text == "" {
+			return
+		}
+		sendMessage(ctx, b, update.Message.Chat.ID, "I did not understand. Use /help to see the commands", nil)
+	}
+}
 
-		imgUrls, err := s.FindImgUrlsOfChapter(manga.LastChapter.Url)
+// max chapters sent for a single /download
+const maxChaptersPerDownload = 20
+
+// /download handler
+// /download Berserk => latest chapter, /download Berserk 100 or /download Berserk 100-105
+// The manga is searched first in the subscriptions of the user, then on the sites
+func downloadHandler(ctx context.Context, b *bot.Bot, update *models.Update, db repository.Database, scraper scraper.Scraper) {
+	const cmd = "/download"
+	const usage = "to download a chapter, use /download 'manga name' 'chapter', like /download Berserk 100 or /download Berserk 100-105. " +
+		"Without the chapter, the latest one is downloaded"
+	chatID := update.Message.Chat.ID
+
+	args, err := parseMessage(cmd, update.Message.Text)
+	if err != nil || args == "" {
+		sendMessage(ctx, b, chatID, usage, nil)
+		return
+	}
+
+	// titles can end with a number, like "Kaiju No. 8". The whole text is tried as a title first
+	manga := findSubscribedManga(db.GetMangaRepo(), model.ChatID(chatID), args)
+	var chRange *chapterRange
+	if manga == nil {
+		var name string
+		name, chRange, err = parseDownloadArgs(args)
 		if err != nil {
-			logger.Log.Errorw("error when getting chapter imgUrls", "err", err)
-			removeKeyboardFromUser(ctx, b, update.Message.Chat.ID,
-				"there was a problem when downloading the chapter, try later")
-			break
+			logger.Log.Debugw("error in message of user", "err", err)
+			sendMessage(ctx, b, chatID, usage, nil)
+			return
 		}
-		docTitle := fmt.Sprintf("%s-%s", manga.Title, manga.LastChapter.Title)
-		bytes, err := downloader.DownloadPdfFromImageSrcs(imgUrls, docTitle)
-		if err != nil {
-			logger.Log.Errorw("error when constructing the pdf", "err", err)
-			removeKeyboardFromUser(ctx, b, update.Message.Chat.ID,
-				"there was a problem when downloading the chapter, try later")
-			break
+		manga = findSubscribedManga(db.GetMangaRepo(), model.ChatID(chatID), name)
+		if manga == nil {
+			scrapeCtx, cancel := context.WithTimeout(ctx, scraperTimeout)
+			mangas, err := scraper.FindListOfMangas(scrapeCtx, name)
+			cancel()
+			if err != nil {
+				logger.Log.Errorw("error searching the mangas", "err", err)
+				sendMessage(ctx, b, chatID, scraperErrorMessage(err), nil)
+				return
+			}
+			if len(mangas) == 0 {
+				sendMessage(ctx, b, chatID, fmt.Sprintf("No manga found with the name %s", name), nil)
+				return
+			}
+			manga = &mangas[0]
 		}
-		logger.Log.Infow("pdf downloaded", "title", docTitle, "sizeBytes", len(bytes))
-		// send as pdf
-		fileReader := bytes2.NewReader(bytes)
-
-		_, err = b.SendDocument(ctx, &bot.SendDocumentParams{
-			ChatID: update.Message.Chat.ID,
-			Document: &models.InputFileUpload{
-				Filename: fmt.Sprintf("%s.pdf", docTitle),
-				Data:     fileReader,
-			},
-		})
-		if err != nil {
-			logger.Log.Errorw("error sending pdf", "err", err)
+	}
+
+	scrapeCtx, cancel := context.WithTimeout(ctx, scraperTimeout)
+	chapters, err := scraper.FindListOfChapters(scrapeCtx, manga.Url, scrapedChapters(chRange))
+	cancel()
+	if err != nil {
+		logger.Log.Errorw("could not find the chapters of the manga", "err", err, "manga_title", manga.Title)
+		sendMessage(ctx, b, chatID, "Could not find the chapters of the manga. "+scraperErrorMessage(err), nil)
+		return
+	}
+
+	selected := selectChapters(chapters, chRange)
+	settings := userSettings(db.GetUserRepo(), model.ChatID(chatID))
+	switch {
+	case len(selected) == 0:
+		sendMessage(ctx, b, chatID, fmt.Sprintf("No chapter found for %s", manga.Title), nil)
+		return
+	case len(selected) > maxChaptersPerDownload:
+		sendMessage(ctx, b, chatID,
+			fmt.Sprintf("You requested %d chapters, at most %d can be downloaded at once", len(selected), maxChaptersPerDownload), nil)
+		return
+	case len(selected) == 1:
+		if err := sendChapter(ctx, b, chatID, scraper, *manga, selected[0], settings); err != nil {
+			logger.Log.Errorw("error when sending the chapter", "err", err)
+			sendMessage(ctx, b, chatID, "there was a problem when downloading the chapter. "+scraperErrorMessage(err), nil)
+		}
+		return
+	}
+
+	// more chapters, a message shows the progress
+	progress, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID: chatID,
+		Text:   fmt.Sprintf("Downloading %d chapters of %s...", len(selected), manga.Title),
+	})
+	if err != nil {
+		logger.Log.Errorw("Error sending message", "error", err, "chatId", chatID)
+	}
+	var failed []string
+	for i, ch := range selected {
+		if ctx.Err() != nil {
 			return
 		}
+		editMessage(ctx, b, progress, fmt.Sprintf("Downloading %s of %s (%d/%d)...", ch.Title, manga.Title, i+1, len(selected)))
+		if err := sendChapter(ctx, b, chatID, scraper, *manga, ch, settings); err != nil {
+			logger.Log.Errorw("error when sending the chapter", "err", err, "chapter", ch.Title)
+			failed = append(failed, ch.Title)
+		}
+	}
 
-		logger.Log.Infoln("pdf sent successfully")
+	summary := fmt.Sprintf("Downloaded %d/%d chapters of %s", len(selected)-len(failed), len(selected), manga.Title)
+	if len(failed) > 0 {
+		summary += "\nCould not download: " + strings.Join(failed, ", ")
+	}
+	editMessage(ctx, b, progress, summary)
+}
 
-	case ReadOnline:
-		logger.Log.Infow("user decided to read the manga online", "manga", manga)
-		sendMessage(ctx, b, update.Message.Chat.ID, manga.LastChapter.Url, &models.ReplyKeyboardRemove{
-			RemoveKeyboard: true,
-		})
-		sendMessage(ctx, b, update.Message.Chat.ID,
-			fmt.Sprintf("You will get a message when the last chapter of %s is released on WeebCentral", manga.Title), nil)
-	case DoNothing:
-		logger.Log.Infow("user decided to do nothing", "manga", manga)
-		removeKeyboardFromUser(ctx, b, update.Message.Chat.ID,
-			fmt.Sprintf("You will get a message when the last chapter of %s is released on WeebCentral", manga.Title))
+// findSubscribedManga looks for the manga between the subscriptions of the user,
+// first with the same title and then with a title containing the name
+func findSubscribedManga(mangaRepo repository.MangaRepo, chatID model.ChatID, name string) *model.Manga {
+	mangas, err := mangaRepo.FindMangasOfUser(chatID)
+	if err != nil {
+		logger.Log.Errorw("error when finding mangas", "err", err)
+		return nil
+	}
+	for _, m := range mangas {
+		if strings.EqualFold(m.Title, name) {
+			return &m
+		}
+	}
+	for _, m := range mangas {
+		if strings.Contains(strings.ToLower(m.Title), strings.ToLower(name)) {
+			return &m
+		}
+	}
+	return nil
+}
+
+// only the latest chapter is needed when no range is requested
+func scrapedChapters(r *chapterRange) int {
+	if r == nil {
+		return 1
+	}
+	return scraper.AllChapters
+}
+
+// /format handler
+// /format shows the format of the downloads, /format cbz changes it
+func formatHandler(ctx context.Context, b *bot.Bot, update *models.Update, userRepo repository.UserRepo) {
+	const cmd = "/format"
+	chatID := model.ChatID(update.Message.Chat.ID)
+
+	args, err := parseMessage(cmd, update.Message.Text)
+	if err != nil || args == "" {
+		sendMessage(ctx, b, int64(chatID), fmt.Sprintf(
+			"Your chapters are downloaded as %s. Change it with /format pdf, /format cbz or /format epub", userSettings(userRepo, chatID).format), nil)
+		return
+	}
+
+	format, err := model.ParseDownloadFormat(args)
+	if err != nil {
+		logger.Log.Debugw("error in message of user", "err", err)
+		sendMessage(ctx, b, int64(chatID), "Unknown format, use /format pdf, /format cbz or /format epub", nil)
+		return
+	}
+
+	// the user could have never been registered
+	if err := userRepo.SaveUser(chatID); err != nil {
+		sendMessage(ctx, b, int64(chatID), "There was a problem with the server, try again later", nil)
+		return
+	}
+	if err := userRepo.SetFormat(chatID, format); err != nil {
+		sendMessage(ctx, b, int64(chatID), "There was a problem with the server, try again later", nil)
+		return
+	}
+	sendMessage(ctx, b, int64(chatID), fmt.Sprintf("From now on your chapters are downloaded as %s", format), nil)
+}
+
+// /profile handler
+// /profile lists the profiles, /profile kobo-libra chooses one
+func profileHandler(ctx context.Context, b *bot.Bot, update *models.Update, userRepo repository.UserRepo) {
+	const cmd = "/profile"
+	chatID := model.ChatID(update.Message.Chat.ID)
+
+	args, err := parseMessage(cmd, update.Message.Text)
+	if err != nil || args == "" {
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "Your pages are downloaded with the profile %s. Change it with /profile followed by one of:\n",
+			userSettings(userRepo, chatID).profile.Name)
+		for _, p := range downloader.Profiles {
+			fmt.Fprintf(&sb, "\n%s - %s", p.Name, p.Description)
+		}
+		sendMessage(ctx, b, int64(chatID), sb.String(), nil)
+		return
+	}
+
+	profile, ok := downloader.ProfileByName(strings.ToLower(args))
+	if !ok {
+		sendMessage(ctx, b, int64(chatID), "Unknown profile, use /profile to see them all", nil)
+		return
+	}
+
+	// the user could have never been registered
+	if err := userRepo.SaveUser(chatID); err != nil {
+		sendMessage(ctx, b, int64(chatID), "There was a problem with the server, try again later", nil)
+		return
+	}
+	if err := userRepo.SetProfile(chatID, profile.Name); err != nil {
+		sendMessage(ctx, b, int64(chatID), "There was a problem with the server, try again later", nil)
+		return
+	}
+	sendMessage(ctx, b, int64(chatID), fmt.Sprintf("From now on your pages are optimized for %s", profile.Description), nil)
+}
+
+// /webtoon handler
+// /webtoon on slices the strips of the webtoons in pages, /webtoon off sends them as they are
+func webtoonHandler(ctx context.Context, b *bot.Bot, update *models.Update, userRepo repository.UserRepo) {
+	const cmd = "/webtoon"
+	chatID := model.ChatID(update.Message.Chat.ID)
+
+	args, err := parseMessage(cmd, update.Message.Text)
+	if err != nil || args == "" {
+		mode := "off"
+		if userSettings(userRepo, chatID).webtoon {
+			mode = "on"
+		}
+		sendMessage(ctx, b, int64(chatID), fmt.Sprintf(
+			"Webtoon mode is %s. With /webtoon on the long strips are sliced in pages, cutting between the panels", mode), nil)
+		return
+	}
+
+	var webtoon bool
+	switch strings.ToLower(args) {
+	case "on":
+		webtoon = true
+	case "off":
+		webtoon = false
 	default:
-		removeKeyboardFromUser(ctx, b, update.Message.Chat.ID, "Invalid choice. Please try again with /add command.")
+		sendMessage(ctx, b, int64(chatID), "Use /webtoon on or /webtoon off", nil)
+		return
+	}
+
+	// the user could have never been registered
+	if err := userRepo.SaveUser(chatID); err != nil {
+		sendMessage(ctx, b, int64(chatID), "There was a problem with the server, try again later", nil)
+		return
+	}
+	if err := userRepo.SetWebtoon(chatID, webtoon); err != nil {
+		sendMessage(ctx, b, int64(chatID), "There was a problem with the server, try again later", nil)
+		return
+	}
+	if webtoon {
+		sendMessage(ctx, b, int64(chatID), "From now on the webtoons are sliced in pages. Normal manga pages are not changed", nil)
+	} else {
+		sendMessage(ctx, b, int64(chatID), "From now on the webtoons are sent as they are", nil)
 	}
 }
 
 // /cancel handler
-// cleans the maps from the chatId data
-func cancelHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+// ends the conversations in progress of the chat
+func cancelHandler(ctx context.Context, b *bot.Bot, update *models.Update, conversations []conversationHandler) {
 	chatId := model.ChatID(update.Message.Chat.ID)
 	logger.Log.Infow("deleting conversation history", "chatID", chatId)
-	convStore.Clean(chatId)
+	for _, conv := range conversations {
+		conv.End(chatId)
+	}
 	removeKeyboardFromUser(ctx, b, update.Message.Chat.ID, "Conversation cancelled. Insert a new command")
 }
 
-// send update to the users as soon as a new a
+// send update to the users as soon as new chapters are released.
+// All the chapters released since the last run are notified, in one message per manga
 func updater(ctx context.Context, b *bot.Bot, db repository.Database, scraper scraper.Scraper) {
 	logger.Log.Infow("starting updating the user")
 	mangas, err := db.GetMangaRepo().FindAllMangas()
@@ -368,47 +862,132 @@ func updater(ctx context.Context, b *bot.Bot, db repository.Database, scraper sc
 		return
 	}
 
-	// get the mangas with new chapters
-	var mangaWithNewChapters []model.Manga // have chapters updated
+	chapterRepo := db.GetChapterRepo()
+	var newChaptersNr, usrNotifiedNr int
 	for _, m := range mangas {
-		scrapChs, err := scraper.FindListOfChapters(m.Url, 1)
-		if err != nil {
-			logger.Log.Errorw("error scraping chapter", "err", err)
+		if ctx.Err() != nil {
+			logger.Log.Infow("updater stopped", "err", ctx.Err())
 			return
 		}
-		scrapCh := scrapChs[0]
-		if scrapCh.Url != m.LastChapter.Url {
-			logger.Log.Infow("manga with new chapter found", "manga", m.Title, "ch_date", scrapCh.ReleasedAt)
-			// new chapter was scraped
-			m.LastChapter = &scrapCh
-			mangaWithNewChapters = append(mangaWithNewChapters, m)
+		newChs, scrapChs, err := newChaptersOf(ctx, scraper, chapterRepo, m)
+		if isNotFound(err) {
+			disableManga(ctx, b, db, users, m)
+			continue
+		}
+		if err != nil {
+			logger.Log.Errorw("error scraping chapter, skipping manga", "manga", m.Title, "timeout", isTimeout(err), "err", err)
+			continue
+		}
+		if len(scrapChs) == 0 {
+			logger.Log.Warnw("no chapter found, skipping manga", "manga", m.Title)
+			continue
 		}
-	}
 
-	logger.Log.Infof("a total of %d new chapters were found", len(mangaWithNewChapters))
+		if len(newChs) == 0 {
+			continue
+		}
+		logger.Log.Infow("manga with new chapters found", "manga", m.Title, "new_chapters", len(newChs))
+		newChaptersNr += len(newChs)
 
-	// notify the users subscribed to the mangas
-	var usrNotifiedNr int
-	for _, usr := range users {
-		for _, m := range mangaWithNewChapters {
+		// notify the users subscribed to the manga
+		msg := newChaptersMessage(m, newChs)
+		for _, usr := range users {
 			if usr.HasMangaSubscription(&m) {
 				usrNotifiedNr++
 				logger.Log.Infow("user is subscribed to manga. sending update...", "chat_id", usr.ChatID, "manga", m.Title)
-				msg := fmt.Sprintf("NEW CHAPTER RELEASED\n%s\n%s\n%s\n", m.Title, m.LastChapter.Title, m.LastChapter.ReleasedAt)
 				sendMessage(ctx, b, int64(usr.ChatID), msg, nil)
 			}
 		}
+
+		latest := newChs[len(newChs)-1]
+		if err := chapterRepo.UpdateLastChapter(&latest, m.Url); err != nil {
+			logger.Log.Errorw("there was a problem updating the chapter in the repository", "err", err)
+		}
 	}
 
+	logger.Log.Infof("a total of %d new chapters were found", newChaptersNr)
 	logger.Log.Infof("a total of %d users were notified", usrNotifiedNr)
+}
 
-	// update the repository
-	chapterRepo := db.GetChapterRepo()
-	for _, m := range mangaWithNewChapters {
-		if err := chapterRepo.UpdateLastChapter(m.LastChapter, m.Url); err != nil {
-			logger.Log.Errorw("there was a problem updating the chapter in the repository", "err", err)
+// chapters requested at every check. When they are all new, many chapters
+// were released at once and the full list is requested
+const updaterChapters = 10
+
+// newChaptersOf saves the chapters read from the site in the history of the manga and returns
+// the new ones released after the last chapter, from the oldest. The chapters read are returned too
+func newChaptersOf(ctx context.Context, s scraper.Scraper, chapterRepo repository.ChapterRepo, m model.Manga) ([]model.Chapter, []model.Chapter, error) {
+	find := func(nChaps int) ([]model.Chapter, error) {
+		var chs []model.Chapter
+		err := withRetry(ctx, func(ctx context.Context) error {
+			// a manga which does not answer must not block the others
+			scrapeCtx, cancel := context.WithTimeout(ctx, scraperTimeout)
+			defer cancel()
+			var err error
+			chs, err = s.FindListOfChapters(scrapeCtx, m.Url, nChaps)
+			return err
+		})
+		return chs, err
+	}
+
+	scrapChs, err := find(updaterChapters)
+	if err != nil || len(scrapChs) == 0 {
+		return nil, scrapChs, err
+	}
+	saved, err := chapterRepo.SaveChapters(m.Url, scrapChs)
+	if err != nil {
+		return nil, scrapChs, err
+	}
+	if len(saved) == updaterChapters && m.LastChapter != nil {
+		if scrapChs, err = find(scraper.AllChapters); err != nil {
+			return nil, nil, err
+		}
+		older, err := chapterRepo.SaveChapters(m.Url, scrapChs)
+		if err != nil {
+			return nil, scrapChs, err
+		}
+		saved = append(saved, older...)
+	}
+	isNew := make(map[string]bool, len(saved))
+	for _, ch := range saved {
+		isNew[ch.Url] = true
+	}
+
+	// the history misses the chapters older than the last one when the manga was just added,
+	// only the ones listed above it are released after. Without it only the most recent can be new
+	after := 1
+	if m.LastChapter != nil {
+		if last := chapterIndex(scrapChs, m.LastChapter.Url); last >= 0 {
+			after = last
+		} else {
+			logger.Log.Warnw("last chapter not found on the site", "manga", m.Title, "chapter", m.LastChapter.Url)
 		}
 	}
 
-	logger.Log.Infof("finished notifying the users")
+	// the site lists the most recent first
+	var newChs []model.Chapter
+	for _, ch := range scrapChs[:after] {
+		if isNew[ch.Url] {
+			newChs = append(newChs, ch)
+		}
+	}
+	slices.Reverse(newChs)
+	return newChs, scrapChs, nil
+}
+
+func chapterIndex(chapters []model.Chapter, url string) int {
+	return slices.IndexFunc(chapters, func(ch model.Chapter) bool { return ch.Url == url })
+}
+
+// disableManga stops the updates of a manga removed from the site and tells its subscribers
+func disableManga(ctx context.Context, b *bot.Bot, db repository.Database, users []model.User, m model.Manga) {
+	logger.Log.Warnw("manga not found on the site, disabling it", "manga", m.Title, "url", m.Url)
+	if err := db.GetMangaRepo().DisableManga(m.Url); err != nil {
+		return
+	}
+	for _, usr := range users {
+		if usr.HasMangaSubscription(&m) {
+			msg := fmt.Sprintf("%s was not found on the site anymore, you will not receive its updates", m.Title)
+			sendMessage(ctx, b, int64(usr.ChatID), msg, nil)
+		}
+	}
 }
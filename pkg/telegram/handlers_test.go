@@ -0,0 +1,483 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/akarakai/gomanga-tbot/pkg/logger"
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+	"github.com/go-telegram/bot/models"
+	"go.uber.org/zap"
+)
+
+func textUpdate(chatID int64, text string) *models.Update {
+	return &models.Update{Message: &models.Message{
+		Chat: models.Chat{ID: chatID},
+		From: &models.User{ID: chatID},
+		Text: text,
+	}}
+}
+
+// callbackUpdate is the press of a button of the message 1 in the chat
+func callbackUpdate(chatID int64, data string) *models.Update {
+	return &models.Update{CallbackQuery: &models.CallbackQuery{
+		ID:   "query",
+		From: models.User{ID: chatID},
+		Data: data,
+		Message: models.MaybeInaccessibleMessage{
+			Message: &models.Message{ID: 1, Chat: models.Chat{ID: chatID}},
+		},
+	}}
+}
+
+// buttonData returns the callback data of the button with the text
+func buttonData(t *testing.T, keyboard string, text string) string {
+	t.Helper()
+	var markup models.InlineKeyboardMarkup
+	if err := json.Unmarshal([]byte(keyboard), &markup); err != nil {
+		t.Fatalf("Expected an inline keyboard, got %q", keyboard)
+	}
+	for _, row := range markup.InlineKeyboard {
+		for _, button := range row {
+			if button.Text == text {
+				return button.CallbackData
+			}
+		}
+	}
+	t.Fatalf("No button %q in %s", text, keyboard)
+	return ""
+}
+
+func TestAddManga(t *testing.T) {
+	// user 1 follows Berserk already, user 2 subscribes to it
+	db, s := newUpdaterTest(t, 3)
+	s.mangas = []model.Manga{
+		{Title: "Berserk", Url: berserkURL, Source: "weebcentral"},
+		{Title: "Berserk", Url: "https://mangadex.org/title/berserk", Source: "mangadex"},
+	}
+	if err := db.UserRepo.SaveUser(2); err != nil {
+		t.Fatalf("SaveUser: %v", err)
+	}
+	b, api := newFakeBot(t)
+	ctx := context.Background()
+	conv := newAddConversation(db, s)
+	chosen := conversationCallback(conv, addExpired)
+
+	addHandler(ctx, b, textUpdate(2, "/add berserk"), conv, s)
+	msgs := api.messages(2)
+	if len(msgs) != 1 {
+		t.Fatalf("Expected inline keyboard with the mangas, got %v", msgs)
+	}
+	other := buttonData(t, msgs[0].Fields["reply_markup"], "Berserk [mangadex]")
+
+	chosen(ctx, b, callbackUpdate(2, buttonData(t, msgs[0].Fields["reply_markup"], "Berserk [weebcentral]")))
+	if answers := api.calls("answerCallbackQuery"); len(answers) != 1 {
+		t.Errorf("Expected the callback to be answered, got %v", answers)
+	}
+	edits := api.calls("editMessageText")
+	if len(edits) != 1 || edits[0].Text() != "You have chosen: Berserk [weebcentral]" {
+		t.Errorf("Expected the list to be edited, got %v", edits)
+	}
+	msgs = api.messages(2)
+	actions := msgs[len(msgs)-1].Fields["reply_markup"]
+	if mangas, _ := db.MangaRepo.FindMangasOfUser(2); len(mangas) != 1 || mangas[0].Url != berserkURL {
+		t.Errorf("Expected user 2 subscribed to Berserk, got %v", mangas)
+	}
+
+	conversationCallback(conv, actionExpired)(ctx, b, callbackUpdate(2, buttonData(t, actions, "Do Nothing")))
+	edits = api.calls("editMessageText")
+	if len(edits) != 2 || !strings.Contains(edits[1].Text(), "You will get a message") {
+		t.Errorf("Expected the actions to be edited, got %v", edits)
+	}
+
+	// the conversation is over, the old buttons do nothing
+	api.reset()
+	chosen(ctx, b, callbackUpdate(2, other))
+	if answers := api.calls("answerCallbackQuery"); len(answers) != 1 || !strings.Contains(answers[0].Text(), "expired") {
+		t.Errorf("Expected an expired answer, got %v", answers)
+	}
+	if mangas, _ := db.MangaRepo.FindMangasOfUser(2); len(mangas) != 1 {
+		t.Errorf("An old button must not subscribe, got %v", mangas)
+	}
+}
+
+func TestRemoveManga(t *testing.T) {
+	db, _ := newUpdaterTest(t, 1)
+	b, api := newFakeBot(t)
+	ctx := context.Background()
+	conv := newRemoveConversation(db)
+
+	removeHandler(ctx, b, textUpdate(1, "/remove"), conv, db.MangaRepo)
+	msgs := api.messages(1)
+	if len(msgs) != 1 {
+		t.Fatalf("Expected keyboard with the mangas, got %v", msgs)
+	}
+
+	conversationCallback(conv, removeExpired)(ctx, b, callbackUpdate(1, buttonData(t, msgs[0].Fields["reply_markup"], "Berserk [weebcentral]")))
+	edits := api.calls("editMessageText")
+	if len(edits) != 1 || !strings.Contains(edits[0].Text(), "not receive the updates of Berserk") {
+		t.Fatalf("Unexpected edits %v", edits)
+	}
+	if mangas, _ := db.MangaRepo.FindMangasOfUser(1); len(mangas) != 0 {
+		t.Errorf("Expected no subscription, got %v", mangas)
+	}
+	// nobody follows it, the updater must not scrape it
+	if mangas, _ := db.MangaRepo.FindAllMangas(); len(mangas) != 0 {
+		t.Errorf("Expected manga to be deleted, got %v", mangas)
+	}
+
+	api.reset()
+	removeHandler(ctx, b, textUpdate(1, "/remove"), conv, db.MangaRepo)
+	if msgs := api.messages(1); len(msgs) != 1 || !strings.Contains(msgs[0].Text(), "not subscribed to any manga") {
+		t.Errorf("Unexpected messages %v", msgs)
+	}
+}
+
+func TestOldKeyboard(t *testing.T) {
+	db, s := newUpdaterTest(t, 3)
+	s.mangas = []model.Manga{
+		{Title: "Berserk", Url: berserkURL, Source: "weebcentral"},
+		{Title: "Berserk", Url: "https://mangadex.org/title/berserk", Source: "mangadex"},
+	}
+	b, api := newFakeBot(t)
+	ctx := context.Background()
+	add := newAddConversation(db, s)
+	remove := newRemoveConversation(db)
+
+	// the list of the first search is still in the chat when the user searches again
+	addHandler(ctx, b, textUpdate(2, "/add berserk"), add, s)
+	old := buttonData(t, api.messages(2)[0].Fields["reply_markup"], "Berserk [weebcentral]")
+	s.mangas = s.mangas[1:]
+	addHandler(ctx, b, textUpdate(2, "/add berserk"), add, s)
+	conversationCallback(add, addExpired)(ctx, b, callbackUpdate(2, old))
+	if answers := api.calls("answerCallbackQuery"); len(answers) != 1 || !strings.Contains(answers[0].Text(), "expired") {
+		t.Errorf("Expected an expired answer, got %v", answers)
+	}
+	if mangas, _ := db.MangaRepo.FindMangasOfUser(2); len(mangas) != 0 {
+		t.Errorf("A button of the first search must not subscribe to the second one, got %v", mangas)
+	}
+	if state, _ := add.State(2); state != ChosenManga {
+		t.Errorf("Expected the second search to wait for its buttons, state %q", state)
+	}
+
+	api.reset()
+	removeHandler(ctx, b, textUpdate(1, "/remove"), remove, db.MangaRepo)
+	old = buttonData(t, api.messages(1)[0].Fields["reply_markup"], "Berserk [weebcentral]")
+	removeHandler(ctx, b, textUpdate(1, "/remove"), remove, db.MangaRepo)
+	conversationCallback(remove, removeExpired)(ctx, b, callbackUpdate(1, old))
+	if mangas, _ := db.MangaRepo.FindMangasOfUser(1); len(mangas) != 1 {
+		t.Errorf("A button of the first /remove must not unsubscribe, got %v", mangas)
+	}
+}
+
+func TestCallbackIndex(t *testing.T) {
+	if idx, err := callbackIndex("add:2", callbackAdd, 3); err != nil || idx != 2 {
+		t.Errorf("Expected 2, got %d %v", idx, err)
+	}
+	for _, data := range []string{"add:3", "add:-1", "add:x", "remove:1"} {
+		if _, err := callbackIndex(data, callbackAdd, 3); err == nil {
+			t.Errorf("Expected error for %q", data)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	db, _ := newUpdaterTest(t, 1)
+	b, api := newFakeBot(t)
+	ctx := context.Background()
+
+	formatHandler(ctx, b, textUpdate(1, "/format"), db.UserRepo)
+	if msgs := api.messages(1); len(msgs) != 1 || !strings.Contains(msgs[0].Text(), "downloaded as pdf") {
+		t.Fatalf("Unexpected messages %v", msgs)
+	}
+
+	formatHandler(ctx, b, textUpdate(1, "/format CBZ"), db.UserRepo)
+	if got := userSettings(db.UserRepo, 1).format; got != model.FormatCBZ {
+		t.Errorf("Expected cbz, got %q", got)
+	}
+
+	formatHandler(ctx, b, textUpdate(1, "/format docx"), db.UserRepo)
+	if msgs := api.messages(1); len(msgs) != 3 || !strings.Contains(msgs[2].Text(), "Unknown format") {
+		t.Errorf("Unexpected messages %v", msgs)
+	}
+	if got := userSettings(db.UserRepo, 1).format; got != model.FormatCBZ {
+		t.Errorf("An unknown format must not change it, got %q", got)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	db, _ := newUpdaterTest(t, 1)
+	b, api := newFakeBot(t)
+	ctx := context.Background()
+
+	profileHandler(ctx, b, textUpdate(1, "/profile"), db.UserRepo)
+	if msgs := api.messages(1); len(msgs) != 1 || !strings.Contains(msgs[0].Text(), "kobo-libra") {
+		t.Fatalf("Expected the list of profiles, got %v", msgs)
+	}
+
+	profileHandler(ctx, b, textUpdate(1, "/profile Kobo-Libra"), db.UserRepo)
+	if got := userSettings(db.UserRepo, 1).profile; got.Name != "kobo-libra" || got.Width != 1264 {
+		t.Errorf("Expected kobo-libra, got %+v", got)
+	}
+
+	profileHandler(ctx, b, textUpdate(1, "/profile etch-a-sketch"), db.UserRepo)
+	if msgs := api.messages(1); len(msgs) != 3 || !strings.Contains(msgs[2].Text(), "Unknown profile") {
+		t.Errorf("Unexpected messages %v", msgs)
+	}
+}
+
+func TestWebtoon(t *testing.T) {
+	db, _ := newUpdaterTest(t, 1)
+	b, api := newFakeBot(t)
+	ctx := context.Background()
+
+	webtoonHandler(ctx, b, textUpdate(1, "/webtoon"), db.UserRepo)
+	if msgs := api.messages(1); len(msgs) != 1 || !strings.Contains(msgs[0].Text(), "mode is off") {
+		t.Fatalf("Unexpected messages %v", msgs)
+	}
+	webtoonHandler(ctx, b, textUpdate(1, "/webtoon ON"), db.UserRepo)
+	if !userSettings(db.UserRepo, 1).webtoon {
+		t.Error("Expected webtoon mode on")
+	}
+	webtoonHandler(ctx, b, textUpdate(1, "/webtoon maybe"), db.UserRepo)
+	if !userSettings(db.UserRepo, 1).webtoon {
+		t.Error("An unknown mode must not change it")
+	}
+	webtoonHandler(ctx, b, textUpdate(1, "/webtoon off"), db.UserRepo)
+	if userSettings(db.UserRepo, 1).webtoon {
+		t.Error("Expected webtoon mode off")
+	}
+}
+
+func TestSendLongMessage(t *testing.T) {
+	logger.Log = zap.NewNop().Sugar()
+	b, api := newFakeBot(t)
+	keyboard := createActionKeyboard(newNonce())
+
+	sendMessage(context.Background(), b, 1, strings.Repeat("word ", 2000), keyboard)
+	msgs := api.messages(1)
+	if len(msgs) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(msgs))
+	}
+	for i, msg := range msgs {
+		if utf8.RuneCountInString(msg.Text()) > maxMessageLength {
+			t.Errorf("Message %d is too long", i)
+		}
+		if _, ok := msg.Fields["reply_markup"]; ok != (i == 2) {
+			t.Errorf("Expected the keyboard on the last message only, message %d has it: %v", i, ok)
+		}
+	}
+}
+
+func TestAddMangaPages(t *testing.T) {
+	db, s := newUpdaterTest(t, 3)
+	for i := 0; i < 25; i++ {
+		s.mangas = append(s.mangas, model.Manga{Title: fmt.Sprintf("Berserk %d", i), Url: fmt.Sprintf("https://weebcentral.com/series/berserk-%d", i)})
+	}
+	s.mangas[12].Url = berserkURL
+	b, api := newFakeBot(t)
+	ctx := context.Background()
+	conv := newAddConversation(db, s)
+	chosen := conversationCallback(conv, addExpired)
+
+	addHandler(ctx, b, textUpdate(1, "/add berserk"), conv, s)
+	keyboard := api.messages(1)[0].Fields["reply_markup"]
+	prefix := strings.TrimSuffix(buttonData(t, keyboard, "Berserk 0"), "0")
+	if !strings.Contains(keyboard, `"`+prefix+`9"`) || strings.Contains(keyboard, `"`+prefix+`10"`) ||
+		!strings.Contains(keyboard, `"`+prefix+`p1"`) || strings.Contains(keyboard, `"`+prefix+`p-1"`) {
+		t.Fatalf("Expected the first page, got %s", keyboard)
+	}
+
+	chosen(ctx, b, callbackUpdate(1, prefix+"p1"))
+	edits := api.calls("editMessageReplyMarkup")
+	if len(edits) != 1 {
+		t.Fatalf("Expected the keyboard to be edited, got %v", edits)
+	}
+	keyboard = edits[0].Fields["reply_markup"]
+	if !strings.Contains(keyboard, `"`+prefix+`10"`) || strings.Contains(keyboard, `"`+prefix+`9"`) ||
+		!strings.Contains(keyboard, `"`+prefix+`p0"`) || !strings.Contains(keyboard, `"`+prefix+`p2"`) {
+		t.Errorf("Expected the second page, got %s", keyboard)
+	}
+	if state, _ := conv.State(1); state != ChosenManga {
+		t.Errorf("Changing page must not end the conversation, state %q", state)
+	}
+
+	// a page out of the list is an old button
+	chosen(ctx, b, callbackUpdate(1, prefix+"p3"))
+	if answers := api.calls("answerCallbackQuery"); len(answers) != 2 || !strings.Contains(answers[1].Text(), "expired") {
+		t.Errorf("Expected an expired answer, got %v", answers)
+	}
+
+	chosen(ctx, b, callbackUpdate(1, prefix+"12"))
+	if edits := api.calls("editMessageText"); len(edits) != 2 || !strings.Contains(edits[1].Text(), "Berserk 12") {
+		t.Errorf("Expected manga 12 to be chosen, got %v", edits)
+	}
+}
+
+func TestListPages(t *testing.T) {
+	db, _ := newUpdaterTest(t, 1)
+	for i := 2; i <= 12; i++ {
+		ch := testChapter(i)
+		ch.Url = fmt.Sprintf("https://weebcentral.com/chapters/vagabond-%d", i)
+		url := fmt.Sprintf("https://weebcentral.com/series/vagabond-%d", i)
+		if err := db.MangaRepo.SaveManga(&model.Manga{Title: fmt.Sprintf("Vagabond %d", i), Url: url, LastChapter: &ch}); err != nil {
+			t.Fatalf("SaveManga: %v", err)
+		}
+		if err := db.UserRepo.SaveManga(1, url); err != nil {
+			t.Fatalf("SaveManga of user: %v", err)
+		}
+	}
+	b, api := newFakeBot(t)
+	ctx := context.Background()
+
+	mangaListHandler(ctx, b, textUpdate(1, "/list"), db.MangaRepo)
+	msgs := api.messages(1)
+	if len(msgs) != 1 || !strings.HasPrefix(msgs[0].Text(), "1. Vagabond 12.") || strings.Contains(msgs[0].Text(), "11. ") {
+		t.Fatalf("Expected the first page, most recent first, got %v", msgs)
+	}
+	if !strings.Contains(msgs[0].Fields["reply_markup"], `"list:p1"`) {
+		t.Errorf("Expected the next button, got %s", msgs[0].Fields["reply_markup"])
+	}
+
+	listPageCallback(ctx, b, callbackUpdate(1, "list:p1"), db.MangaRepo)
+	edits := api.calls("editMessageText")
+	if len(edits) != 1 || !strings.HasPrefix(edits[0].Text(), "11. Vagabond 2.") || !strings.Contains(edits[0].Text(), "12. Berserk.") {
+		t.Errorf("Expected the second page, got %v", edits)
+	}
+	if keyboard := edits[0].Fields["reply_markup"]; !strings.Contains(keyboard, `"list:p0"`) || strings.Contains(keyboard, `"list:p2"`) {
+		t.Errorf("Expected only the previous button, got %s", keyboard)
+	}
+}
+
+func inlineUpdate(userID int64, query string) *models.Update {
+	return &models.Update{InlineQuery: &models.InlineQuery{ID: "inline", From: &models.User{ID: userID}, Query: query}}
+}
+
+func TestInlineQuery(t *testing.T) {
+	inlineMangas = newInlineCache(inlineScrapers)
+	db, s := newUpdaterTest(t, 3)
+	s.mangas = []model.Manga{
+		{Title: "Berserk", Url: berserkURL, Source: "weebcentral", CoverUrl: "https://example.com/berserk.jpg"},
+		{Title: "Berserk of Gluttony", Url: "https://weebcentral.com/series/gluttony", Source: "weebcentral"},
+	}
+	b, api := newFakeBot(t)
+	ctx := context.Background()
+
+	inlineQueryHandler(ctx, b, inlineUpdate(2, "berserk"), db.ShareRepo, s, "gomangabot")
+	answers := api.calls("answerInlineQuery")
+	if len(answers) != 1 {
+		t.Fatalf("Expected the inline query to be answered, got %v", answers)
+	}
+	results := answers[0].Fields["results"]
+	for _, want := range []string{
+		`"type":"article"`,
+		`"thumbnail_url":"https://example.com/berserk.jpg"`,
+		"Latest: Chapter 3",
+		"Latest chapter not found",
+		"https://t.me/gomangabot?start=add_" + shareKey(berserkURL),
+		"Berserk of Gluttony",
+	} {
+		if !strings.Contains(results, want) {
+			t.Errorf("Expected %q in the results %s", want, results)
+		}
+	}
+	if manga, _ := db.ShareRepo.FindShared(shareKey(berserkURL)); manga == nil || manga.Url != berserkURL {
+		t.Errorf("Expected the shared manga to be saved, got %v", manga)
+	}
+
+	// nothing is searched while the user has not typed yet
+	api.reset()
+	inlineQueryHandler(ctx, b, inlineUpdate(2, " "), db.ShareRepo, s, "gomangabot")
+	if answers := api.calls("answerInlineQuery"); len(answers) != 1 || answers[0].Fields["results"] != "[]" {
+		t.Errorf("Expected an empty answer, got %v", answers)
+	}
+}
+
+// slowScraper counts the calls for the details running at the same time
+type slowScraper struct {
+	*fakeScraper
+	count         sync.Mutex
+	running, most int
+}
+
+func (s *slowScraper) FindMangaDetails(ctx context.Context, mangaURL string) (model.Manga, error) {
+	s.count.Lock()
+	s.running++
+	s.most = max(s.most, s.running)
+	s.count.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	s.count.Lock()
+	s.running--
+	s.count.Unlock()
+	return s.fakeScraper.FindMangaDetails(ctx, mangaURL)
+}
+
+func TestInlineDetails(t *testing.T) {
+	inlineMangas = newInlineCache(1)
+	s := &slowScraper{fakeScraper: &fakeScraper{}}
+	var found []model.Manga
+	for i := 1; i <= 4; i++ {
+		url := fmt.Sprintf("https://weebcentral.com/series/%d", i)
+		s.release(url, testChapter(i))
+		found = append(found, model.Manga{Title: fmt.Sprintf("Manga %d", i), Url: url})
+	}
+
+	mangas := slices.Clone(found)
+	inlineDetails(context.Background(), s, mangas)
+	if s.most != 1 {
+		t.Errorf("Expected a call at a time, got %d", s.most)
+	}
+	for _, m := range mangas {
+		if m.LastChapter == nil {
+			t.Errorf("Expected the last chapter of %s", m.Title)
+		}
+	}
+
+	// the next keys typed by the user find the same mangas, they are not scraped again
+	calls := len(s.calls)
+	mangas = slices.Clone(found)
+	inlineDetails(context.Background(), s, mangas)
+	if len(s.calls) != calls {
+		t.Errorf("Expected the mangas from the cache, got %d more calls", len(s.calls)-calls)
+	}
+	if mangas[3].LastChapter == nil || mangas[3].LastChapter.Number != 4 {
+		t.Errorf("Expected the last chapter from the cache, got %v", mangas[3].LastChapter)
+	}
+}
+
+func TestStartSharedManga(t *testing.T) {
+	db, s := newUpdaterTest(t, 3)
+	b, api := newFakeBot(t)
+	ctx := context.Background()
+	conv := newAddConversation(db, s)
+	key := shareKey(berserkURL)
+	if err := db.ShareRepo.SaveShared(key, model.Manga{Title: "Berserk", Url: berserkURL, Source: "weebcentral"}); err != nil {
+		t.Fatalf("SaveShared: %v", err)
+	}
+
+	// user 2 opens the bot for the first time with the link
+	startHandler(ctx, b, textUpdate(2, "/start add_"+key), db, conv, s)
+	if mangas, _ := db.MangaRepo.FindMangasOfUser(2); len(mangas) != 1 || mangas[0].Url != berserkURL {
+		t.Fatalf("Expected user 2 subscribed to Berserk, got %v", mangas)
+	}
+	msgs := api.messages(2)
+	buttonData(t, msgs[len(msgs)-1].Fields["reply_markup"], "Download")
+	if state, _ := conv.State(2); state != ChoseWhatToDo {
+		t.Errorf("Expected the conversation to wait for the action, state %q", state)
+	}
+
+	api.reset()
+	startHandler(ctx, b, textUpdate(3, "/start add_unknown"), db, conv, s)
+	if msgs := api.messages(3); len(msgs) != 1 || !strings.Contains(msgs[0].Text(), "expired") {
+		t.Errorf("Unexpected messages %v", msgs)
+	}
+}
@@ -1,17 +1,99 @@
 package telegram
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf16"
+	"unicode/utf8"
 
+	"github.com/akarakai/gomanga-tbot/pkg/downloader"
 	"github.com/akarakai/gomanga-tbot/pkg/logger"
 	"github.com/akarakai/gomanga-tbot/pkg/model"
+	"github.com/akarakai/gomanga-tbot/pkg/repository"
+	"github.com/akarakai/gomanga-tbot/pkg/scraper"
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
 )
 
+// max time a single call to the scraper can take
+const scraperTimeout = 45 * time.Second
+
+// the inline answers are shown while the user types, the search and the details must be quick
+const inlineTimeout = 10 * time.Second
+
+// max mangas answered to an inline query, the details of every one are scraped
+const maxInlineResults = 5
+
+// max calls to the scraper of the inline queries at the same time, for all the users
+const inlineScrapers = 2
+
+// the mangas of the inline answers are kept for this time with their details
+const inlineCacheTime = 30 * time.Minute
+
+// attempts of the updater when the error of the scraper may fix itself
+const scraperAttempts = 3
+
+// wait before the first retry, doubled at every attempt
+var retryBackoff = 2 * time.Second
+
+// scraperErrorMessage explains to the user why the scraper failed
+func scraperErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, scraper.ErrNotFound):
+		return "The manga was not found on the site, it may have been removed"
+	case errors.Is(err, scraper.ErrBlocked):
+		return "The site is blocking the bot at the moment, try again later"
+	case errors.Is(err, scraper.ErrRateLimited):
+		return "The site received too many requests, try again in a few minutes"
+	case isTimeout(err):
+		return "The site is taking too long to answer, try again later"
+	case errors.Is(err, scraper.ErrLayoutChanged):
+		return "The site changed and the bot cannot read it anymore, it will be fixed soon"
+	case errors.Is(err, scraper.ErrNetwork):
+		return "The site cannot be reached, try again later"
+	}
+	return "There are some problems with the bot, try again"
+}
+
+// withRetry calls f again with a growing backoff while the error is retryable
+func withRetry(ctx context.Context, f func(ctx context.Context) error) error {
+	wait := retryBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = f(ctx); err == nil || !scraper.IsRetryable(err) || attempt == scraperAttempts {
+			return err
+		}
+		logger.Log.Warnw("scraper failed, retrying", "attempt", attempt, "wait", wait, "err", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func isTimeout(err error) bool {
+	return scraper.IsTimeout(err)
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, scraper.ErrNotFound)
+}
+
 // Helper function to format release date to human-readable format
 func formatReleaseDate(releaseTime time.Time) string {
 	now := time.Now()
@@ -45,19 +127,386 @@ func pluralS(count int) string {
 }
 
 // Helper function to reduce code duplication for sending messages
+// The texts longer than a telegram message are sent in more messages, the keyboard goes with the last one
 func sendMessage(ctx context.Context, b *bot.Bot, chatID int64, text string, replyMarkup models.ReplyMarkup) {
 	if text == "" {
 		logger.Log.Warn("Attempting to send empty message, skipping")
 		return
 	}
 
-	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
-		ChatID:      chatID,
-		Text:        text,
-		ReplyMarkup: replyMarkup,
+	chunks := splitMessage(text, maxMessageLength)
+	for i, chunk := range chunks {
+		params := &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   chunk,
+		}
+		if i == len(chunks)-1 {
+			params.ReplyMarkup = replyMarkup
+		}
+		if _, err := b.SendMessage(ctx, params); err != nil {
+			logger.Log.Errorw("Error sending message", "error", err, "chatId", chatID)
+			return
+		}
+	}
+}
+
+// telegram refuses the messages longer than 4096 characters, counted in UTF-16 like the emojis
+const maxMessageLength = 4096
+
+// splitMessage splits the text in chunks of at most max characters.
+// The text is cut between paragraphs, lines or words when possible
+func splitMessage(text string, max int) []string {
+	var chunks []string
+	for utf16Length(text) > max {
+		cut := messageCut(text, max)
+		if chunk := strings.TrimRight(text[:cut], " \n"); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		text = strings.TrimLeft(text[cut:], " \n")
+	}
+	if text != "" {
+		chunks = append(chunks, text)
+	}
+	return chunks
+}
+
+// messageCut returns the byte index where the text longer than max is cut:
+// the last paragraph, line or word ending in the first max characters
+func messageCut(text string, max int) int {
+	limit, length := 0, 0
+	for i, r := range text {
+		length += utf16.RuneLen(r)
+		if length > max {
+			break
+		}
+		limit = i + utf8.RuneLen(r)
+	}
+	for _, sep := range []string{"\n\n", "\n", " "} {
+		if i := strings.LastIndex(text[:limit], sep); i > 0 {
+			return i
+		}
+	}
+	return limit
+}
+
+func utf16Length(text string) int {
+	length := 0
+	for _, r := range text {
+		length += utf16.RuneLen(r) // the invalid bytes are read as utf8.RuneError, one character
+	}
+	return length
+}
+
+// sendPhoto sends the image at photoURL with the caption.
+// Telegram may refuse to download the image, in that case only the caption is sent
+func sendPhoto(ctx context.Context, b *bot.Bot, chatID int64, photoURL string, caption string) {
+	if photoURL == "" {
+		sendMessage(ctx, b, chatID, caption, nil)
+		return
+	}
+
+	_, err := b.SendPhoto(ctx, &bot.SendPhotoParams{
+		ChatID:  chatID,
+		Photo:   &models.InputFileString{Data: photoURL},
+		Caption: caption,
 	})
 	if err != nil {
-		logger.Log.Errorw("Error sending message", "error", err, "chatId", chatID)
+		logger.Log.Errorw("Error sending photo, sending the caption only", "error", err, "chatId", chatID, "photo", photoURL)
+		sendMessage(ctx, b, chatID, caption, nil)
+	}
+}
+
+// max length of the caption of a photo
+const maxCaptionLength = 1024
+
+// mangaInfoText describes the manga in the confirmation of /add.
+// The description is cut so that the text fits in the caption of the cover
+func mangaInfoText(manga model.Manga) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📚 **%s**\n", manga.Title)
+	if len(manga.AltTitles) > 0 {
+		fmt.Fprintf(&sb, "🏷 Also known as: %s\n", strings.Join(manga.AltTitles, ", "))
+	}
+	if len(manga.Authors) > 0 {
+		fmt.Fprintf(&sb, "✍️ Authors: %s\n", strings.Join(manga.Authors, ", "))
+	}
+	if len(manga.Genres) > 0 {
+		fmt.Fprintf(&sb, "🎭 Genres: %s\n", strings.Join(manga.Genres, ", "))
+	}
+	if manga.Status != model.StatusUnknown {
+		fmt.Fprintf(&sb, "📌 Status: %s\n", manga.Status)
+	}
+	if manga.LastChapter != nil {
+		fmt.Fprintf(&sb, "📖 Latest Chapter: %s\n📅 Released: %s\n",
+			manga.LastChapter.Title, formatReleaseDate(manga.LastChapter.ReleasedAt))
+	}
+
+	const ending = "\nWhat would you like to do?"
+	if manga.Description != "" {
+		// telegram counts the characters in UTF-16, like the emojis of the text
+		room := maxCaptionLength - utf16Length(sb.String()) - utf16Length(ending) - 2
+		sb.WriteString("\n" + truncate(manga.Description, room) + "\n")
+	}
+	sb.WriteString(ending)
+	return sb.String()
+}
+
+// mergeDetails copies the metadata scraped from the page of the manga in the manga found with the search
+func mergeDetails(manga *model.Manga, details model.Manga) {
+	manga.CoverUrl = details.CoverUrl
+	manga.Authors = details.Authors
+	manga.Genres = details.Genres
+	manga.Status = details.Status
+	manga.AltTitles = details.AltTitles
+	manga.Description = details.Description
+}
+
+// the links of the shared mangas open the chat with the bot sending /start add_<key>
+const deepLinkAdd = "add_"
+
+// shareKey is the key of the manga in the links of the shared messages. Telegram allows
+// only 64 characters after /start, the url is replaced by the beginning of its hash
+func shareKey(mangaURL string) string {
+	sum := sha256.Sum256([]byte(mangaURL))
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// deepLink opens the private chat with the bot, which receives /start payload
+func deepLink(username string, payload string) string {
+	return fmt.Sprintf("https://t.me/%s?start=%s", username, payload)
+}
+
+// inlineArticle is the result of an inline query for the manga. Sending it in a chat
+// shares the manga with the buttons for subscribing and reading it online
+func inlineArticle(manga model.Manga, key string, username string) *models.InlineQueryResultArticle {
+	var keyboard [][]models.InlineKeyboardButton
+	if username != "" {
+		keyboard = append(keyboard, []models.InlineKeyboardButton{{Text: "Subscribe", URL: deepLink(username, deepLinkAdd+key)}})
+	}
+	keyboard = append(keyboard, []models.InlineKeyboardButton{{Text: "Read online", URL: manga.Url}})
+
+	return &models.InlineQueryResultArticle{
+		ID:                  key,
+		Title:               mangaButtonText(manga),
+		Description:         inlineDescription(manga),
+		ThumbnailURL:        manga.CoverUrl,
+		InputMessageContent: &models.InputTextMessageContent{MessageText: sharedMangaText(manga)},
+		ReplyMarkup:         &models.InlineKeyboardMarkup{InlineKeyboard: keyboard},
+	}
+}
+
+// inlineDescription is shown under the title in the list of the inline results
+func inlineDescription(manga model.Manga) string {
+	if manga.LastChapter == nil {
+		return "Latest chapter not found"
+	}
+	return fmt.Sprintf("Latest: %s, %s", manga.LastChapter.Title, formatReleaseDate(manga.LastChapter.ReleasedAt))
+}
+
+// sharedMangaText is the message sent in the chat when a result of the inline mode is chosen
+func sharedMangaText(manga model.Manga) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📚 %s\n", manga.Title)
+	if len(manga.Authors) > 0 {
+		fmt.Fprintf(&sb, "✍️ Authors: %s\n", strings.Join(manga.Authors, ", "))
+	}
+	if len(manga.Genres) > 0 {
+		fmt.Fprintf(&sb, "🎭 Genres: %s\n", strings.Join(manga.Genres, ", "))
+	}
+	if manga.LastChapter != nil {
+		fmt.Fprintf(&sb, "📖 Latest Chapter: %s\n", manga.LastChapter.Title)
+	}
+	if manga.Source != "" {
+		fmt.Fprintf(&sb, "🔗 Read it on %s", manga.Source)
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// inlineCache keeps the mangas answered to the inline queries with their details, by url.
+// Every key typed by the user is a new query, often with the same mangas. It also limits
+// the calls to the scraper, which are shared with the updater and the commands
+type inlineCache struct {
+	mu     sync.Mutex
+	mangas map[string]inlineManga
+	slots  chan struct{}
+}
+
+type inlineManga struct {
+	manga     model.Manga
+	expiresAt time.Time
+}
+
+func newInlineCache(scrapers int) *inlineCache {
+	return &inlineCache{mangas: make(map[string]inlineManga), slots: make(chan struct{}, scrapers)}
+}
+
+// the mangas of the inline queries of all the chats
+var inlineMangas = newInlineCache(inlineScrapers)
+
+// get returns the manga if it is kept and not expired
+func (c *inlineCache) get(url string) (model.Manga, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m, ok := c.mangas[url]
+	if !ok || time.Now().After(m.expiresAt) {
+		return model.Manga{}, false
+	}
+	return m.manga, true
+}
+
+// put keeps the manga for inlineCacheTime, the expired ones are forgotten
+func (c *inlineCache) put(manga model.Manga) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for url, m := range c.mangas {
+		if now.After(m.expiresAt) {
+			delete(c.mangas, url)
+		}
+	}
+	c.mangas[manga.Url] = inlineManga{manga: manga, expiresAt: now.Add(inlineCacheTime)}
+}
+
+// acquire waits for a free call to the scraper, false if ctx is done first
+func (c *inlineCache) acquire(ctx context.Context) bool {
+	select {
+	case c.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (c *inlineCache) release() {
+	<-c.slots
+}
+
+// answerInlineQuery sends the results of the inline query, none shows an empty list.
+// The same query of every user has the same results, telegram caches them
+func answerInlineQuery(ctx context.Context, b *bot.Bot, query *models.InlineQuery, results []models.InlineQueryResult) {
+	if results == nil {
+		results = []models.InlineQueryResult{} // telegram wants an array, not null
+	}
+	_, err := b.AnswerInlineQuery(ctx, &bot.AnswerInlineQueryParams{
+		InlineQueryID: query.ID,
+		Results:       results,
+		CacheTime:     300,
+	})
+	if err != nil {
+		logger.Log.Errorw("Error answering inline query", "error", err, "userId", query.From.ID)
+	}
+}
+
+// truncate cuts the text to max characters, ending it with "…".
+// The characters are counted in UTF-16, as telegram does
+func truncate(text string, max int) string {
+	if utf16Length(text) <= max {
+		return text
+	}
+	if max <= 1 {
+		return ""
+	}
+	length := 0
+	for i, r := range text {
+		length += utf16.RuneLen(r)
+		if length > max-1 {
+			return strings.TrimSpace(text[:i]) + "…"
+		}
+	}
+	return text
+}
+
+// newChaptersMessage lists the chapters released, from the oldest
+func newChaptersMessage(manga model.Manga, chapters []model.Chapter) string {
+	if len(chapters) == 1 {
+		ch := chapters[0]
+		return fmt.Sprintf("NEW CHAPTER RELEASED\n%s\n%s\n%s\n%s\n", manga.Title, ch.Title, formatReleaseDate(ch.ReleasedAt), ch.Url)
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d NEW CHAPTERS RELEASED\n%s\n", len(chapters), manga.Title)
+	for _, ch := range chapters {
+		fmt.Fprintf(&sb, "\n%s - %s\n%s\n", ch.Title, formatReleaseDate(ch.ReleasedAt), ch.Url)
+	}
+	return sb.String()
+}
+
+// downloads the pages of the chapters, shared by all the chats
+var chapterDownloader = downloader.NewDownloaderDefault()
+
+// sendChapter downloads the pages of the chapter and sends them to the chat with the settings of the user
+func sendChapter(ctx context.Context, b *bot.Bot, chatID int64, s scraper.Scraper, manga model.Manga, ch model.Chapter, settings downloadSettings) error {
+	scrapeCtx, cancel := context.WithTimeout(ctx, scraperTimeout)
+	defer cancel()
+	imgUrls, err := s.FindImgUrlsOfChapter(scrapeCtx, ch.Url)
+	if err != nil {
+		return fmt.Errorf("could not get the pages of %s: %w", ch.Url, err)
+	}
+
+	docTitle := fmt.Sprintf("%s-%s", manga.Title, ch.Title)
+	format := settings.format
+	docs, err := chapterDownloader.WithReferer(ch.Url).WithProfile(settings.profile).WithWebtoon(settings.webtoon).
+		DownloadChapter(ctx, imgUrls, format, docTitle, downloader.NewComicInfo(manga, ch, settings.webtoon))
+	if err != nil {
+		return fmt.Errorf("could not construct the %s: %w", format, err)
+	}
+	logger.Log.Infow("chapter downloaded", "title", docTitle, "format", format, "profile", settings.profile.Name, "parts", len(docs))
+
+	// a chapter too big for telegram is sent in parts, one after the other
+	for i, doc := range docs {
+		params := &bot.SendDocumentParams{
+			ChatID: chatID,
+			Document: &models.InputFileUpload{
+				Filename: doc.Name,
+				Data:     bytes.NewReader(doc.Data),
+			},
+		}
+		if len(docs) > 1 {
+			params.Caption = fmt.Sprintf("%s - part %d of %d", docTitle, i+1, len(docs))
+		}
+		if _, err := b.SendDocument(ctx, params); err != nil {
+			return fmt.Errorf("could not send %s: %w", doc.Name, err)
+		}
+		logger.Log.Infow("document sent successfully", "name", doc.Name, "sizeBytes", len(doc.Data), "chat_id", chatID)
+	}
+	return nil
+}
+
+// downloadSettings are the choices of the user for the downloaded chapters
+type downloadSettings struct {
+	format  model.DownloadFormat
+	profile downloader.Profile
+	webtoon bool
+}
+
+// userSettings returns the settings chosen by the user, a pdf of the original pages if the user never chose
+func userSettings(userRepo repository.UserRepo, chatID model.ChatID) downloadSettings {
+	settings := downloadSettings{format: model.FormatPDF, profile: downloader.OriginalProfile}
+	usr, err := userRepo.FindUserByChatID(chatID)
+	if err != nil || usr == nil {
+		return settings
+	}
+	if usr.Format != "" {
+		settings.format = usr.Format
+	}
+	if profile, ok := downloader.ProfileByName(usr.Profile); ok {
+		settings.profile = profile
+	}
+	settings.webtoon = usr.Webtoon
+	return settings
+}
+
+// editMessage replaces the text of a message sent by the bot, like a progress message
+func editMessage(ctx context.Context, b *bot.Bot, msg *models.Message, text string) {
+	if msg == nil {
+		return
+	}
+	_, err := b.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:    msg.Chat.ID,
+		MessageID: msg.ID,
+		Text:      text,
+	})
+	if err != nil {
+		logger.Log.Errorw("Error editing message", "error", err, "chatId", msg.Chat.ID)
 	}
 }
 
@@ -79,25 +528,233 @@ func removeKeyboardFromUser(ctx context.Context, b *bot.Bot, chatID int64, messa
 	}
 }
 
-// Helper function to create manga keyboard
-func createMangaKeyboard(mangas []model.Manga) [][]models.KeyboardButton {
-	var keyboard [][]models.KeyboardButton
-	for _, manga := range mangas {
-		row := []models.KeyboardButton{
-			{Text: manga.Title},
+// the callback data of the inline keyboards is the prefix followed by the argument,
+// telegram allows at most 64 bytes so the mangas are referred by their index.
+// The buttons of a conversation have the nonce of the session after the prefix, like add:1f2e3d4c:2
+const (
+	callbackAdd    = "add:"    // index of the manga in the search results
+	callbackAction = "action:" // CommandManga
+	callbackRemove = "remove:" // index of the manga in the subscriptions
+	callbackList   = "list:"   // only the pages of /list
+)
+
+// newNonce returns the nonce of a new session. The buttons of the older messages of the chat
+// have another one, so they are not taken for the buttons of the conversation in progress
+func newNonce() string {
+	nonce := make([]byte, 4)
+	_, _ = rand.Read(nonce)
+	return hex.EncodeToString(nonce)
+}
+
+// callbackPrefix is the start of the callback data of the buttons sent in the session with the nonce
+func callbackPrefix(prefix string, nonce string) string {
+	return prefix + nonce + ":"
+}
+
+// the long lists of mangas are shown in pages
+const mangasPerPage = 10
+
+// the callback data of the buttons for changing page is the prefix followed by p and the page, like add:p2
+const callbackPage = "p"
+
+// createMangaKeyboard returns a button for every manga of the page, the callback data is the prefix followed by the index.
+// Below them there are the buttons for the previous and the next page
+func createMangaKeyboard(mangas []model.Manga, prefix string, page int) *models.InlineKeyboardMarkup {
+	var keyboard [][]models.InlineKeyboardButton
+	from, to := pageBounds(len(mangas), page)
+	for i := from; i < to; i++ {
+		row := []models.InlineKeyboardButton{
+			{Text: mangaButtonText(mangas[i]), CallbackData: prefix + strconv.Itoa(i)},
 		}
 		keyboard = append(keyboard, row)
 	}
-	return keyboard
+	if row := pageButtons(prefix, page, pageCount(len(mangas))); row != nil {
+		keyboard = append(keyboard, row)
+	}
+	return &models.InlineKeyboardMarkup{InlineKeyboard: keyboard}
+}
+
+// pageCount is the number of pages of a list of n mangas, at least one
+func pageCount(n int) int {
+	return max(1, (n+mangasPerPage-1)/mangasPerPage)
+}
+
+// pageBounds returns the indexes of the first and after the last manga of the page
+func pageBounds(n int, page int) (int, int) {
+	from := min(page*mangasPerPage, n)
+	return from, min(from+mangasPerPage, n)
+}
+
+// pageButtons returns the buttons for the previous and the next page, nil when there is only one page
+func pageButtons(prefix string, page int, pages int) []models.InlineKeyboardButton {
+	if pages <= 1 {
+		return nil
+	}
+	var row []models.InlineKeyboardButton
+	if page > 0 {
+		row = append(row, models.InlineKeyboardButton{Text: "« Prev", CallbackData: prefix + callbackPage + strconv.Itoa(page-1)})
+	}
+	if page < pages-1 {
+		row = append(row, models.InlineKeyboardButton{Text: "Next »", CallbackData: prefix + callbackPage + strconv.Itoa(page+1)})
+	}
+	return row
+}
+
+// callbackPageNumber reads the page of a button for changing page, false when the data is not of such a button
+func callbackPageNumber(data string, prefix string, pages int) (int, bool) {
+	number, ok := strings.CutPrefix(data, prefix+callbackPage)
+	if !ok {
+		return 0, false
+	}
+	page, err := strconv.Atoi(number)
+	if err != nil || page < 0 || page >= pages {
+		return 0, false
+	}
+	return page, true
+}
+
+// the same title can be found in more sources, the source makes the button unique
+func mangaButtonText(manga model.Manga) string {
+	if manga.Source == "" {
+		return manga.Title
+	}
+	return fmt.Sprintf("%s [%s]", manga.Title, manga.Source)
 }
 
 // Helper function to create action keyboard
-func createActionKeyboard() [][]models.KeyboardButton {
-	return [][]models.KeyboardButton{
-		{{Text: string(Download)}},
-		{{Text: string(ReadOnline)}},
-		{{Text: string(DoNothing)}},
+func createActionKeyboard(nonce string) *models.InlineKeyboardMarkup {
+	prefix := callbackPrefix(callbackAction, nonce)
+	button := func(text string, command CommandManga) []models.InlineKeyboardButton {
+		return []models.InlineKeyboardButton{{Text: text, CallbackData: prefix + string(command)}}
+	}
+	return &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{
+		button("Download", Download),
+		button("Read Online", ReadOnline),
+		button("Do Nothing", DoNothing),
+	}}
+}
+
+// callbackIndex reads the index of the callback data, which must be lower than n
+func callbackIndex(data string, prefix string, n int) (int, error) {
+	idx, err := strconv.Atoi(strings.TrimPrefix(data, prefix))
+	if err != nil || !strings.HasPrefix(data, prefix) {
+		return 0, fmt.Errorf("invalid callback data %q", data)
+	}
+	if idx < 0 || idx >= n {
+		return 0, fmt.Errorf("index of callback data %q out of range", data)
+	}
+	return idx, nil
+}
+
+// callbackChatID is the chat of the message with the button. Without the message,
+// which is too old, the chat is the private chat with the user
+func callbackChatID(query *models.CallbackQuery) model.ChatID {
+	if msg := query.Message.Message; msg != nil {
+		return model.ChatID(msg.Chat.ID)
+	}
+	return model.ChatID(query.From.ID)
+}
+
+// answerCallback stops the loading animation of the button, the text is shown as a notification
+func answerCallback(ctx context.Context, b *bot.Bot, query *models.CallbackQuery, text string) {
+	_, err := b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+		CallbackQueryID: query.ID,
+		Text:            text,
+	})
+	if err != nil {
+		logger.Log.Errorw("Error answering callback query", "error", err, "userId", query.From.ID)
+	}
+}
+
+// editCallbackMessage replaces the message with the pressed button. A nil keyboard removes the buttons
+func editCallbackMessage(ctx context.Context, b *bot.Bot, query *models.CallbackQuery, text string, keyboard *models.InlineKeyboardMarkup) {
+	msg := query.Message.Message
+	if msg == nil {
+		return
+	}
+	params := &bot.EditMessageTextParams{
+		ChatID:    msg.Chat.ID,
+		MessageID: msg.ID,
+		Text:      text,
+	}
+	if keyboard != nil {
+		params.ReplyMarkup = keyboard
+	}
+	if _, err := b.EditMessageText(ctx, params); err != nil {
+		logger.Log.Errorw("Error editing message", "error", err, "chatId", msg.Chat.ID)
+	}
+}
+
+// editCallbackKeyboard replaces the buttons of the message with the pressed button, the text does not change
+func editCallbackKeyboard(ctx context.Context, b *bot.Bot, query *models.CallbackQuery, keyboard *models.InlineKeyboardMarkup) {
+	msg := query.Message.Message
+	if msg == nil {
+		return
+	}
+	_, err := b.EditMessageReplyMarkup(ctx, &bot.EditMessageReplyMarkupParams{
+		ChatID:      msg.Chat.ID,
+		MessageID:   msg.ID,
+		ReplyMarkup: keyboard,
+	})
+	if err != nil {
+		logger.Log.Errorw("Error editing keyboard", "error", err, "chatId", msg.Chat.ID)
+	}
+}
+
+// chapterRange is the chapters requested with /download, From and To included
+type chapterRange struct {
+	From float64
+	To   float64
+}
+
+var chapterRangeRegex = regexp.MustCompile(`^(\d+(?:\.\d+)?)(?:-(\d+(?:\.\d+)?))?$`)
+
+// parseDownloadArgs splits "Berserk 100-105" in the name of the manga and the chapters.
+// Without chapters the range is nil, meaning the latest chapter
+func parseDownloadArgs(args string) (string, *chapterRange, error) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return "", nil, errors.New("manga name is missing")
+	}
+
+	last := fields[len(fields)-1]
+	match := chapterRangeRegex.FindStringSubmatch(last)
+	if match == nil || len(fields) == 1 {
+		return strings.Join(fields, " "), nil, nil
+	}
+
+	from, _ := strconv.ParseFloat(match[1], 64)
+	to := from
+	if match[2] != "" {
+		to, _ = strconv.ParseFloat(match[2], 64)
+	}
+	if from > to {
+		return "", nil, fmt.Errorf("invalid range %s", last)
+	}
+	return strings.Join(fields[:len(fields)-1], " "), &chapterRange{From: from, To: to}, nil
+}
+
+// selectChapters returns the chapters in the range from the oldest, once per number.
+// With a nil range only the most recent chapter is returned
+func selectChapters(chapters []model.Chapter, r *chapterRange) []model.Chapter {
+	if r == nil {
+		if len(chapters) == 0 {
+			return nil
+		}
+		return chapters[:1]
+	}
+
+	var selected []model.Chapter
+	seen := make(map[float64]bool)
+	for _, ch := range chapters {
+		if ch.Number == model.NoChapterNumber || ch.Number < r.From || ch.Number > r.To || seen[ch.Number] {
+			continue
+		}
+		seen[ch.Number] = true
+		selected = append(selected, ch)
 	}
+	slices.SortStableFunc(selected, func(a, b model.Chapter) int { return cmp.Compare(a.Number, b.Number) })
+	return selected
 }
 
 // /add One Piece => One Piece
@@ -1,6 +1,18 @@
 package telegram
 
-import "testing"
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/akarakai/gomanga-tbot/pkg/logger"
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+	"github.com/akarakai/gomanga-tbot/pkg/scraper"
+	"go.uber.org/zap"
+)
 
 func TestParseMessage(t *testing.T) {
 	t.Run("good commands", func(t *testing.T) {
@@ -45,3 +57,168 @@ func TestParseMessage(t *testing.T) {
 		}
 	})
 }
+
+func TestWithRetry(t *testing.T) {
+	logger.Log = zap.NewNop().Sugar()
+	retryBackoff = time.Millisecond
+
+	t.Run("RetryableErrors", func(t *testing.T) {
+		calls := 0
+		err := withRetry(context.Background(), func(ctx context.Context) error {
+			calls++
+			return fmt.Errorf("%w: status 503", scraper.ErrNetwork)
+		})
+		if err == nil {
+			t.Error("Expected error, got nil")
+		}
+		if calls != scraperAttempts {
+			t.Errorf("Expected %d calls, got %d", scraperAttempts, calls)
+		}
+	})
+
+	t.Run("SucceedsAfterRetry", func(t *testing.T) {
+		calls := 0
+		err := withRetry(context.Background(), func(ctx context.Context) error {
+			calls++
+			if calls == 1 {
+				return fmt.Errorf("%w: status 429", scraper.ErrRateLimited)
+			}
+			return nil
+		})
+		if err != nil || calls != 2 {
+			t.Errorf("Expected success at the second call, got %v after %d calls", err, calls)
+		}
+	})
+
+	t.Run("NotFoundIsNotRetried", func(t *testing.T) {
+		calls := 0
+		err := withRetry(context.Background(), func(ctx context.Context) error {
+			calls++
+			return fmt.Errorf("%w: status 404", scraper.ErrNotFound)
+		})
+		if !isNotFound(err) || calls != 1 {
+			t.Errorf("Expected one call and not found error, got %v after %d calls", err, calls)
+		}
+	})
+}
+
+func TestMangaInfoText(t *testing.T) {
+	manga := model.Manga{
+		Title:       "Berserk",
+		Authors:     []string{"Miura Kentarou"},
+		Genres:      []string{"Action", "Horror"},
+		Status:      model.StatusHiatus,
+		Description: strings.Repeat("Guts is out for revenge. ", 100),
+		LastChapter: &model.Chapter{Title: "Chapter 376", ReleasedAt: time.Now()},
+	}
+	text := mangaInfoText(manga)
+	if n := utf16Length(text); n > maxCaptionLength {
+		t.Errorf("Caption is %d characters, max is %d", n, maxCaptionLength)
+	}
+	// the emojis are 2 characters for telegram
+	emojis := manga
+	emojis.Description = strings.Repeat("Guts 🗡️ is out for revenge 🔥. ", 100)
+	if n := utf16Length(mangaInfoText(emojis)); n > maxCaptionLength {
+		t.Errorf("Caption with emojis is %d characters, max is %d", n, maxCaptionLength)
+	}
+	for _, expected := range []string{"Berserk", "Miura Kentarou", "Action, Horror", "hiatus", "Chapter 376", "…"} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("Expected %q in %q", expected, text)
+		}
+	}
+
+	// without metadata only the title and the chapter are shown
+	text = mangaInfoText(model.Manga{Title: "Vagabond", LastChapter: manga.LastChapter})
+	if strings.Contains(text, "Genres") || strings.Contains(text, "Status") {
+		t.Errorf("Unexpected metadata in %q", text)
+	}
+}
+
+func TestParseDownloadArgs(t *testing.T) {
+	tests := []struct {
+		args  string
+		name  string
+		chaps *chapterRange
+	}{
+		{"Berserk", "Berserk", nil},
+		{"Berserk 100", "Berserk", &chapterRange{From: 100, To: 100}},
+		{"Berserk 100-105", "Berserk", &chapterRange{From: 100, To: 105}},
+		{"one piece  10.5", "one piece", &chapterRange{From: 10.5, To: 10.5}},
+		{"20th century boys", "20th century boys", nil},
+		{"100", "100", nil},
+	}
+	for _, tt := range tests {
+		name, chaps, err := parseDownloadArgs(tt.args)
+		if err != nil {
+			t.Fatalf("parseDownloadArgs(%q): %v", tt.args, err)
+		}
+		if name != tt.name {
+			t.Errorf("parseDownloadArgs(%q) name = %q, want %q", tt.args, name, tt.name)
+		}
+		if (chaps == nil) != (tt.chaps == nil) || (chaps != nil && *chaps != *tt.chaps) {
+			t.Errorf("parseDownloadArgs(%q) range = %v, want %v", tt.args, chaps, tt.chaps)
+		}
+	}
+
+	for _, args := range []string{"", "  ", "Berserk 105-100"} {
+		if _, _, err := parseDownloadArgs(args); err == nil {
+			t.Errorf("parseDownloadArgs(%q) should fail", args)
+		}
+	}
+}
+
+func TestSelectChapters(t *testing.T) {
+	// newest first, like the scrapers return them
+	chapters := []model.Chapter{
+		{Title: "Chapter 4", Number: 4},
+		{Title: "Chapter 3.5", Number: 3.5},
+		{Title: "Chapter 3", Number: 3},
+		{Title: "Chapter 3 (reupload)", Number: 3},
+		{Title: "Extra", Number: model.NoChapterNumber},
+		{Title: "Chapter 1", Number: 1},
+	}
+
+	latest := selectChapters(chapters, nil)
+	if len(latest) != 1 || latest[0].Title != "Chapter 4" {
+		t.Errorf("latest chapter = %v", latest)
+	}
+
+	var titles []string
+	for _, ch := range selectChapters(chapters, &chapterRange{From: 1, To: 3.5}) {
+		titles = append(titles, ch.Title)
+	}
+	if got, want := strings.Join(titles, ", "), "Chapter 1, Chapter 3, Chapter 3.5"; got != want {
+		t.Errorf("selected chapters = %s, want %s", got, want)
+	}
+
+	if got := selectChapters(chapters, &chapterRange{From: 10, To: 20}); len(got) != 0 {
+		t.Errorf("selected chapters out of range = %v", got)
+	}
+	if got := selectChapters(nil, nil); got != nil {
+		t.Errorf("latest chapter of empty list = %v", got)
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	paragraph := strings.Repeat("a", 30)
+	text := strings.Repeat(paragraph+"\n\n", 9) + paragraph // 10 paragraphs of 32 characters
+	chunks := splitMessage(text, 100)
+	if len(chunks) != 4 {
+		t.Fatalf("Expected 4 chunks, got %d: %q", len(chunks), chunks)
+	}
+	for _, chunk := range chunks {
+		if len(chunk) > 100 || strings.HasPrefix(chunk, "\n") || strings.HasSuffix(chunk, "\n") {
+			t.Errorf("Chunk not cut between paragraphs: %q", chunk)
+		}
+	}
+
+	// without spaces the text is cut anyway, the emojis count as two characters like in telegram
+	chunks = splitMessage(strings.Repeat("😀", 60), 100)
+	if len(chunks) != 2 || utf8.RuneCountInString(chunks[0]) != 50 || utf8.RuneCountInString(chunks[1]) != 10 {
+		t.Errorf("Unexpected chunks %q", chunks)
+	}
+
+	if chunks := splitMessage("short", 100); len(chunks) != 1 || chunks[0] != "short" {
+		t.Errorf("Unexpected chunks %q", chunks)
+	}
+}
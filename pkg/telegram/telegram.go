@@ -14,26 +14,44 @@ import (
 type Service struct {
 	bot     *bot.Bot
 	db      repository.Database
-	scraper scraper.Scraper
+	sources *scraper.Registry
+	add     *Conversation[addSession]
+	remove  *Conversation[removeSession]
+	// username of the bot, for the links to its chat. Empty when telegram did not tell it
+	username string
 }
 
-func NewTelegramService(apiKey string, db repository.Database, scraper scraper.Scraper) (*Service, error) {
-	b, err := bot.New(apiKey)
+// NewTelegramService creates the bot. Each manga is scraped by the source of the registry owning its url
+func NewTelegramService(apiKey string, db repository.Database, sources *scraper.Registry) (*Service, error) {
+	t := &Service{
+		db:      db,
+		sources: sources,
+		add:     newAddConversation(db, sources),
+		remove:  newRemoveConversation(db),
+	}
+	b, err := bot.New(apiKey, bot.WithDefaultHandler(newDefaultHandler(t.conversations())))
 	if err != nil {
 		return nil, err
 	}
-	return &Service{
-		bot:     b,
-		db:      db,
-		scraper: scraper,
-	}, nil
+	t.bot = b
+	return t, nil
+}
+
+// conversations are all the commands with more steps
+func (t *Service) conversations() []conversationHandler {
+	return []conversationHandler{t.add, t.remove}
 }
 
 func (t *Service) Start(ctx context.Context) {
+	if me, err := t.bot.GetMe(ctx); err != nil {
+		logger.Log.Errorw("could not get the username of the bot, the shared mangas will not have the subscribe button", "err", err)
+	} else {
+		t.username = me.Username
+	}
 
 	t.bot.RegisterHandler(bot.HandlerTypeMessageText, "start", bot.MatchTypeCommand,
 		func(ctx context.Context, bot *bot.Bot, update *models.Update) {
-			startHandler(ctx, bot, update, t.db.GetUserRepo())
+			startHandler(ctx, bot, update, t.db, t.add, t.sources)
 		})
 
 	t.bot.RegisterHandler(bot.HandlerTypeMessageText, "list", bot.MatchTypeCommand,
@@ -52,31 +70,85 @@ func (t *Service) Start(ctx context.Context) {
 
 	t.bot.RegisterHandler(bot.HandlerTypeMessageText, "add", bot.MatchTypeCommand,
 		func(ctx context.Context, bot *bot.Bot, update *models.Update) {
-			addHandler(ctx, bot, update, t.db, t.scraper)
+			addHandler(ctx, bot, update, t.add, t.sources)
+		})
+
+	t.bot.RegisterHandler(bot.HandlerTypeMessageText, "remove", bot.MatchTypeCommand,
+		func(ctx context.Context, bot *bot.Bot, update *models.Update) {
+			removeHandler(ctx, bot, update, t.remove, t.db.GetMangaRepo())
+		})
+
+	t.bot.RegisterHandler(bot.HandlerTypeMessageText, "download", bot.MatchTypeCommand,
+		func(ctx context.Context, bot *bot.Bot, update *models.Update) {
+			downloadHandler(ctx, bot, update, t.db, t.sources)
+		})
 
+	t.bot.RegisterHandler(bot.HandlerTypeMessageText, "format", bot.MatchTypeCommand,
+		func(ctx context.Context, bot *bot.Bot, update *models.Update) {
+			formatHandler(ctx, bot, update, t.db.GetUserRepo())
 		})
 
-	t.bot.RegisterHandler(bot.HandlerTypeMessageText, "cancel", bot.MatchTypeCommand, cancelHandler)
+	t.bot.RegisterHandler(bot.HandlerTypeMessageText, "profile", bot.MatchTypeCommand,
+		func(ctx context.Context, bot *bot.Bot, update *models.Update) {
+			profileHandler(ctx, bot, update, t.db.GetUserRepo())
+		})
 
-	t.bot.RegisterHandler(bot.HandlerTypeMessageText, "", bot.MatchTypeContains,
+	t.bot.RegisterHandler(bot.HandlerTypeMessageText, "webtoon", bot.MatchTypeCommand,
 		func(ctx context.Context, bot *bot.Bot, update *models.Update) {
-			conversationHandler(ctx, bot, update, t.db, t.scraper)
+			webtoonHandler(ctx, bot, update, t.db.GetUserRepo())
+		})
+
+	t.bot.RegisterHandler(bot.HandlerTypeMessageText, "cancel", bot.MatchTypeCommand,
+		func(ctx context.Context, bot *bot.Bot, update *models.Update) {
+			cancelHandler(ctx, bot, update, t.conversations())
+		})
+
+	// the buttons of the inline keyboards, pressed during a conversation
+	t.bot.RegisterHandler(bot.HandlerTypeCallbackQueryData, callbackAdd, bot.MatchTypePrefix,
+		conversationCallback(t.add, addExpired))
+
+	t.bot.RegisterHandler(bot.HandlerTypeCallbackQueryData, callbackAction, bot.MatchTypePrefix,
+		conversationCallback(t.add, actionExpired))
+
+	t.bot.RegisterHandler(bot.HandlerTypeCallbackQueryData, callbackRemove, bot.MatchTypePrefix,
+		conversationCallback(t.remove, removeExpired))
+
+	t.bot.RegisterHandler(bot.HandlerTypeCallbackQueryData, callbackList, bot.MatchTypePrefix,
+		func(ctx context.Context, bot *bot.Bot, update *models.Update) {
+			listPageCallback(ctx, bot, update, t.db.GetMangaRepo())
+		})
+
+	// @gomangabot <manga name> from any chat
+	t.bot.RegisterHandlerMatchFunc(func(update *models.Update) bool { return update.InlineQuery != nil },
+		func(ctx context.Context, bot *bot.Bot, update *models.Update) {
+			inlineQueryHandler(ctx, bot, update, t.db.GetShareRepo(), t.sources, t.username)
 		})
 
 	logger.Log.Infof("starting the bot")
 
-	t.schedule(time.Now().Add(1*time.Minute), time.Hour*1, func() {
-		updater(ctx, t.bot, t.db, t.scraper)
+	t.schedule(ctx, time.Now().Add(1*time.Minute), time.Hour*1, func() {
+		updater(ctx, t.bot, t.db, t.sources)
+	})
+
+	// the conversations abandoned by the users are deleted, the ones expired while the bot was down too
+	t.schedule(ctx, time.Now(), conversationTTL, func() {
+		for _, conv := range t.conversations() {
+			if n := conv.Expire(); n > 0 {
+				logger.Log.Infow("expired conversations deleted", "count", n)
+			}
+		}
 	})
 
 	t.bot.Start(ctx)
 }
 
-func (t *Service) schedule(startTime time.Time, every time.Duration, f func()) {
+// schedule runs f at startTime and then every interval, until ctx is done
+func (t *Service) schedule(ctx context.Context, startTime time.Time, every time.Duration, f func()) {
 	go func() {
-		now := time.Now()
-		if startTime.After(now) {
-			time.Sleep(startTime.Sub(now))
+		select {
+		case <-time.After(time.Until(startTime)):
+		case <-ctx.Done():
+			return
 		}
 
 		f()
@@ -85,8 +157,12 @@ func (t *Service) schedule(startTime time.Time, every time.Duration, f func()) {
 		defer ticker.Stop()
 
 		for {
-			<-ticker.C
-			f()
+			select {
+			case <-ticker.C:
+				f()
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 }
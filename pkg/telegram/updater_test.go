@@ -0,0 +1,164 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akarakai/gomanga-tbot/pkg/logger"
+	"github.com/akarakai/gomanga-tbot/pkg/model"
+	"github.com/akarakai/gomanga-tbot/pkg/repository"
+	"github.com/akarakai/gomanga-tbot/pkg/scraper"
+	"go.uber.org/zap"
+)
+
+const berserkURL = "https://weebcentral.com/series/berserk"
+
+func testChapter(number int) model.Chapter {
+	return model.Chapter{
+		Title:      fmt.Sprintf("Chapter %d", number),
+		Url:        fmt.Sprintf("https://weebcentral.com/chapters/berserk-%d", number),
+		Number:     float64(number),
+		ReleasedAt: time.Date(2025, 1, number, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+// newUpdaterTest saves berserk up to the chapter last, followed by the user 1
+func newUpdaterTest(t *testing.T, last int) (*repository.Sqlite3Database, *fakeScraper) {
+	t.Helper()
+	logger.Log = zap.NewNop().Sugar()
+	db, err := repository.NewSqlite3Database(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSqlite3Database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	s := &fakeScraper{}
+	for i := 1; i <= last; i++ {
+		s.release(berserkURL, testChapter(i))
+	}
+	lastCh := testChapter(last)
+	if err := db.MangaRepo.SaveManga(&model.Manga{Title: "Berserk", Url: berserkURL, LastChapter: &lastCh}); err != nil {
+		t.Fatalf("SaveManga: %v", err)
+	}
+	if err := db.UserRepo.SaveUser(1); err != nil {
+		t.Fatalf("SaveUser: %v", err)
+	}
+	if err := db.UserRepo.SaveManga(1, berserkURL); err != nil {
+		t.Fatalf("SaveManga of user: %v", err)
+	}
+	return db, s
+}
+
+func TestUpdaterNotifiesEveryNewChapter(t *testing.T) {
+	db, s := newUpdaterTest(t, 10)
+	b, api := newFakeBot(t)
+
+	s.release(berserkURL, testChapter(11), testChapter(12), testChapter(13))
+	updater(context.Background(), b, db, s)
+
+	msgs := api.messages(1)
+	if len(msgs) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(msgs))
+	}
+	text := msgs[0].Text()
+	if !strings.HasPrefix(text, "3 NEW CHAPTERS RELEASED") {
+		t.Errorf("Unexpected message %q", text)
+	}
+	// from the oldest
+	i11, i12, i13 := strings.Index(text, "Chapter 11"), strings.Index(text, "Chapter 12"), strings.Index(text, "Chapter 13")
+	if i11 < 0 || !(i11 < i12 && i12 < i13) {
+		t.Errorf("Chapters missing or not in order in %q", text)
+	}
+
+	for _, n := range []float64{11, 12, 13} {
+		if ch, _ := db.ChapterRepo.FindChapterByNumber(berserkURL, n); ch == nil {
+			t.Errorf("Chapter %v not recorded", n)
+		}
+	}
+	m, _ := db.MangaRepo.FindMangaByUrl(berserkURL)
+	if m.LastChapter == nil || m.LastChapter.Number != 13 {
+		t.Errorf("Expected last chapter 13, got %v", m.LastChapter)
+	}
+
+	// nothing new at the next run
+	api.reset()
+	updater(context.Background(), b, db, s)
+	if msgs := api.messages(1); len(msgs) != 0 {
+		t.Errorf("Expected no message, got %v", msgs)
+	}
+}
+
+func TestUpdaterSingleChapter(t *testing.T) {
+	db, s := newUpdaterTest(t, 10)
+	b, api := newFakeBot(t)
+
+	s.release(berserkURL, testChapter(11))
+	updater(context.Background(), b, db, s)
+
+	msgs := api.messages(1)
+	if len(msgs) != 1 || !strings.HasPrefix(msgs[0].Text(), "NEW CHAPTER RELEASED\nBerserk\nChapter 11") {
+		t.Fatalf("Unexpected messages %v", msgs)
+	}
+}
+
+func TestUpdaterManyChaptersAtOnce(t *testing.T) {
+	db, s := newUpdaterTest(t, 5)
+	b, api := newFakeBot(t)
+
+	// more than the chapters requested at every check
+	for i := 6; i <= 6+updaterChapters; i++ {
+		s.release(berserkURL, testChapter(i))
+	}
+	updater(context.Background(), b, db, s)
+
+	if len(s.calls) != 2 || s.calls[1] != scraper.AllChapters {
+		t.Errorf("Expected the full list to be requested, calls %v", s.calls)
+	}
+	msgs := api.messages(1)
+	if len(msgs) != 1 || !strings.HasPrefix(msgs[0].Text(), fmt.Sprintf("%d NEW CHAPTERS RELEASED", updaterChapters+1)) {
+		t.Fatalf("Unexpected messages %v", msgs)
+	}
+	chapters, _ := db.ChapterRepo.FindChaptersOfManga(berserkURL)
+	if len(chapters) != 6+updaterChapters {
+		t.Errorf("Expected %d chapters in the history, got %d", 6+updaterChapters, len(chapters))
+	}
+}
+
+func TestUpdaterNotSubscribedUser(t *testing.T) {
+	db, s := newUpdaterTest(t, 3)
+	b, api := newFakeBot(t)
+	if err := db.UserRepo.SaveUser(2); err != nil {
+		t.Fatalf("SaveUser: %v", err)
+	}
+
+	s.release(berserkURL, testChapter(4))
+	updater(context.Background(), b, db, s)
+
+	if msgs := api.messages(2); len(msgs) != 0 {
+		t.Errorf("User 2 is not subscribed, got %v", msgs)
+	}
+	if msgs := api.messages(1); len(msgs) != 1 {
+		t.Errorf("Expected 1 message for user 1, got %d", len(msgs))
+	}
+}
+
+func TestUpdaterSkipsChaptersInTheHistory(t *testing.T) {
+	db, s := newUpdaterTest(t, 10)
+	b, api := newFakeBot(t)
+
+	// the chapter 11 was already seen, but the last chapter was not updated
+	if _, err := db.ChapterRepo.SaveChapters(berserkURL, []model.Chapter{testChapter(11)}); err != nil {
+		t.Fatalf("SaveChapters: %v", err)
+	}
+	s.release(berserkURL, testChapter(11), testChapter(12))
+	updater(context.Background(), b, db, s)
+
+	msgs := api.messages(1)
+	if len(msgs) != 1 || !strings.HasPrefix(msgs[0].Text(), "NEW CHAPTER RELEASED\nBerserk\nChapter 12") {
+		t.Fatalf("Unexpected messages %v", msgs)
+	}
+}